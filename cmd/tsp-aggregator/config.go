@@ -1,14 +1,15 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 
 	"opentsp.org/internal/config"
 	"opentsp.org/internal/flag"
+	"opentsp.org/internal/pprof"
 	"opentsp.org/internal/relay"
 	"opentsp.org/internal/restart"
 	"opentsp.org/internal/tsdb/filter"
@@ -28,14 +29,18 @@ func load(path string) *Config {
 	}
 	cfg := new(Config)
 	config.Load(cfg, path, "tsp-aggregator?host={{.Hostname}}")
+	pprof.Handle("/debug/config", http.HandlerFunc(config.ServeHistory))
 	if flag.TestMode {
 		cfg.Dump(os.Stdout)
+		config.DumpSources(os.Stderr, cfg)
 		os.Exit(0)
 	}
 	go func() {
-		dummy := new(Config)
-		config.Next(dummy)
-		restartCause <- "config updated"
+		for {
+			next := new(Config)
+			config.Next(next)
+			configUpdate <- next
+		}
 	}()
 	return cfg
 }
@@ -43,8 +48,7 @@ func load(path string) *Config {
 func (c *Config) Reset() { *c = *defaultConfig }
 
 func (c *Config) Dump(w io.Writer) {
-	buf, _ := json.MarshalIndent(c, "", "\t")
-	fmt.Fprintln(w, string(buf))
+	config.Dump(w, c)
 }
 
 func (c *Config) Validate() error {
@@ -62,10 +66,41 @@ func (c *Config) Validate() error {
 	return nil
 }
 
-var restartCause = make(chan string)
+var (
+	restartCause = make(chan string)
+	configUpdate = make(chan *Config)
+)
 
-func Restart() {
-	cause := <-restartCause
+// Reload applies config updates received from the controller. The Filter,
+// Relay, and ListenAddr settings are applied live.
+func Reload(self *filter.Switch, relays *relay.Pool, server *Server) {
+	var cause string
+	for cause == "" {
+		select {
+		case cause = <-restartCause:
+		case next := <-configUpdate:
+			if err := apply(next, self, relays, server); err != nil {
+				cause = fmt.Sprintf("config updated: %v", err)
+			}
+		}
+	}
 	log.Printf("restarting... (%s)", cause)
 	restart.Do()
 }
+
+func apply(next *Config, self *filter.Switch, relays *relay.Pool, server *Server) error {
+	if err := server.Listen(next.ListenAddr); err != nil {
+		// Keep serving on the old address.
+		log.Printf("server: %v", err)
+	}
+	if err := self.Set(next.Filter); err != nil {
+		return err
+	}
+	if err := relays.Update(next.Relay); err != nil {
+		return err
+	}
+	cfg.Filter = next.Filter
+	cfg.Relay = next.Relay
+	log.Print("config updated")
+	return nil
+}
@@ -30,31 +30,32 @@ func init() {
 		filter.Debug = log.New(w, "debug: filter: ", 0)
 	}
 	log.Print("start pid=", os.Getpid())
-	go Restart()
 }
 
 func main() {
 	var (
-		remote = ListenAndServe(cfg.ListenAddr)
-		self   = SelfStats("tsp.aggregator.", cfg.Filter)
-		final  = tsdb.Join(remote, self)
-		relays = relay.NewPool(cfg.Relay, final)
+		server   = ListenAndServe(cfg.ListenAddr)
+		self, sw = SelfStats("tsp.aggregator.", cfg.Filter)
+		final    = tsdb.Join(server.C, self)
+		relays   = relay.NewPool(cfg.Relay, final)
 	)
+	go Reload(sw, relays, server)
 	relays.Broadcast()
 }
 
 // SelfStats is like stats.Self except the returned tsdb.Chan is filtered using
-// the given rules.
-func SelfStats(prefix string, rules []filter.Rule) tsdb.Chan {
-	var (
-		self     = stats.Self(prefix)
-		filtered = filter.Series(rules, self)
-		out      = make(chan *tsdb.Point)
-	)
+// the given rules. The rules may be replaced using the returned filter.Switch.
+func SelfStats(prefix string, rules []filter.Rule) (tsdb.Chan, *filter.Switch) {
+	self := stats.Self(prefix)
+	filtered, err := filter.NewSwitch(rules, self)
+	if err != nil {
+		log.Panicf("internal error: %v", err)
+	}
+	out := make(chan *tsdb.Point)
 	go func() {
 		for {
 			out <- filtered.Next()
 		}
 	}()
-	return out
+	return out, filtered
 }
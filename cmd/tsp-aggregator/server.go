@@ -7,11 +7,13 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"expvar"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"sync"
 	"time"
 
 	"opentsp.org/internal/tsdb"
@@ -26,39 +28,70 @@ var (
 	statServerCurrEstab = expvar.NewInt("server.CurrEstab")
 )
 
-func ListenAndServe(addr string) tsdb.Chan {
+// Server implements fan-in from forwarders and pollers.
+type Server struct {
+	C tsdb.Chan // received data points
+
+	ch       chan *tsdb.Point
+	mu       sync.Mutex
+	addr     string
+	listener net.Listener
+}
+
+// ListenAndServe starts a server listening on the given address.
+func ListenAndServe(addr string) *Server {
 	ch := make(chan *tsdb.Point, MaxQueue)
-	s := listen(addr)
+	s := &Server{
+		C:  ch,
+		ch: ch,
+	}
+	if err := s.Listen(addr); err != nil {
+		log.Fatal(err)
+	}
 	statQueue.Set("", expvar.Func(func() interface{} {
 		return len(ch)
 	}))
-	go s.loop(ch)
-	return ch
+	return s
 }
 
-func listen(addr string) *server {
+// Listen rebinds the server to the given address. Connections accepted on
+// the old address are not interrupted. If the address is unchanged, Listen
+// is a no-op. If the new address cannot be bound, the old one remains in
+// use.
+func (s *Server) Listen(addr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil && addr == s.addr {
+		return nil
+	}
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	return &server{l}
-}
-
-// server implements fan-in from forwarders and pollers.
-type server struct {
-	listener net.Listener
+	if s.listener != nil {
+		s.listener.Close()
+		log.Printf("server: stopped listening on %s", s.addr)
+	}
+	s.addr = addr
+	s.listener = l
+	log.Printf("server: listening on %s", addr)
+	go s.loop(l)
+	return nil
 }
 
-func (s *server) loop(w chan<- *tsdb.Point) {
+func (s *Server) loop(l net.Listener) {
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := l.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.Print(err)
 			time.Sleep(5 * time.Second)
 			continue
 		}
 		c := &serverConn{Conn: conn}
-		go c.loop(w)
+		go c.loop(s.ch)
 	}
 }
 
@@ -16,9 +16,14 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"opentsp.org/cmd/tsp-controller/config/network"
+	"opentsp.org/internal/tsdb"
+	"opentsp.org/internal/tsdb/filter"
 	"opentsp.org/internal/version"
 )
 
@@ -30,9 +35,16 @@ var Debug *log.Logger
 var (
 	FilePath    = flag.String("f", DefaultFilePath, "configuration file")
 	TestMode    = flag.Bool("t", false, "configuration test")
+	LintMode    = flag.Bool("lint", false, "report all configuration problems")
+	ViewMode    = flag.String("view", "", "print the view of program:host")
+	DumpMode    = flag.String("dump", "", "write the views of all hosts to directory")
 	VerboseMode = flag.Bool("v", false, "verbose mode")
 	ListenAddr  = flag.String("l", ":8084", "listen address")
 	VersionMode = flag.Bool("version", false, "echo version and exit")
+
+	CertFile     = flag.String("cert", "", "TLS certificate file")
+	KeyFile      = flag.String("key", "", "TLS private key file")
+	ClientCAFile = flag.String("ca", "", "TLS client certificate authorities file")
 )
 
 var modules []func(*Config) error
@@ -42,15 +54,21 @@ func Register(fn func(*Config) error) {
 	modules = append(modules, fn)
 }
 
-// Loaded contains the loaded configuration.
+// Loaded contains the configuration loaded at startup. See Watch for
+// subsequent reloads.
 var Loaded *Config
 
 // Config represents controller's entire configuration, i.e. the <config> block.
 type Config struct {
-	Hosts   *Hosts          `xml:"hostgroup"`
-	Network *network.Config `xml:"network"`
-	Filter  *Filter         `xml:"filter"`
-	Extra   []*Element      `xml:",any"`
+	Hosts     *Hosts          `xml:"hostgroup"`
+	Network   *network.Config `xml:"network"`
+	Filter    *Filter         `xml:"filter"`
+	Inventory []*Inventory    `xml:"inventory"`
+	Extra     []*Element      `xml:",any"`
+
+	// declared holds the hosts declared in the config file, if Hosts
+	// includes hosts supplied by inventories.
+	declared *Hosts
 }
 
 // UpdateFunc is registered by extension module to decode custom Host-level
@@ -107,6 +125,21 @@ func (c *Config) Host(id string) (*Host, error) {
 	return host, nil
 }
 
+// Ambiguous reports whether id is not the id of a host, but the short id of
+// several hosts. Host resolves such an id to no host.
+func (c *Config) Ambiguous(id string) bool {
+	n := 0
+	for _, registered := range c.Hosts.All {
+		if registered.ID == id {
+			return false
+		}
+		if registered.shortID() == id {
+			n++
+		}
+	}
+	return n > 1
+}
+
 // Cluster returns hosts with the given cluster id.
 func (c *Config) Cluster(id string) []*Host {
 	var found []*Host
@@ -153,12 +186,20 @@ func (d *decoder) Decode() (*Config, error) {
 		return nil, err
 	}
 	config.Hosts.tags = nil
+	config.Hosts.rules = nil
+	config.Hosts.labels = nil
+	if len(config.Inventory) == 0 {
+		config.Hosts.scopes = nil // needed only to add inventory hosts
+	}
 	if err := validateFilter(&config); err != nil {
 		return nil, err
 	}
 	if err := validateExtra(&config); err != nil {
 		return nil, err
 	}
+	if err := validateInventory(&config); err != nil {
+		return nil, err
+	}
 	if err := validateNetwork(&config); err != nil {
 		return nil, err
 	}
@@ -183,7 +224,19 @@ func validateFilter(config *Config) error {
 	return nil
 }
 
+func validateInventory(config *Config) error {
+	for _, inv := range config.Inventory {
+		if err := inv.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func validateExtra(config *Config) error {
+	if err := validateProcess(config); err != nil {
+		return err
+	}
 	for _, updateFn := range modules {
 		if err := updateFn(config); err != nil {
 			return err
@@ -221,21 +274,175 @@ func Load() {
 	if *VerboseMode {
 		Debug = log.New(os.Stderr, "debug: ", 0)
 	}
-	buf, err := ioutil.ReadFile(*FilePath)
-	if err != nil {
-		log.Fatal(err)
+	if *LintMode {
+		os.Exit(lint())
 	}
-	config, err := Decode(bytes.NewBuffer(buf))
+	config, fetchErr, err := readFile(nil)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if fetchErr != nil {
+		log.Printf("config: %v", fetchErr)
+	}
 	if *TestMode {
 		os.Exit(0)
 	}
+	Loaded = config
+	if *ViewMode != "" || *DumpMode != "" {
+		return // handled by caller
+	}
 	log.Print("start",
 		" pid=", os.Getpid(),
 	)
-	Loaded = config
+	setReloadStatus(nil)
+	if fetchErr != nil {
+		setReloadStatus(fetchErr)
+	}
+}
+
+// readFile reads the config file, and extends its hosts with the inventory
+// records. A failed inventory fetch does not fail the read: the records of
+// the inventory are carried over from the last config, if any, and the fetch
+// error is returned along with the config.
+func readFile(last *Config) (config *Config, fetchErr, err error) {
+	buf, err := ioutil.ReadFile(*FilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err = Decode(bytes.NewBuffer(buf))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(config.Inventory) == 0 {
+		return config, nil, nil
+	}
+	if last != nil {
+		config.keepInventory(last)
+	}
+	_, fetchErr = config.fetchInventory(time.Now(), true)
+	config, err = config.withInventory()
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, fetchErr, nil
+}
+
+// watchInterval determines how often the watched files are checked for
+// changes.
+const watchInterval = 5 * time.Second
+
+// ReloadStatus reports the outcome of config reloads.
+type ReloadStatus struct {
+	Loaded    time.Time // load or last inventory refresh time of the config in use
+	Attempted time.Time // time of the last reload attempt
+	Error     string    `json:",omitempty"` // error of the last reload attempt
+}
+
+var reloadStatus struct {
+	sync.Mutex
+	ReloadStatus
+}
+
+func setReloadStatus(err error) {
+	reloadStatus.Lock()
+	defer reloadStatus.Unlock()
+	now := time.Now()
+	reloadStatus.Attempted = now
+	if err != nil {
+		reloadStatus.Error = err.Error()
+		return
+	}
+	reloadStatus.Loaded = now
+	reloadStatus.Error = ""
+}
+
+// GetReloadStatus returns the outcome of the last config reload.
+func GetReloadStatus() ReloadStatus {
+	reloadStatus.Lock()
+	defer reloadStatus.Unlock()
+	return reloadStatus.ReloadStatus
+}
+
+// Watch reloads the config whenever the config file, the network file, the
+// filter program, or an inventory file changes. The new config is decoded,
+// validated, and passed to fn, which is expected to put it in use. If any of
+// these steps fails, the config in use is retained, and the error is
+// reported by GetReloadStatus. In addition, the inventories are refreshed
+// periodically; a config that includes the changed records is passed to fn.
+// A failed inventory fetch retains the last records of the inventory, and is
+// reported by GetReloadStatus as well.
+func Watch(config *Config, fn func(*Config) error) {
+	last := fingerprint(watchedPaths(config))
+	var fileErr error // error of the last reload of the changed files
+	for now := range time.Tick(watchInterval) {
+		var (
+			next     *Config
+			fetchErr error
+			err      error
+			reread   bool
+		)
+		if fp := fingerprint(watchedPaths(config)); fp != last {
+			last = fp
+			reread = true
+			next, fetchErr, err = readFile(config)
+		} else {
+			var changed bool
+			changed, fetchErr = config.fetchInventory(now, false)
+			if !changed {
+				if fetchErr != nil {
+					log.Printf("config: reload error: %v", fetchErr)
+					setReloadStatus(fetchErr)
+				} else if config.fetched(now) && fileErr == nil {
+					setReloadStatus(nil)
+				}
+				continue
+			}
+			next, err = config.withInventory()
+		}
+		if err == nil {
+			err = fn(next)
+		}
+		if reread {
+			fileErr = err
+		}
+		setReloadStatus(err)
+		if err != nil {
+			log.Printf("config: reload error: %v", err)
+			continue
+		}
+		log.Print("config: reloaded")
+		if fetchErr != nil {
+			log.Printf("config: reload error: %v", fetchErr)
+			setReloadStatus(fetchErr)
+		}
+		config = next
+		last = fingerprint(watchedPaths(config))
+	}
+}
+
+func watchedPaths(config *Config) []string {
+	paths := []string{*FilePath, config.Network.Path(), config.Filter.Path}
+	for _, inv := range config.Inventory {
+		if inv.Type == "file" {
+			paths = append(paths, inv.Path)
+		}
+	}
+	return paths
+}
+
+// fingerprint summarises the modification times and sizes of the given
+// files.
+func fingerprint(paths []string) string {
+	var buf bytes.Buffer
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(&buf, "%s: %v\n", path, err)
+			continue
+		}
+		fmt.Fprintf(&buf, "%s: %v %d\n", path, fi.ModTime().UnixNano(), fi.Size())
+	}
+	return buf.String()
 }
 
 // Host represents a single <host> element.
@@ -243,16 +450,65 @@ type Host struct {
 	ID        string     `xml:"id,attr"`
 	ClusterID string     `xml:"-"`
 	Tags      []string   `xml:"-"`
+	Rule      []*Rule    `xml:"rule"`
+	Label     []*Label   `xml:"label"`
 	Extra     []*Element `xml:",any"`
+
+	// inherited holds rules of the enclosing hostgroups and cluster.
+	inherited []*Rule
+
+	// inheritedLabels holds labels of the enclosing hostgroups and cluster.
+	inheritedLabels []*Label
 }
 
 func (h *Host) Validate() error {
 	if h.ID == "" {
 		return fmt.Errorf("missing host attribute: id")
 	}
+	if err := validateRules(h.Rule); err != nil {
+		return fmt.Errorf("host %s: %v", h.ID, err)
+	}
+	if err := validateLabels(h.Label); err != nil {
+		return fmt.Errorf("host %s: %v", h.ID, err)
+	}
 	return nil
 }
 
+// Rules returns the filter rules that apply to the given program on the
+// host. The rules of the outermost hostgroup come first, and those of the
+// host itself come last.
+func (h *Host) Rules(program string) []*Rule {
+	var found []*Rule
+	for _, rules := range [][]*Rule{h.inherited, h.Rule} {
+		for _, r := range rules {
+			if r.Program == "" || r.Program == program {
+				found = append(found, r)
+			}
+		}
+	}
+	return found
+}
+
+// Labels returns the labels of the host, including those inherited from the
+// enclosing hostgroups and cluster, ordered by name. A label of an inner
+// element overrides the label of the same name of an outer element.
+func (h *Host) Labels() []*Label {
+	m := make(map[string]*Label)
+	for _, labels := range [][]*Label{h.inheritedLabels, h.Label} {
+		for _, l := range labels {
+			m[l.Name] = l
+		}
+	}
+	var found []*Label
+	for _, l := range m {
+		found = append(found, l)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].Name < found[j].Name
+	})
+	return found
+}
+
 // shortID shortens "foo.example.com" to just "foo".
 func (h *Host) shortID() string {
 	label := strings.Split(h.ID, ".")
@@ -268,9 +524,11 @@ func (h *Host) String() string {
 
 // Cluster represents a single <cluster> element.
 type Cluster struct {
-	ID   string    `xml:"id,attr"`
-	Host []*Host   `xml:"host"`
-	Any  *xml.Name `xml:",any"`
+	ID    string    `xml:"id,attr"`
+	Host  []*Host   `xml:"host"`
+	Rule  []*Rule   `xml:"rule"`
+	Label []*Label  `xml:"label"`
+	Any   *xml.Name `xml:",any"`
 }
 
 func (cluster *Cluster) Validate() error {
@@ -280,6 +538,12 @@ func (cluster *Cluster) Validate() error {
 	if any := cluster.Any; any != nil {
 		return fmt.Errorf("cluster %s: invalid element: %s", cluster.ID, any.Local)
 	}
+	if err := validateRules(cluster.Rule); err != nil {
+		return fmt.Errorf("cluster %s: %v", cluster.ID, err)
+	}
+	if err := validateLabels(cluster.Label); err != nil {
+		return fmt.Errorf("cluster %s: %v", cluster.ID, err)
+	}
 	return nil
 }
 
@@ -288,6 +552,8 @@ type HostGroup struct {
 	Sub     []*HostGroup `xml:"hostgroup"`
 	ID      string       `xml:"id,attr"`
 	Cluster []*Cluster   `xml:"cluster"`
+	Rule    []*Rule      `xml:"rule"`
+	Label   []*Label     `xml:"label"`
 	Any     *xml.Name    `xml:",any"`
 }
 
@@ -298,14 +564,117 @@ func (group *HostGroup) Validate() error {
 	if any := group.Any; any != nil {
 		return fmt.Errorf("hostgroup %s: invalid element: %s", group.ID, any.Local)
 	}
+	if err := validateRules(group.Rule); err != nil {
+		return fmt.Errorf("hostgroup %s: %v", group.ID, err)
+	}
+	if err := validateLabels(group.Label); err != nil {
+		return fmt.Errorf("hostgroup %s: %v", group.ID, err)
+	}
+	return nil
+}
+
+// Rule represents a single <rule> element, a filter rule as specified in
+// the Filter setting of tsp-forwarder(8). The rule applies to all hosts
+// enclosed by the parent element. If Program is set, the rule applies only
+// to the named program, which must be one of RulePrograms.
+type Rule struct {
+	Program string    `xml:"program,attr" json:"-"`
+	Match   []string  `xml:"match" json:",omitempty"`
+	Set     []string  `xml:"set" json:",omitempty"`
+	Block   bool      `xml:"block,attr" json:",omitempty"`
+	Any     *xml.Name `xml:",any" json:"-"`
+}
+
+// RulePrograms lists the programs that are served filter rules.
+var RulePrograms = []string{"collect-statse", "tsp-aggregator", "tsp-forwarder", "tsp-poller"}
+
+func (r *Rule) Validate() error {
+	if any := r.Any; any != nil {
+		return fmt.Errorf("rule: invalid element: %s", any.Local)
+	}
+	if r.Program != "" && !isRuleProgram(r.Program) {
+		return fmt.Errorf("rule: unknown program: %s, want one of: %s", r.Program, strings.Join(RulePrograms, ", "))
+	}
+	if _, err := filter.New(filter.Rule{Match: r.Match, Set: r.Set, Block: r.Block}); err != nil {
+		return fmt.Errorf("invalid rule: %v", err)
+	}
+	return nil
+}
+
+func isRuleProgram(program string) bool {
+	for _, p := range RulePrograms {
+		if p == program {
+			return true
+		}
+	}
+	return false
+}
+
+func validateRules(rules []*Rule) error {
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Label represents a single <label> element, a key/value pair that applies
+// to all hosts enclosed by the parent element. If Tag is set, the label is
+// also served as a filter rule that adds the tag Name=Value to points that
+// lack it.
+type Label struct {
+	Name  string    `xml:"name,attr"`
+	Value string    `xml:"value,attr"`
+	Tag   bool      `xml:"tag,attr" json:",omitempty"`
+	Any   *xml.Name `xml:",any" json:"-"`
+}
+
+func (l *Label) Validate() error {
+	if l.Name == "" {
+		return fmt.Errorf("missing label attribute: name")
+	}
+	if any := l.Any; any != nil {
+		return fmt.Errorf("label %s: invalid element: %s", l.Name, any.Local)
+	}
+	if l.Value == "" {
+		return fmt.Errorf("label %s: missing attribute: value", l.Name)
+	}
+	if l.Tag {
+		switch {
+		case l.Name == "host" || l.Name == "cluster":
+			return fmt.Errorf("label %s: reserved tag", l.Name)
+		case tsdb.Clean(l.Name) != l.Name || tsdb.Clean(l.Value) != l.Value:
+			return fmt.Errorf("label %s: invalid tag: %s=%s", l.Name, l.Name, l.Value)
+		}
+	}
+	return nil
+}
+
+func validateLabels(labels []*Label) error {
+	ns := make(Namespace)
+	for _, l := range labels {
+		if err := l.Validate(); err != nil {
+			return err
+		}
+		if ns[l.Name] {
+			return fmt.Errorf("label redeclared: %s", l.Name)
+		}
+		ns[l.Name] = true
+	}
 	return nil
 }
 
 // Hosts is a set of all <host> elements.
 type Hosts struct {
-	All  []*Host
-	NS   Namespace
-	tags tags
+	All    []*Host
+	NS     Namespace
+	tags   tags
+	rules  []*Rule  // rules of the enclosing elements
+	labels []*Label // labels of the enclosing elements
+
+	// scopes holds the declared hostgroups and clusters.
+	scopes map[string]*scope
 }
 
 func (hs *Hosts) String() string {
@@ -332,6 +701,11 @@ func (hs *Hosts) addGroup(group *HostGroup) error {
 	}
 	hs.tags.Push(group.ID)
 	defer hs.tags.Pop()
+	defer hs.push(group.Rule, group.Label)()
+	hs.addScope(group.ID, &scope{
+		groups:   len(group.Sub) > 0,
+		clusters: len(group.Cluster) > 0,
+	})
 	switch {
 	default:
 		// ok
@@ -363,6 +737,8 @@ func (hs *Hosts) addCluster(cluster *Cluster) error {
 	}
 	hs.tags.Push(cluster.ID)
 	defer hs.tags.Pop()
+	defer hs.push(cluster.Rule, cluster.Label)()
+	hs.addScope(cluster.ID, &scope{cluster: true})
 	switch {
 	default:
 		// ok
@@ -387,10 +763,39 @@ func (hs *Hosts) add(host *Host, cluster *Cluster) error {
 	defer hs.tags.Pop()
 	host.ClusterID = cluster.ID
 	host.Tags = hs.tags.Copy()
+	host.inherited = append([]*Rule(nil), hs.rules...)
+	host.inheritedLabels = append([]*Label(nil), hs.labels...)
 	hs.All = append(hs.All, host)
 	return nil
 }
 
+// push adds rules and labels inherited by the enclosed hosts. It returns a
+// function that removes them.
+func (hs *Hosts) push(rules []*Rule, labels []*Label) func() {
+	n, m := len(hs.rules), len(hs.labels)
+	hs.rules = append(hs.rules, rules...)
+	hs.labels = append(hs.labels, labels...)
+	return func() {
+		hs.rules = hs.rules[:n]
+		hs.labels = hs.labels[:m]
+	}
+}
+
+// addScope records the hostgroup or cluster being declared, so that hosts
+// supplied by inventories may be added to it.
+func (hs *Hosts) addScope(id string, s *scope) {
+	if hs.scopes == nil {
+		hs.scopes = make(map[string]*scope)
+	}
+	if n := len(hs.tags); n > 1 {
+		s.parent = hs.tags[n-2]
+	}
+	s.tags = hs.tags.Copy()
+	s.rules = append([]*Rule(nil), hs.rules...)
+	s.labels = append([]*Label(nil), hs.labels...)
+	hs.scopes[id] = s
+}
+
 // Filter is a system command that acts as a hook, allowing operators to serve
 // custom rewrite/block filter rules.
 type Filter struct {
@@ -183,6 +183,69 @@ var testDecode = []struct {
 			},
 		}),
 	},
+	17: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<rule><match>(</match></rule>
+	</hostgroup>
+</config>`,
+		err: "hostgroup foo: invalid rule",
+	},
+	18: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<rule><foo/></rule>
+		</cluster>
+	</hostgroup>
+</config>`,
+		err: "cluster foo.live: rule: invalid element: foo",
+	},
+	19: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<label name="dc" value="ld5"/>
+		<label name="dc" value="ld6"/>
+	</hostgroup>
+</config>`,
+		err: "hostgroup foo: label redeclared: dc",
+	},
+	20: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<label name="host" value="bar" tag="true"/>
+		</cluster>
+	</hostgroup>
+</config>`,
+		err: "cluster foo.live: label host: reserved tag",
+	},
+	21: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001">
+				<label name="role" value="web server" tag="true"/>
+			</host>
+		</cluster>
+	</hostgroup>
+</config>`,
+		err: "host foo001: label role: invalid tag: role=web server",
+	},
+	22: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<rule program="tsp-polller" block="true"><match>x</match></rule>
+	</hostgroup>
+</config>`,
+		err: "hostgroup foo: rule: unknown program: tsp-polller",
+	},
 }
 
 func makeConfig(v interface{}) *Config {
@@ -228,3 +291,92 @@ func TestDecode(t *testing.T) {
 		}
 	}
 }
+
+var testRules = []struct {
+	host    string
+	program string
+	want    []string
+}{
+	{"foo001", "tsp-forwarder", []string{"group", "subgroup", "cluster", "host"}},
+	{"foo001", "tsp-poller", []string{"group", "subgroup", "poller", "cluster", "host"}},
+	{"foo002", "tsp-forwarder", []string{"group", "subgroup", "cluster"}},
+	{"bar001", "tsp-forwarder", []string{"group"}},
+}
+
+func TestRules(t *testing.T) {
+	cfg, err := newDecoder(strings.NewReader(`
+<config>
+	<hostgroup id="all">
+		<rule block="true"><match>group</match></rule>
+		<hostgroup id="foo">
+			<rule block="true"><match>subgroup</match></rule>
+			<rule program="tsp-poller" block="true"><match>poller</match></rule>
+			<cluster id="foo.live">
+				<rule block="true"><match>cluster</match></rule>
+				<host id="foo001">
+					<rule block="true"><match>host</match></rule>
+				</host>
+				<host id="foo002"/>
+			</cluster>
+		</hostgroup>
+		<hostgroup id="bar">
+			<cluster id="bar.live">
+				<host id="bar001"/>
+			</cluster>
+		</hostgroup>
+	</hostgroup>
+</config>`)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range testRules {
+		host, err := cfg.Host(tt.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range host.Rules(tt.program) {
+			got = append(got, r.Match[0])
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d. invalid rules\ngot:  %q\nwant: %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	cfg, err := newDecoder(strings.NewReader(`
+<config>
+	<hostgroup id="foo">
+		<label name="dc" value="ld5" tag="true"/>
+		<label name="env" value="live"/>
+		<cluster id="foo.live">
+			<label name="role" value="web"/>
+			<host id="foo001">
+				<label name="dc" value="ld6" tag="true"/>
+			</host>
+			<host id="foo002"/>
+		</cluster>
+	</hostgroup>
+</config>`)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"foo001": "dc=ld6 env=live role=web",
+		"foo002": "dc=ld5 env=live role=web",
+	}
+	for id, want := range want {
+		host, err := cfg.Host(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, l := range host.Labels() {
+			got = append(got, l.Name+"="+l.Value)
+		}
+		if s := strings.Join(got, " "); s != want {
+			t.Errorf("%s: invalid labels, got: %s, want: %s", id, s, want)
+		}
+	}
+}
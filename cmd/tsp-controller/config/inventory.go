@@ -0,0 +1,435 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRefresh is the default interval between inventory fetches.
+	defaultRefresh = 5 * time.Minute
+
+	// inventoryTimeout limits the duration of a HTTP inventory fetch.
+	inventoryTimeout = 1 * time.Minute
+)
+
+// Inventory represents a single <inventory> element, an external source of
+// hosts, such as a CMDB export.
+type Inventory struct {
+	Type    string    `xml:"type,attr"`
+	Path    string    `xml:"path,attr"`
+	URL     string    `xml:"url,attr"`
+	Format  string    `xml:"format,attr"`
+	Refresh string    `xml:"refresh,attr"`
+	Any     *xml.Name `xml:",any"`
+
+	provider Provider
+	interval time.Duration
+	records  []*Record
+	fetched  time.Time
+}
+
+func (inv *Inventory) Validate() error {
+	if any := inv.Any; any != nil {
+		return fmt.Errorf("inventory: invalid element: %s", any.Local)
+	}
+	if inv.Type == "" {
+		return fmt.Errorf("missing inventory attribute: type")
+	}
+	switch inv.Format {
+	default:
+		return fmt.Errorf("inventory: unsupported format: %s", inv.Format)
+	case "", "json", "csv":
+		// ok
+	}
+	inv.interval = defaultRefresh
+	if inv.Refresh != "" {
+		d, err := time.ParseDuration(inv.Refresh)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("inventory: invalid refresh: %s", inv.Refresh)
+		}
+		inv.interval = d
+	}
+	newProvider, ok := providers[inv.Type]
+	if !ok {
+		return fmt.Errorf("inventory: unsupported type: %s", inv.Type)
+	}
+	p, err := newProvider(inv)
+	if err != nil {
+		return fmt.Errorf("inventory: %v", err)
+	}
+	inv.provider = p
+	return nil
+}
+
+// source returns a description of the inventory suitable for error messages.
+func (inv *Inventory) source() string {
+	if inv.URL != "" {
+		return inv.URL
+	}
+	if inv.Path != "" {
+		return inv.Path
+	}
+	return inv.Type
+}
+
+// A Provider supplies host records from an external inventory.
+type Provider interface {
+	Records() ([]*Record, error)
+}
+
+var providers = map[string]func(*Inventory) (Provider, error){
+	"file": newFileProvider,
+	"http": newHTTPProvider,
+}
+
+// RegisterProvider registers an inventory provider, making it available to
+// <inventory> elements of the given type. The function fn is passed the
+// element, and returns the provider or a validation error.
+func RegisterProvider(typ string, fn func(*Inventory) (Provider, error)) {
+	providers[typ] = fn
+}
+
+// Record represents a host supplied by an inventory. The host is enclosed by
+// the given cluster, which in turn is enclosed by the given hostgroups, the
+// outermost first. The labels, if any, apply to the host.
+type Record struct {
+	Host      string
+	Cluster   string
+	HostGroup []string
+	Label     []*Label `json:",omitempty"`
+}
+
+func (r *Record) Validate() error {
+	switch {
+	case r.Host == "":
+		return fmt.Errorf("missing host")
+	case r.Cluster == "":
+		return fmt.Errorf("host %s: missing cluster", r.Host)
+	case len(r.HostGroup) == 0:
+		return fmt.Errorf("host %s: missing hostgroup", r.Host)
+	case !isPlainID(r.Host):
+		return fmt.Errorf("host %s: invalid id", r.Host)
+	case !isPlainID(r.Cluster):
+		return fmt.Errorf("host %s: invalid cluster: %s", r.Host, r.Cluster)
+	}
+	for _, id := range r.HostGroup {
+		if id == "" {
+			return fmt.Errorf("host %s: empty hostgroup", r.Host)
+		}
+		if !isPlainID(id) {
+			return fmt.Errorf("host %s: invalid hostgroup: %s", r.Host, id)
+		}
+	}
+	if err := validateLabels(r.Label); err != nil {
+		return fmt.Errorf("host %s: %v", r.Host, err)
+	}
+	return nil
+}
+
+// isPlainID reports whether the given id is safe to use as a file name, that
+// is contains no path separators and is not a relative path element. The ids
+// supplied by inventories are external data, and host ids name the files
+// written by Dump.
+func isPlainID(id string) bool {
+	return id != "." && id != ".." && !strings.ContainsAny(id, `/\`)
+}
+
+// decodeRecords decodes the records in the given format. The JSON format is
+// an array of records. The CSV format has one record per line, listing the
+// host, the cluster, and the hostgroups, the outermost first; it does not
+// support labels.
+func decodeRecords(r io.Reader, format string) ([]*Record, error) {
+	r = io.LimitReader(r, maxSize)
+	var records []*Record
+	switch format {
+	default:
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			return nil, err
+		}
+	case "csv":
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.Comment = '#'
+		cr.TrimLeadingSpace = true
+		for line := 1; ; line++ {
+			fields, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if line == 1 && strings.EqualFold(fields[0], "host") {
+				continue // header
+			}
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("line %d: want host,cluster,hostgroup...", line)
+			}
+			records = append(records, &Record{
+				Host:      fields[0],
+				Cluster:   fields[1],
+				HostGroup: fields[2:],
+			})
+		}
+	}
+	for _, r := range records {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// fileProvider reads records from a local file.
+type fileProvider struct {
+	path   string
+	format string
+}
+
+func newFileProvider(inv *Inventory) (Provider, error) {
+	if inv.Path == "" {
+		return nil, fmt.Errorf("missing attribute: path")
+	}
+	format := inv.Format
+	if format == "" && filepath.Ext(inv.Path) == ".csv" {
+		format = "csv"
+	}
+	return &fileProvider{inv.Path, format}, nil
+}
+
+func (p *fileProvider) Records() ([]*Record, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeRecords(f, p.format)
+}
+
+// httpProvider fetches records from a HTTP endpoint.
+type httpProvider struct {
+	url    string
+	format string
+	client *http.Client
+}
+
+func newHTTPProvider(inv *Inventory) (Provider, error) {
+	if inv.URL == "" {
+		return nil, fmt.Errorf("missing attribute: url")
+	}
+	u, err := url.Parse(inv.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid url: %s", inv.URL)
+	}
+	return &httpProvider{
+		url:    inv.URL,
+		format: inv.Format,
+		client: &http.Client{Timeout: inventoryTimeout},
+	}, nil
+}
+
+func (p *httpProvider) Records() ([]*Record, error) {
+	resp, err := p.client.Get(p.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	format := p.format
+	if format == "" && strings.Contains(resp.Header.Get("Content-Type"), "csv") {
+		format = "csv"
+	}
+	return decodeRecords(resp.Body, format)
+}
+
+// fetchInventory fetches the records of inventories due for a refresh, or,
+// if all is set, of all inventories. It reports whether any records have
+// changed. A failed fetch retains the previous records, and is retried after
+// the refresh interval.
+func (c *Config) fetchInventory(now time.Time, all bool) (changed bool, err error) {
+	for _, inv := range c.Inventory {
+		if !all && now.Sub(inv.fetched) < inv.interval {
+			continue
+		}
+		inv.fetched = now
+		records, ferr := inv.provider.Records()
+		if ferr != nil {
+			if err == nil {
+				err = fmt.Errorf("inventory %s: %v", inv.source(), ferr)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(records, inv.records) {
+			inv.records = records
+			changed = true
+		}
+	}
+	return changed, err
+}
+
+// fetched reports whether any inventory was fetched at the given time.
+func (c *Config) fetched(now time.Time) bool {
+	for _, inv := range c.Inventory {
+		if inv.fetched.Equal(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepInventory copies the records of the inventories of the last config
+// that are unchanged in c, so that they survive a failed fetch.
+func (c *Config) keepInventory(last *Config) {
+	for _, inv := range c.Inventory {
+		for _, prev := range last.Inventory {
+			if inv.Type == prev.Type && inv.Path == prev.Path && inv.URL == prev.URL && inv.Format == prev.Format {
+				inv.records = prev.records
+				break
+			}
+		}
+	}
+}
+
+// withInventory returns a copy of the config whose hosts are extended with
+// the inventory records.
+func (c *Config) withInventory() (*Config, error) {
+	declared := c.declared
+	if declared == nil {
+		declared = c.Hosts
+	}
+	hosts := declared.copy()
+	for _, inv := range c.Inventory {
+		for _, r := range inv.records {
+			if err := hosts.addRecord(r); err != nil {
+				return nil, fmt.Errorf("inventory %s: %v", inv.source(), err)
+			}
+		}
+	}
+	cp := *c
+	cp.Hosts = hosts
+	cp.declared = declared
+	return &cp, nil
+}
+
+// scope holds the details of a hostgroup or cluster needed to add hosts
+// supplied by inventories.
+type scope struct {
+	cluster  bool
+	parent   string
+	tags     []string // ids of the enclosing elements and the element itself
+	rules    []*Rule  // rules of the enclosing elements and the element itself
+	labels   []*Label // labels of the enclosing elements and the element itself
+	groups   bool     // encloses hostgroups
+	clusters bool     // encloses clusters
+}
+
+// copy returns a copy of hs that can be extended without affecting hs.
+func (hs *Hosts) copy() *Hosts {
+	cp := &Hosts{
+		All:    append([]*Host(nil), hs.All...),
+		NS:     make(Namespace),
+		scopes: make(map[string]*scope),
+	}
+	for id := range hs.NS {
+		cp.NS[id] = true
+	}
+	for id, s := range hs.scopes {
+		s := *s
+		cp.scopes[id] = &s
+	}
+	return cp
+}
+
+// addRecord adds a host supplied by an inventory. Hosts declared in the
+// config file, or supplied earlier, take precedence.
+func (hs *Hosts) addRecord(r *Record) error {
+	for _, host := range hs.All {
+		if host.ID == r.Host {
+			if Debug != nil {
+				Debug.Printf("inventory: host %s: ignoring redeclaration", r.Host)
+			}
+			return nil
+		}
+	}
+	var parent *scope
+	parentID := ""
+	for _, id := range r.HostGroup {
+		s, err := hs.openScope(id, parentID, parent, false)
+		if err != nil {
+			return err
+		}
+		parent, parentID = s, id
+	}
+	cluster, err := hs.openScope(r.Cluster, parentID, parent, true)
+	if err != nil {
+		return err
+	}
+	if err := hs.NS.Add(r.Host); err != nil {
+		return err
+	}
+	host := &Host{
+		ID:        r.Host,
+		ClusterID: r.Cluster,
+		Tags:      append(append([]string(nil), cluster.tags...), r.Host),
+		Label:     r.Label,
+		inherited: cluster.rules,
+
+		inheritedLabels: cluster.labels,
+	}
+	hs.All = append(hs.All, host)
+	return nil
+}
+
+// openScope returns the hostgroup or cluster with the given id, declaring it
+// if necessary.
+func (hs *Hosts) openScope(id, parentID string, parent *scope, cluster bool) (*scope, error) {
+	kind := "hostgroup"
+	if cluster {
+		kind = "cluster"
+	}
+	if s, ok := hs.scopes[id]; ok {
+		if s.cluster != cluster {
+			return nil, fmt.Errorf("%s %s: declared with different type", kind, id)
+		}
+		if s.parent != parentID {
+			return nil, fmt.Errorf("%s %s: declared with different parent", kind, id)
+		}
+		return s, nil
+	}
+	if parent != nil {
+		if cluster && parent.groups || !cluster && parent.clusters {
+			return nil, fmt.Errorf("hostgroup %s: contains both hostgroup and cluster", parentID)
+		}
+	}
+	if err := hs.NS.Add(id); err != nil {
+		return nil, err
+	}
+	s := &scope{cluster: cluster, parent: parentID}
+	if parent != nil {
+		s.tags = append(s.tags, parent.tags...)
+		s.rules = parent.rules
+		s.labels = parent.labels
+		parent.groups = parent.groups || !cluster
+		parent.clusters = parent.clusters || cluster
+	}
+	s.tags = append(s.tags, id)
+	hs.scopes[id] = s
+	return s, nil
+}
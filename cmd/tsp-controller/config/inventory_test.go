@@ -0,0 +1,251 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testInventory = []struct {
+	json string
+	csv  string
+	out  []*Host
+	err  string
+}{
+	0: {
+		json: `[]`,
+		csv:  ``,
+		out: []*Host{
+			{ID: "foo001", ClusterID: "foo.live", Tags: []string{"foo", "foo.live", "foo001"}},
+		},
+	},
+	1: {
+		json: `[
+			{"Host": "foo002", "Cluster": "foo.live", "HostGroup": ["foo"], "Label": [{"Name": "role", "Value": "db"}]},
+			{"Host": "foo001", "Cluster": "foo.live", "HostGroup": ["foo"]}
+		]`,
+		csv: "host,cluster,hostgroup\n" +
+			"bar001,bar.live,bar,bar.web\n" +
+			"bar002,bar.test,bar,bar.web\n",
+		out: []*Host{
+			{ID: "foo001", ClusterID: "foo.live", Tags: []string{"foo", "foo.live", "foo001"}},
+			{ID: "foo002", ClusterID: "foo.live", Tags: []string{"foo", "foo.live", "foo002"}, Label: []*Label{{Name: "role", Value: "db"}}},
+			{ID: "bar001", ClusterID: "bar.live", Tags: []string{"bar", "bar.web", "bar.live", "bar001"}},
+			{ID: "bar002", ClusterID: "bar.test", Tags: []string{"bar", "bar.web", "bar.test", "bar002"}},
+		},
+	},
+	2: {
+		json: `[{"Host": "foo002", "Cluster": "foo.live", "HostGroup": ["bar"]}]`,
+		err:  "cluster foo.live: declared with different parent",
+	},
+	3: {
+		json: `[{"Host": "foo002", "Cluster": "foo", "HostGroup": ["bar"]}]`,
+		err:  "cluster foo: declared with different type",
+	},
+	4: {
+		json: `[{"Host": "foo002", "Cluster": "foo.test", "HostGroup": ["foo", "foo.sub"]}]`,
+		err:  "hostgroup foo: contains both hostgroup and cluster",
+	},
+	5: {
+		json: `[{"Host": "foo.live", "Cluster": "bar.live", "HostGroup": ["bar"]}]`,
+		err:  "identifier redeclared: foo.live",
+	},
+	6: {
+		csv: "foo002,foo.live\n",
+		err: "line 1: want host,cluster,hostgroup...",
+	},
+	7: {
+		json: `[{"Host": "foo002", "HostGroup": ["foo"]}]`,
+		err:  "host foo002: missing cluster",
+	},
+	8: {
+		json: `[{"Host": "../foo002", "Cluster": "foo.live", "HostGroup": ["foo"]}]`,
+		err:  "host ../foo002: invalid id",
+	},
+	9: {
+		csv: "foo002,..,foo\n",
+		err: "host foo002: invalid cluster: ..",
+	},
+	10: {
+		json: `[{"Host": "foo002", "Cluster": "foo.live", "HostGroup": ["foo/bar"]}]`,
+		err:  "host foo002: invalid hostgroup: foo/bar",
+	},
+}
+
+func TestInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var csv string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Write([]byte(csv))
+	}))
+	defer srv.Close()
+	path := filepath.Join(dir, "hosts.json")
+	for i, tt := range testInventory {
+		if err := ioutil.WriteFile(path, []byte(tt.json), 0644); err != nil {
+			t.Fatal(err)
+		}
+		csv = tt.csv
+		cfg, err := Decode(strings.NewReader(`
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<rule block="true"><match>^foo\.debug\.</match></rule>
+			<host id="foo001"/>
+		</cluster>
+	</hostgroup>
+	<inventory type="file" path="` + path + `"/>
+	<inventory type="http" url="` + srv.URL + `" refresh="1m"/>
+</config>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.json == "" {
+			cfg.Inventory = cfg.Inventory[1:]
+		}
+		_, err = cfg.fetchInventory(time.Now(), true)
+		if err == nil {
+			cfg, err = cfg.withInventory()
+		}
+		if err != nil {
+			if tt.err == "" {
+				t.Errorf("#%d. unexpected error: %v", i, err)
+				continue
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("#%d. invalid error, got: %s, want: %s", i, err, tt.err)
+			}
+			continue
+		}
+		if tt.err != "" {
+			t.Errorf("#%d. unexpected success, want error: %v", i, tt.err)
+			continue
+		}
+		var got []*Host
+		for _, host := range cfg.Hosts.All {
+			if host.ID != "bar001" && host.ID != "bar002" && len(host.Rules("tsp-forwarder")) != 1 {
+				t.Errorf("#%d. host %s: cluster rule not inherited", i, host.ID)
+			}
+			cp := *host
+			cp.inherited = nil
+			got = append(got, &cp)
+		}
+		if !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("#%d. invalid hosts\ngot:  %+v\nwant: %+v", i, got, tt.out)
+		}
+	}
+}
+
+func TestInventoryRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts.csv")
+	write := func(s string) {
+		if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("foo001,foo.live,foo\n")
+	cfg, err := Decode(strings.NewReader(`
+<config>
+	<inventory type="file" path="` + path + `" refresh="1m"/>
+</config>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if changed, err := cfg.fetchInventory(now, true); err != nil || !changed {
+		t.Fatalf("initial fetch: changed=%v, err=%v", changed, err)
+	}
+	write("foo001,foo.live,foo\nfoo002,foo.live,foo\n")
+	if changed, _ := cfg.fetchInventory(now.Add(30*time.Second), false); changed {
+		t.Errorf("fetched before refresh interval")
+	}
+	if changed, _ := cfg.fetchInventory(now.Add(time.Minute), false); !changed {
+		t.Errorf("not fetched after refresh interval")
+	}
+	write("invalid\n")
+	if _, err := cfg.fetchInventory(now.Add(2*time.Minute), false); err == nil {
+		t.Errorf("unexpected success")
+	}
+	merged, err := cfg.withInventory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(merged.Hosts.All); n != 2 {
+		t.Errorf("failed fetch did not retain records, got %d hosts", n)
+	}
+	if n := len(cfg.Hosts.All); n != 0 {
+		t.Errorf("declared hosts modified, got %d hosts", n)
+	}
+}
+
+func TestReadFileInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts.csv")
+	write := func(path, s string) {
+		if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(path string) { *FilePath = path }(*FilePath)
+	*FilePath = filepath.Join(dir, "config")
+	write(*FilePath, `
+<config>
+	<inventory type="file" path="`+path+`"/>
+</config>`)
+	// A failed fetch does not fail the initial read.
+	write(path, "invalid\n")
+	cfg, fetchErr, err := readFile(nil)
+	if err != nil || fetchErr == nil {
+		t.Fatalf("initial read: fetchErr=%v, err=%v", fetchErr, err)
+	}
+	if n := len(cfg.Hosts.All); n != 0 {
+		t.Errorf("got %d hosts, want 0", n)
+	}
+	write(path, "foo001,foo.live,foo\nfoo002,foo.live,foo\n")
+	cfg, fetchErr, err = readFile(cfg)
+	if err != nil || fetchErr != nil {
+		t.Fatalf("fetchErr=%v, err=%v", fetchErr, err)
+	}
+	if n := len(cfg.Hosts.All); n != 2 {
+		t.Errorf("got %d hosts, want 2", n)
+	}
+	// A failed fetch on reload retains the last records.
+	write(path, "invalid\n")
+	cfg, fetchErr, err = readFile(cfg)
+	if err != nil || fetchErr == nil {
+		t.Fatalf("reload: fetchErr=%v, err=%v", fetchErr, err)
+	}
+	if n := len(cfg.Hosts.All); n != 2 {
+		t.Errorf("failed fetch did not retain records, got %d hosts", n)
+	}
+	// The records are carried over into the config refreshed later.
+	if changed, err := cfg.fetchInventory(time.Now().Add(time.Hour), false); err == nil || changed {
+		t.Errorf("refresh: changed=%v, err=%v", changed, err)
+	}
+	if merged, err := cfg.withInventory(); err != nil || len(merged.Hosts.All) != 2 {
+		t.Errorf("refresh did not retain records: %v", err)
+	}
+}
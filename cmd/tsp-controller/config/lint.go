@@ -0,0 +1,252 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxProblems limits the number of errors reported by Lint.
+const maxProblems = 100
+
+// Problem is an error or a warning reported by Lint.
+type Problem struct {
+	Line    int // 0 if unknown
+	Warning bool
+	Msg     string
+}
+
+func (p *Problem) String() string {
+	s := p.Msg
+	if p.Warning {
+		s = "warning: " + s
+	}
+	if p.Line > 0 {
+		s = fmt.Sprintf("%d: %s", p.Line, s)
+	}
+	return s
+}
+
+var checks []func(*Config) []error
+
+// RegisterCheck registers a function that inspects a valid config for
+// likely mistakes, for example elements that have no effect. The returned
+// errors are reported by Lint as warnings. An error that starts with the
+// element name and id, such as "querygroup foo: ...", is reported with the
+// line of that element.
+func RegisterCheck(fn func(*Config) []error) {
+	checks = append(checks, fn)
+}
+
+// node is an element of the config file.
+type node struct {
+	name, id   string
+	line       int
+	start, end int64
+}
+
+// parseNodes returns the elements of the XML document, in document order.
+func parseNodes(buf []byte) ([]*node, error) {
+	var (
+		dec   = xml.NewDecoder(bytes.NewReader(buf))
+		nodes []*node
+		stack []*node
+	)
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nodes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &node{
+				name:  tok.Name.Local,
+				line:  1 + bytes.Count(buf[:start], []byte("\n")),
+				start: start,
+			}
+			for _, attr := range tok.Attr {
+				if attr.Name.Local == "id" {
+					n.id = attr.Value
+				}
+			}
+			nodes = append(nodes, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack[len(stack)-1].end = dec.InputOffset()
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+var (
+	errKindID     = regexp.MustCompile(`^([a-z]+) ([^ :]+):`)
+	errMissingID  = regexp.MustCompile(`^missing ([a-z]+) attribute: id`)
+	errRedeclared = regexp.MustCompile(`^identifier redeclared: (.+)`)
+)
+
+// locate returns the element that caused the given error, or nil if it
+// cannot be determined.
+func locate(nodes []*node, err error) *node {
+	msg := err.Error()
+	if m := errRedeclared.FindStringSubmatch(msg); m != nil {
+		var found *node
+		for _, n := range nodes {
+			if n.id == m[1] && n.start >= 0 {
+				if found == nil {
+					found = n
+					continue
+				}
+				return n // the redeclaration
+			}
+		}
+		return nil
+	}
+	if m := errMissingID.FindStringSubmatch(msg); m != nil {
+		for _, n := range nodes {
+			if n.name == m[1] && n.id == "" && n.start >= 0 {
+				return n
+			}
+		}
+		return nil
+	}
+	if m := errKindID.FindStringSubmatch(msg); m != nil {
+		for _, n := range nodes {
+			if n.name == m[1] && n.id == m[2] && n.start >= 0 {
+				return n
+			}
+		}
+	}
+	return nil
+}
+
+// Lint reports the problems found in the given config file. Unlike Decode,
+// which stops at the first error, it reports every element in error: the
+// offending element is blanked out and the config decoded again. Errors
+// caused only by the removal of an earlier element may be reported, too.
+//
+// If the remainder of the config is valid, Lint also warns about likely
+// mistakes: hosts outside the network restrictions, short host ids that
+// refer to more than one host, and the problems reported by the functions
+// registered using RegisterCheck. Inventories that cannot be fetched are
+// reported as warnings, too: the failure is not a config error, and the
+// controller keeps running without the inventory records.
+func Lint(buf []byte) []*Problem {
+	nodes, err := parseNodes(buf)
+	if err != nil {
+		line := 0
+		if serr, ok := err.(*xml.SyntaxError); ok {
+			line = serr.Line
+		}
+		return []*Problem{{Line: line, Msg: err.Error()}}
+	}
+	buf = append([]byte(nil), buf...)
+	var problems []*Problem
+	for {
+		config, err := Decode(bytes.NewReader(buf))
+		var fetchErr error
+		if err == nil && len(config.Inventory) > 0 {
+			_, fetchErr = config.fetchInventory(time.Now(), true)
+			config, err = config.withInventory()
+		}
+		if err == nil {
+			if fetchErr != nil {
+				problems = append(problems, &Problem{Warning: true, Msg: fetchErr.Error()})
+			}
+			return append(problems, lintConfig(config, nodes)...)
+		}
+		n := locate(nodes, err)
+		if n == nil || n.name == "config" || len(problems) == maxProblems {
+			return append(problems, &Problem{Msg: err.Error()})
+		}
+		problems = append(problems, &Problem{Line: n.line, Msg: err.Error()})
+		// Blank out the element, preserving the line numbers.
+		for i := n.start; i < n.end; i++ {
+			if buf[i] != '\n' {
+				buf[i] = ' '
+			}
+		}
+		for _, m := range nodes {
+			if m.start >= n.start && m.end <= n.end {
+				m.start = -1
+			}
+		}
+	}
+}
+
+// lintConfig returns the warnings about a valid config.
+func lintConfig(config *Config, nodes []*node) []*Problem {
+	var warnings []error
+	hostIDs := make(map[string]bool)
+	shortIDs := make(map[string][]string)
+	for _, host := range config.Hosts.All {
+		if !config.Network.InScope(host.ID) {
+			warnings = append(warnings, fmt.Errorf("host %s: outside network restrictions", host.ID))
+		}
+		hostIDs[host.ID] = true
+		if short := host.shortID(); short != host.ID {
+			shortIDs[short] = append(shortIDs[short], host.ID)
+		}
+	}
+	// An exact match takes precedence over short ids, see Config.Host.
+	var ambiguous []string
+	for short, ids := range shortIDs {
+		if len(ids) > 1 && !hostIDs[short] {
+			ambiguous = append(ambiguous, short)
+		}
+	}
+	sort.Strings(ambiguous)
+	for _, short := range ambiguous {
+		ids := shortIDs[short]
+		warnings = append(warnings, fmt.Errorf("host %s: short id %s is ambiguous, also refers to: %s",
+			ids[0], short, strings.Join(ids[1:], ", ")))
+	}
+	for _, fn := range checks {
+		warnings = append(warnings, fn(config)...)
+	}
+	var problems []*Problem
+	for _, err := range warnings {
+		p := &Problem{Warning: true, Msg: err.Error()}
+		if n := locate(nodes, err); n != nil {
+			p.Line = n.line
+		}
+		problems = append(problems, p)
+	}
+	return problems
+}
+
+// lint lints FilePath, printing the problems to standard output. It returns
+// the exit status: 0 if no errors were found, 1 otherwise.
+func lint() int {
+	buf, err := ioutil.ReadFile(*FilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	status := 0
+	for _, p := range Lint(buf) {
+		sep := ":"
+		if p.Line == 0 {
+			sep = ": "
+		}
+		fmt.Printf("%s%s%s\n", *FilePath, sep, p)
+		if !p.Warning {
+			status = 1
+		}
+	}
+	return status
+}
@@ -0,0 +1,176 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testLint = []struct {
+	in  string
+	out []string
+}{
+	0: {
+		in: `<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001"/>
+		</cluster>
+	</hostgroup>
+</config>`,
+		out: nil,
+	},
+	1: {
+		in: `<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001"/>
+			<host>
+			</host>
+			<host id="foo002"><label name="role"/></host>
+		</cluster>
+		<cluster id="foo.live"/>
+		<cluster id="foo.test">
+			<rule><foo/></rule>
+		</cluster>
+	</hostgroup>
+</config>`,
+		out: []string{
+			"5: missing host attribute: id",
+			"7: host foo002: label role: missing attribute: value",
+			"9: identifier redeclared: foo.live",
+			"10: cluster foo.test: rule: invalid element: foo",
+		},
+	},
+	2: {
+		in: `<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001.a.example.com"/>
+			<host id="foo001.b.example.com"/>
+			<host id="foo002.a.example.com"/>
+			<host id="foo002"/>
+			<host id="foo003.a.example.com"/>
+		</cluster>
+	</hostgroup>
+</config>`,
+		out: []string{
+			"4: warning: host foo001.a.example.com: short id foo001 is ambiguous, also refers to: foo001.b.example.com",
+		},
+	},
+	3: {
+		in: `<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001"/>
+		</cluster>
+	</hostgroup>
+	<foo/>
+</config>`,
+		out: []string{
+			"unsupported element: foo",
+		},
+	},
+	4: {
+		in: `<config>
+	<hostgroup id="foo">
+</config>`,
+		out: []string{
+			"3: XML syntax error on line 3: element <hostgroup> closed by </config>",
+		},
+	},
+}
+
+func TestLint(t *testing.T) {
+	for i, tt := range testLint {
+		var got []string
+		for _, p := range Lint([]byte(tt.in)) {
+			got = append(got, p.String())
+		}
+		if !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("#%d. invalid problems\ngot:  %q\nwant: %q", i, got, tt.out)
+		}
+	}
+}
+
+func TestLintInventory(t *testing.T) {
+	problems := Lint([]byte(`<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001"/>
+		</cluster>
+	</hostgroup>
+	<inventory type="file" path="/nonexistent/inventory.json"/>
+</config>`))
+	if len(problems) != 1 {
+		t.Fatalf("got %d problems, want 1: %v", len(problems), problems)
+	}
+	p := problems[0]
+	if !p.Warning || !strings.HasPrefix(p.Msg, "inventory /nonexistent/inventory.json: ") {
+		t.Errorf("invalid problem: %v", p)
+	}
+}
+
+const testLocateConfig = `<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001"/>
+			<host>
+			</host>
+		</cluster>
+		<cluster id="foo.live"/>
+	</hostgroup>
+	<inventory type="file" path="/x/y"/>
+</config>`
+
+var testLocate = []struct {
+	err  string
+	line int // 0 if not located
+}{
+	// identifier redeclared: <id>
+	0: {"identifier redeclared: foo.live", 8},
+	1: {"identifier redeclared: bar", 0},
+	// missing <element> attribute: id
+	2: {"missing host attribute: id", 5},
+	3: {"missing cluster attribute: id", 0},
+	// <element> <id>: ...
+	4: {"host foo001: label role: missing attribute: value", 4},
+	5: {"cluster foo.live: rule: invalid element: foo", 3},
+	6: {"host foo002: outside network restrictions", 0},
+	7: {"inventory /x/y: open /x/y: no such file or directory", 0},
+	// other
+	8: {"unsupported element: foo", 0},
+}
+
+func TestLocate(t *testing.T) {
+	nodes, err := parseNodes([]byte(testLocateConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range testLocate {
+		line := 0
+		if n := locate(nodes, errors.New(tt.err)); n != nil {
+			line = n.line
+		}
+		if line != tt.line {
+			t.Errorf("#%d. %s: got line %d, want %d", i, tt.err, line, tt.line)
+		}
+	}
+	// Elements set aside are skipped.
+	for _, n := range nodes {
+		if n.name == "cluster" && n.line == 3 {
+			n.start = -1
+		}
+	}
+	if n := locate(nodes, errors.New("identifier redeclared: foo.live")); n != nil {
+		t.Errorf("set aside element located: line %d", n.line)
+	}
+	if n := locate(nodes, errors.New("cluster foo.live: x")); n == nil || n.line != 8 {
+		t.Errorf("cluster foo.live: got %v, want line 8", n)
+	}
+}
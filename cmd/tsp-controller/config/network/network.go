@@ -9,10 +9,15 @@ import (
 	"bytes"
 	"encoding/xml"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"regexp"
+	"strings"
+
+	"opentsp.org/internal/tsdb/filter"
 )
 
 var (
@@ -47,8 +52,16 @@ type Config struct {
 	restrict []*Restriction
 
 	// Network topology.
-	Aggregator *Aggregator
+	Aggregator []*Aggregator
 	Subscriber []*Subscriber
+
+	// path is the path of the network config file.
+	path string
+}
+
+// Path returns the path of the network config file.
+func (c *Config) Path() string {
+	return c.path
 }
 
 // InScope reports if the given hostname is in scope.
@@ -81,20 +94,30 @@ func ReadFile(path string) (*Config, error) {
 	buf, err := ioutil.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return &DefaultConfig, nil
+			config := DefaultConfig
+			config.path = path
+			return &config, nil
 		}
 		return nil, err
 	}
 	var f struct {
 		Restrict   []*Restriction `xml:"restrict"`
-		Aggregator *Aggregator    `xml:"aggregator"`
+		Aggregator []*Aggregator  `xml:"aggregator"`
 		Subscriber []*Subscriber  `xml:"subscriber"`
 	}
 	r := bytes.NewBuffer(buf)
 	if err := xml.NewDecoder(r).Decode(&f); err != nil {
 		return nil, fmt.Errorf("error decoding %s: %v", path, err)
 	}
-	config := Config{f.Restrict, f.Aggregator, f.Subscriber}
+	if err := validateAggregators(f.Aggregator); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, s := range f.Subscriber {
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	config := Config{f.Restrict, f.Aggregator, f.Subscriber, path}
 	if len(config.restrict) == 0 {
 		config.restrict = DefaultRestrictions
 	}
@@ -135,17 +158,211 @@ func (r *Restriction) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) err
 	return nil
 }
 
-// Aggregator specifies the aggregator node, a node that exports the
-// indirect site feed.
+// Aggregator specifies an aggregator node, a node that exports the indirect
+// site feed.
+//
+// If several aggregators are declared, each exports a shard of the site
+// feed: the feed of the hosts in the listed clusters or, if Cluster is
+// unset, of the hosts assigned to it by consistent hashing. If Merge is
+// true, the aggregator instead merges the shards, and exports the complete
+// site feed.
 type Aggregator struct {
-	Host string `xml:"host,attr"`
+	Host    string `xml:"host,attr"`
+	Cluster string `xml:"cluster,attr"` // comma-separated list of cluster ids
+	Merge   bool   `xml:"merge,attr"`
+}
+
+// Clusters returns the ids of the clusters assigned to the aggregator.
+func (a *Aggregator) Clusters() []string {
+	var ids []string
+	for _, id := range strings.Split(a.Cluster, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// hostname returns the aggregator host without the port.
+func (a *Aggregator) hostname() string {
+	if host, _, err := net.SplitHostPort(a.Host); err == nil {
+		return host
+	}
+	return a.Host
+}
+
+func validateAggregators(aggregators []*Aggregator) error {
+	var (
+		hosts    = make(map[string]bool)
+		clusters = make(map[string]bool)
+		merge    = 0
+		shards   = 0
+	)
+	for _, a := range aggregators {
+		if a.Host == "" {
+			return fmt.Errorf("aggregator: missing attribute: host")
+		}
+		if hosts[a.hostname()] {
+			return fmt.Errorf("aggregator %s: host redeclared", a.Host)
+		}
+		hosts[a.hostname()] = true
+		if a.Merge {
+			if a.Cluster != "" {
+				return fmt.Errorf("aggregator %s: merge aggregator may not list clusters", a.Host)
+			}
+			merge++
+			continue
+		}
+		shards++
+		for _, id := range a.Clusters() {
+			if clusters[id] {
+				return fmt.Errorf("aggregator %s: cluster %s assigned twice", a.Host, id)
+			}
+			clusters[id] = true
+		}
+	}
+	if merge > 1 {
+		return fmt.Errorf("aggregator: at most one merge aggregator allowed")
+	}
+	if merge == 1 && shards == 0 {
+		return fmt.Errorf("aggregator: merge aggregator requires shard aggregators")
+	}
+	return nil
+}
+
+// Merger returns the aggregator that merges the shards, if any.
+func (c *Config) Merger() *Aggregator {
+	for _, a := range c.Aggregator {
+		if a.Merge {
+			return a
+		}
+	}
+	return nil
+}
+
+// FindAggregator returns the aggregator that runs on the given host, if any.
+func (c *Config) FindAggregator(host string) *Aggregator {
+	for _, a := range c.Aggregator {
+		if a.hostname() == host {
+			return a
+		}
+	}
+	return nil
+}
+
+// AggregatorFor returns the aggregator that receives the feed of the given
+// host, which belongs to the given cluster. The aggregator that lists the
+// cluster is preferred. Otherwise, the aggregator is chosen by rendezvous
+// hashing of the host id among the aggregators that list no clusters or,
+// if there are none, among all shard aggregators. Nil is returned if no
+// aggregator is declared.
+func (c *Config) AggregatorFor(host, cluster string) *Aggregator {
+	var pool, shards []*Aggregator
+	for _, a := range c.Aggregator {
+		if a.Merge {
+			continue
+		}
+		for _, id := range a.Clusters() {
+			if id == cluster && cluster != "" {
+				return a
+			}
+		}
+		if a.Cluster == "" {
+			pool = append(pool, a)
+		}
+		shards = append(shards, a)
+	}
+	if len(pool) == 0 {
+		pool = shards
+	}
+	var (
+		best  *Aggregator
+		score uint64
+	)
+	for _, a := range pool {
+		h := fnv.New64a()
+		h.Write([]byte(host))
+		h.Write([]byte{0})
+		h.Write([]byte(a.Host))
+		if s := h.Sum64(); best == nil || s > score {
+			best, score = a, s
+		}
+	}
+	return best
 }
 
 // Subscriber represents a consumer of the site feed. The feed arrives in the aggregated
 // form (unless Direct is true), and without any pre-processing (unless Dedup is true).
+//
+// The feed may be narrowed to the metrics matched by Include, less those
+// matched by Exclude, and to the hosts matched by Scope.
 type Subscriber struct {
-	ID     string `xml:"id,attr"`
-	Host   string `xml:"host,attr"`
-	Direct bool   `xml:"direct,attr"`
-	Dedup  bool   `xml:"dedup,attr"`
+	ID      string         `xml:"id,attr"`
+	Host    string         `xml:"host,attr"`
+	Direct  bool           `xml:"direct,attr"`
+	Dedup   bool           `xml:"dedup,attr"`
+	Include []*Selector    `xml:"include"`
+	Exclude []*Selector    `xml:"exclude"`
+	Scope   []*Restriction `xml:"scope"`
+}
+
+// Selector selects metrics of a subscriber feed.
+type Selector struct {
+	Metric string `xml:"metric,attr"` // regex
+}
+
+func (s *Subscriber) validate() error {
+	for _, sel := range append(s.Include, s.Exclude...) {
+		if sel.Metric == "" {
+			return fmt.Errorf("subscriber %s: selector missing attribute: metric", s.ID)
+		}
+		if _, err := regexp.Compile(sel.Metric); err != nil {
+			return fmt.Errorf("subscriber %s: %v", s.ID, err)
+		}
+	}
+	if rules := s.Filter(); len(rules) > 0 {
+		if _, err := filter.New(rules...); err != nil {
+			return fmt.Errorf("subscriber %s: %v", s.ID, err)
+		}
+	}
+	return nil
+}
+
+// Filter returns the relay filter that narrows the feed of the subscriber,
+// or nil if the subscriber receives the whole feed. The host scope is
+// matched against the host tag.
+func (s *Subscriber) Filter() []filter.Rule {
+	var rules []filter.Rule
+	for _, sel := range s.Exclude {
+		rules = append(rules, filter.Rule{
+			Match: []string{sel.Metric},
+			Block: true,
+		})
+	}
+	if len(s.Include) == 0 && len(s.Scope) == 0 {
+		return rules
+	}
+	metrics := []string{""}
+	if len(s.Include) > 0 {
+		metrics = nil
+		for _, sel := range s.Include {
+			metrics = append(metrics, sel.Metric)
+		}
+	}
+	for _, metric := range metrics {
+		if len(s.Scope) == 0 {
+			rules = append(rules, filter.Rule{
+				Match: []string{metric},
+				Pass:  true,
+			})
+			continue
+		}
+		for _, scope := range s.Scope {
+			rules = append(rules, filter.Rule{
+				Match: []string{metric, "host", scope.Host.String()},
+				Pass:  true,
+			})
+		}
+	}
+	return append(rules, filter.Rule{Block: true})
 }
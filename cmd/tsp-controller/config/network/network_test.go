@@ -5,12 +5,16 @@
 package network
 
 import (
+	"encoding/json"
 	"encoding/xml"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+
+	"opentsp.org/internal/tsdb/filter"
 )
 
 var testUnmarshal = []struct {
@@ -33,8 +37,8 @@ var testUnmarshal = []struct {
 </network>
 `,
 		out: Config{
-			Aggregator: &Aggregator{
-				Host: "ahost",
+			Aggregator: []*Aggregator{
+				{Host: "ahost"},
 			},
 			Subscriber: []*Subscriber{
 				{
@@ -66,7 +70,11 @@ func TestUnmarshal(t *testing.T) {
 		if err := xml.NewDecoder(r).Decode(&config); err != nil {
 			t.Fatal(err)
 		}
+		if config.Path() != f.Name() {
+			t.Errorf("#%d. got path %q, want %q", i, config.Path(), f.Name())
+		}
 		config.restrict = nil
+		config.path = ""
 		if !reflect.DeepEqual(config, tt.out) {
 			t.Errorf("#%d. got %+v, want %+v", i, config, tt.out)
 		}
@@ -79,7 +87,182 @@ func TestFileAbsent(t *testing.T) {
 	if err := xml.NewDecoder(r).Decode(&config); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if config.Path() != "/var/empty/foo" {
+		t.Errorf("got path %q, want /var/empty/foo", config.Path())
+	}
+	config.path = ""
 	if !reflect.DeepEqual(config, DefaultConfig) {
 		t.Errorf("got %v, want %v", config, DefaultConfig)
 	}
 }
+
+var testAggregator = []struct {
+	in  string
+	err string
+}{
+	0: {
+		in:  `<network><aggregator/></network>`,
+		err: "aggregator: missing attribute: host",
+	},
+	1: {
+		in: `<network>
+			<aggregator host="a:4242"/>
+			<aggregator host="a:4243"/>
+		</network>`,
+		err: "aggregator a:4243: host redeclared",
+	},
+	2: {
+		in: `<network>
+			<aggregator host="a" cluster="foo.live"/>
+			<aggregator host="b" cluster="bar.live,foo.live"/>
+		</network>`,
+		err: "aggregator b: cluster foo.live assigned twice",
+	},
+	3: {
+		in: `<network>
+			<aggregator host="a" merge="true"/>
+		</network>`,
+		err: "aggregator: merge aggregator requires shard aggregators",
+	},
+	4: {
+		in: `<network>
+			<aggregator host="a"/>
+			<aggregator host="b" merge="true"/>
+			<aggregator host="c" merge="true"/>
+		</network>`,
+		err: "aggregator: at most one merge aggregator allowed",
+	},
+	5: {
+		in: `<network>
+			<aggregator host="a"/>
+			<aggregator host="b" merge="true" cluster="foo.live"/>
+		</network>`,
+		err: "aggregator b: merge aggregator may not list clusters",
+	},
+}
+
+func TestAggregatorValidate(t *testing.T) {
+	for i, tt := range testAggregator {
+		f, err := ioutil.TempFile("", "aggregatortest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.Write([]byte(tt.in)); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		_, err = ReadFile(f.Name())
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("#%d. invalid error, got: %v, want: %s", i, err, tt.err)
+		}
+	}
+}
+
+func TestAggregatorFor(t *testing.T) {
+	config := &Config{
+		Aggregator: []*Aggregator{
+			{Host: "a:4242", Cluster: "foo.live, bar.live"},
+			{Host: "b:4242"},
+			{Host: "c:4242"},
+			{Host: "d:4242", Merge: true},
+		},
+	}
+	if a := config.AggregatorFor("foo001", "foo.live"); a.Host != "a:4242" {
+		t.Errorf("foo001: got %s, want a:4242", a.Host)
+	}
+	count := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		host := fmt.Sprintf("baz%03d", i)
+		a := config.AggregatorFor(host, "baz.live")
+		if again := config.AggregatorFor(host, "baz.live"); again != a {
+			t.Fatalf("%s: inconsistent assignment", host)
+		}
+		count[a.Host]++
+	}
+	if count["a:4242"] != 0 || count["d:4242"] != 0 {
+		t.Errorf("hashed onto cluster or merge aggregator: %v", count)
+	}
+	if count["b:4242"] < 400 || count["c:4242"] < 400 {
+		t.Errorf("unbalanced assignment: %v", count)
+	}
+	// Removing an aggregator moves only the hosts assigned to it.
+	smaller := &Config{Aggregator: config.Aggregator[:2]}
+	for i := 0; i < 1000; i++ {
+		host := fmt.Sprintf("baz%03d", i)
+		if a := config.AggregatorFor(host, ""); a.Host == "b:4242" {
+			if b := smaller.AggregatorFor(host, ""); b.Host != "b:4242" {
+				t.Fatalf("%s: moved from b to %s", host, b.Host)
+			}
+		}
+	}
+	if a := (&Config{}).AggregatorFor("foo001", "foo.live"); a != nil {
+		t.Errorf("got %v, want nil", a)
+	}
+	if a := config.FindAggregator("d"); a != config.Aggregator[3] {
+		t.Errorf("FindAggregator: got %v", a)
+	}
+}
+
+func TestSubscriberFilter(t *testing.T) {
+	f, err := ioutil.TempFile("", "subscribertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+<network>
+	<subscriber id="all" host="a"/>
+	<subscriber id="capacity" host="b">
+		<include metric="^proc\."/>
+		<include metric="^net\."/>
+		<exclude metric="^proc\.debug\."/>
+		<scope host="\.ld5\."/>
+	</subscriber>
+	<subscriber id="nodebug" host="c">
+		<exclude metric="\.debug\."/>
+	</subscriber>
+</network>`)
+	f.Close()
+	config, err := ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`[]`,
+		`[{"Match":["^proc\\.debug\\."],"Block":true},` +
+			`{"Match":["^proc\\.","host","\\.ld5\\."],"Pass":true},` +
+			`{"Match":["^net\\.","host","\\.ld5\\."],"Pass":true},` +
+			`{"Block":true}]`,
+		`[{"Match":["\\.debug\\."],"Block":true}]`,
+	}
+	for i, s := range config.Subscriber {
+		rules := s.Filter()
+		if rules == nil {
+			rules = []filter.Rule{}
+		}
+		buf, _ := json.Marshal(rules)
+		if string(buf) != want[i] {
+			t.Errorf("%s: invalid filter\ngot:  %s\nwant: %s", s.ID, buf, want[i])
+		}
+	}
+}
+
+func TestSubscriberValidate(t *testing.T) {
+	for i, in := range []string{
+		`<subscriber id="s"><include/></subscriber>`,
+		`<subscriber id="s"><exclude metric="("/></subscriber>`,
+		`<subscriber id="s"><include metric="(a|b)"/><scope host="(c|d)"/></subscriber>`,
+	} {
+		f, err := ioutil.TempFile("", "subscribertest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString("<network>" + in + "</network>")
+		f.Close()
+		if _, err := ReadFile(f.Name()); err == nil || !strings.Contains(err.Error(), "subscriber s: ") {
+			t.Errorf("#%d. invalid error: %v", i, err)
+		}
+	}
+}
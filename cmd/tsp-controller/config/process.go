@@ -0,0 +1,120 @@
+// Copyright 2014 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+
+	"opentsp.org/internal/tsdb"
+)
+
+// A Process corresponds to a host-level <process> configuration element.
+//
+// Processes are served by collect-jmx as lookup keys. The optional Name and
+// Cmdline regular expressions select the process for the built-in process
+// metrics collector of tsp-forwarder(8).
+type Process struct {
+	ID      string
+	Name    string
+	Cmdline string
+}
+
+var _ = xml.Unmarshaler(&Process{})
+
+func (p *Process) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	var v struct {
+		XMLName xml.Name  `xml:"process"`
+		ID      string    `xml:"id,attr"`
+		Name    string    `xml:"name,attr"`
+		Cmdline string    `xml:"cmdline,attr"`
+		Any     *xml.Name `xml:",any"`
+	}
+	if err := dec.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	if any := v.Any; any != nil {
+		return fmt.Errorf("process %s: invalid element: %s", v.ID, any.Local)
+	}
+	*p = Process{
+		ID:      v.ID,
+		Name:    v.Name,
+		Cmdline: v.Cmdline,
+	}
+	return nil
+}
+
+func (p *Process) validate() error {
+	if p.ID == "" {
+		return fmt.Errorf("missing process attribute: id")
+	}
+	if _, err := regexp.Compile(p.Name); err != nil {
+		return fmt.Errorf("process %s: invalid name: %v", p.ID, err)
+	}
+	if _, err := regexp.Compile(p.Cmdline); err != nil {
+		return fmt.Errorf("process %s: invalid cmdline: %v", p.ID, err)
+	}
+	// Selected processes are reported by tsp-forwarder(8) using the id as
+	// a tag value.
+	if (p.Name != "" || p.Cmdline != "") && tsdb.Clean(p.ID) != p.ID {
+		return fmt.Errorf("process %s: invalid id", p.ID)
+	}
+	return nil
+}
+
+// Processes returns the host's <process> elements.
+func (h *Host) Processes() []*Process {
+	var processes []*Process
+	for _, elem := range h.Extra {
+		if p, ok := elem.Value.(*Process); ok {
+			processes = append(processes, p)
+		}
+	}
+	return processes
+}
+
+// validateProcess decodes host-level <process> elements. Process ids share
+// the namespace of hosts and hostgroups.
+func validateProcess(config *Config) error {
+	global := make(Namespace)
+	before := make(Namespace)
+	for id := range config.Hosts.NS {
+		before[id] = true
+	}
+	local := make(map[string]Namespace)
+	err := config.UpdateHost(func(hostID string, elem *Element) error {
+		if elem.Name != "process" {
+			return nil
+		}
+		p := new(Process)
+		if err := xml.Unmarshal(elem.Raw, p); err != nil {
+			return nil // reported as unsupported element
+		}
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("host %s: %v", hostID, err)
+		}
+		if local[hostID] == nil {
+			local[hostID] = make(Namespace)
+		}
+		if err := local[hostID].Add(p.ID); err != nil {
+			return fmt.Errorf("host %s: process redeclared: %s", hostID, p.ID)
+		}
+		config.Hosts.NS[p.ID] = true
+		global[p.ID] = true
+		elem.Value = p
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Ensure no naming ambiguity exists.
+	for id := range global {
+		if before[id] {
+			return fmt.Errorf("identifier redeclared: %v", id)
+		}
+	}
+	return nil
+}
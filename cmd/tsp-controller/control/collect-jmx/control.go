@@ -31,13 +31,19 @@ const (
 
 func init() {
 	control.Register(Update, Handle)
+	control.RegisterView("collect-jmx", func(config *config.Config, host string) (interface{}, error) {
+		h := &handler{config}
+		view, err := h.View(&Key{"collect-jmx", host, ""})
+		if err != nil {
+			return nil, err
+		}
+		return view, nil
+	})
+	config.RegisterCheck(checkQueryGroups)
 }
 
 // Update decodes the collect-jmx parts of the config.
 func Update(config *config.Config) error {
-	if err := updateHost(config); err != nil {
-		return err
-	}
 	if err := updateGlobal(config); err != nil {
 		return err
 	}
@@ -66,46 +72,6 @@ func updateGlobal(config_ *config.Config) error {
 	return nil
 }
 
-// updateHost extends the provided common config with host-level Process elements.
-func updateHost(config_ *config.Config) error {
-	global := make(config.Namespace)
-	before := make(config.Namespace)
-	for id := range config_.Hosts.NS {
-		before[id] = true
-	}
-	// Decode <process> elements.
-	err := config_.UpdateHost(func(hostID string, elem *config.Element) error {
-		p := new(Process)
-		if err := xml.Unmarshal(elem.Raw, p); err != nil {
-			return nil
-		}
-		if err := p.validate(); err != nil {
-			return fmt.Errorf("host %s: %v", hostID, err)
-		}
-		config_.Hosts.NS[p.ID] = true
-		global[p.ID] = true
-		elem.Value = p
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-	// Ensure no naming ambiguity exists.
-	count := make(map[string]int)
-	for id := range global {
-		count[id]++
-	}
-	for id := range before {
-		count[id]++
-	}
-	for id := range count {
-		if count[id] > 1 {
-			return fmt.Errorf("identifier redeclared: %v", id)
-		}
-	}
-	return nil
-}
-
 // A QueryGroup corresponds to a <querygroup> configuration element.
 type QueryGroup struct {
 	ID      string
@@ -176,11 +142,7 @@ func (g *QueryGroup) validate(config *config.Config) error {
 					seen[host] = true
 				}
 			}
-			for _, elem := range host.Extra {
-				process, ok := elem.Value.(*Process)
-				if !ok {
-					continue
-				}
+			for _, process := range host.Processes() {
 				if process.ID == targetID {
 					if seen[process] {
 						return fmt.Errorf("process %s: query redeclared", host.ID)
@@ -198,43 +160,39 @@ func (g *QueryGroup) validate(config *config.Config) error {
 	return nil
 }
 
-// A Process corresponds to a <process> configuration element.
-type Process struct {
-	ID string
-}
-
-var _ = xml.Unmarshaler(&Process{})
-
-func (p *Process) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
-	var v struct {
-		XMLName xml.Name  `xml:"process"`
-		ID      string    `xml:"id,attr"`
-		Any     *xml.Name `xml:",any"`
-	}
-	if err := dec.DecodeElement(&v, &start); err != nil {
-		return err
-	}
-	if any := v.Any; any != nil {
-		return fmt.Errorf("process %s: invalid element: %s", p.ID, any.Local)
+// checkQueryGroups warns about querygroups that match no hosts or processes.
+func checkQueryGroups(config *config.Config) []error {
+	var warnings []error
+	for _, elem := range config.Extra {
+		group, ok := elem.Value.(*QueryGroup)
+		if !ok || matchesAny(group, config) {
+			continue
+		}
+		warnings = append(warnings, fmt.Errorf("querygroup %s: matches no hosts", group.ID))
 	}
-	*p = Process{ID: v.ID}
-	return nil
+	return warnings
 }
 
-func (p *Process) validate() error {
-	if p.ID == "" {
-		return fmt.Errorf("missing process attribute: id")
+func matchesAny(group *QueryGroup, config *config.Config) bool {
+	for _, host := range config.Hosts.All {
+		if group.Match(host.Tags...) {
+			return true
+		}
+		for _, p := range host.Processes() {
+			if group.Match(p.ID) {
+				return true
+			}
+		}
 	}
-	// XXX: check namespace conflict
-	return nil
+	return false
 }
 
 // Handle registers the HTTP handlers.
-func Handle(config *config.Config) error {
+func Handle(mux *http.ServeMux, config *config.Config) error {
 	handler := &handler{config}
-	http.Handle("/control/v1/collect-jmx", handler)
-	http.Handle("/config/betex", handler) // legacy
-	http.Handle("/config/jmx", handler)   // legacy
+	mux.Handle("/control/v1/collect-jmx", handler)
+	mux.Handle("/config/betex", handler) // legacy
+	mux.Handle("/config/jmx", handler)   // legacy
 	return nil
 }
 
@@ -338,7 +296,10 @@ func (h *handler) View(key *Key) (*View, error) {
 func hostQueries(host *config.Host, config *config.Config) []*Query {
 	var found []*Query
 	for _, elem := range config.Extra {
-		group := elem.Value.(*QueryGroup)
+		group, ok := elem.Value.(*QueryGroup)
+		if !ok {
+			continue
+		}
 		if group.Match(host.Tags...) {
 			found = append(found, group.Query...)
 		}
@@ -352,7 +313,10 @@ func processQueries(host *config.Host, config *config.Config, processID string)
 	}
 	var found []*Query
 	for _, elem := range config.Extra {
-		group := elem.Value.(*QueryGroup)
+		group, ok := elem.Value.(*QueryGroup)
+		if !ok {
+			continue
+		}
 		if group.Match(processID) {
 			found = append(found, group.Query...)
 		}
@@ -363,8 +327,8 @@ func processQueries(host *config.Host, config *config.Config, processID string)
 // hasProcess reports if the given host contains a process subelement
 // with the given id.
 func hasProcess(host *config.Host, id string) bool {
-	for _, extra := range host.Extra {
-		if p, ok := extra.Value.(*Process); ok && p.ID == id {
+	for _, p := range host.Processes() {
+		if p.ID == id {
 			return true
 		}
 	}
@@ -58,23 +58,7 @@ var testUpdate = []struct {
 		</cluster>
 	</hostgroup>
 </config>`,
-		// TODO: should reject.
-		// err: "identifier redeclared: foo.process",
-		out: makeConfig([]*config.Host{
-			{
-				ID:        "foo001",
-				ClusterID: "foo.live",
-				Tags:      []string{"foo", "foo.live", "foo001"},
-				Extra: makeExtra([]*Process{
-					{
-						ID: "foo.process",
-					},
-					{
-						ID: "foo.process",
-					},
-				}),
-			},
-		}),
+		err: "host foo001: process redeclared: foo.process",
 	},
 	3: {
 		in: `
@@ -92,7 +76,7 @@ var testUpdate = []struct {
 				ID:        "foo001",
 				ClusterID: "foo.live",
 				Tags:      []string{"foo", "foo.live", "foo001"},
-				Extra: makeExtra([]*Process{
+				Extra: makeExtra([]*config.Process{
 					{
 						ID: "foo.process",
 					},
@@ -320,12 +304,12 @@ var testUpdate = []struct {
 						Tags:      []string{"foo", "foo.live", "foo001"},
 						Extra: []*config.Element{
 							{
-								Value: &Process{
+								Value: &config.Process{
 									ID: "a",
 								},
 							},
 							{
-								Value: &Process{
+								Value: &config.Process{
 									ID: "b",
 								},
 							},
@@ -608,7 +592,7 @@ var testUpdate = []struct {
 				ID:        "foo001",
 				ClusterID: "foo.live",
 				Tags:      []string{"foo", "foo.live", "foo001"},
-				Extra: makeExtra([]*Process{
+				Extra: makeExtra([]*config.Process{
 					{
 						ID: "foo.process",
 					},
@@ -618,7 +602,7 @@ var testUpdate = []struct {
 				ID:        "foo002",
 				ClusterID: "foo.live",
 				Tags:      []string{"foo", "foo.live", "foo002"},
-				Extra: makeExtra([]*Process{
+				Extra: makeExtra([]*config.Process{
 					{
 						ID: "foo.process",
 					},
@@ -626,6 +610,58 @@ var testUpdate = []struct {
 			},
 		}),
 	},
+	24: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001">
+				<process id="foo.process" name="^java$" cmdline="-Dapp=foo "/>
+			</host>
+		</cluster>
+	</hostgroup>
+</config>`,
+		out: makeConfig([]*config.Host{
+			{
+				ID:        "foo001",
+				ClusterID: "foo.live",
+				Tags:      []string{"foo", "foo.live", "foo001"},
+				Extra: makeExtra([]*config.Process{
+					{
+						ID:      "foo.process",
+						Name:    "^java$",
+						Cmdline: "-Dapp=foo ",
+					},
+				}),
+			},
+		}),
+	},
+	25: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001">
+				<process id="foo.process" cmdline="("/>
+			</host>
+		</cluster>
+	</hostgroup>
+</config>`,
+		err: "host foo001: process foo.process: invalid cmdline",
+	},
+	26: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001">
+				<process id="foo process" name="^java$"/>
+			</host>
+		</cluster>
+	</hostgroup>
+</config>`,
+		err: "host foo001: process foo process: invalid id",
+	},
 	/*
 	   	{
 	   		in: `
@@ -695,7 +731,7 @@ func TestUpdate(t *testing.T) {
 func makeExtra(v interface{}) []*config.Element {
 	var rv []*config.Element
 	switch v := v.(type) {
-	case []*Process:
+	case []*config.Process:
 		for _, p := range v {
 			rv = append(rv, &config.Element{
 				Value: p,
@@ -750,3 +786,37 @@ func TestNoSuchProcess(t *testing.T) {
 		t.Errorf("unexpected result\ngot:  %+v\nwant: []", view.Objects[0])
 	}
 }
+
+func TestCheckQueryGroups(t *testing.T) {
+	cfg, err := config.Decode(strings.NewReader(`
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001">
+				<process id="foo.process"/>
+			</host>
+		</cluster>
+		<cluster id="foo.test"/>
+	</hostgroup>
+	<querygroup id="host" targets="foo">
+		<query id="x" on="X:name=*"/>
+	</querygroup>
+	<querygroup id="process" targets="foo.process">
+		<query id="x" on="X:name=*"/>
+	</querygroup>
+	<querygroup id="empty" targets="foo.test">
+		<query id="x" on="X:name=*"/>
+	</querygroup>
+</config>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, err := range checkQueryGroups(cfg) {
+		got = append(got, err.Error())
+	}
+	want := []string{"querygroup empty: matches no hosts"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid warnings\ngot:  %q\nwant: %q", got, want)
+	}
+}
@@ -18,6 +18,14 @@ import (
 
 func init() {
 	control.Register(Update, Handle)
+	control.RegisterView("collect-statse", func(config *config.Config, host string) (interface{}, error) {
+		h := &handler{config}
+		view, err := h.View(&Key{"collect-statse", host})
+		if err != nil {
+			return nil, err
+		}
+		return view, nil
+	})
 }
 
 // Update decodes the collect-statse(8) parts of the config.
@@ -36,9 +44,9 @@ func Update(config_ *config.Config) error {
 }
 
 // Handle installs the HTTP handler.
-func Handle(config *config.Config) error {
+func Handle(mux *http.ServeMux, config *config.Config) error {
 	handler := &handler{config}
-	http.Handle("/control/v1/collect-statse", handler)
+	mux.Handle("/control/v1/collect-statse", handler)
 	return nil
 }
 
@@ -110,11 +118,21 @@ func (h *handler) View(key *Key) (*View, error) {
 		return nil, err
 	}
 	view := new(View)
+	// Add declared filter rules.
+	for _, r := range host.Rules(key.Program) {
+		view.Forwarder.Filter = append(view.Forwarder.Filter, &Rule{
+			Match: r.Match,
+			Set:   r.Set,
+			Block: r.Block,
+		})
+	}
 	// Load custom filter rules.
-	err = h.config.Filter.Run(&view.Forwarder.Filter, key.Program, host.ID, host.ClusterID)
+	var custom []*Rule
+	err = h.config.Filter.Run(&custom, key.Program, host.ID, host.ClusterID)
 	if err != nil {
 		return nil, &internalError{err}
 	}
+	view.Forwarder.Filter = append(view.Forwarder.Filter, custom...)
 	// Add host tag if missing.
 	view.Forwarder.Filter = append(view.Forwarder.Filter, &Rule{
 		Match: []string{"", "host", "^$"},
@@ -127,6 +145,16 @@ func (h *handler) View(key *Key) (*View, error) {
 			Set:   []string{"", "cluster", cluster},
 		})
 	}
+	// Add label tags if missing.
+	for _, l := range host.Labels() {
+		if !l.Tag {
+			continue
+		}
+		view.Forwarder.Filter = append(view.Forwarder.Filter, &Rule{
+			Match: []string{"", l.Name, "^$"},
+			Set:   []string{"", l.Name, l.Value},
+		})
+	}
 	// Set aggregator host.
 	if ahost, ok := aggregatorHost(host, h.config); ok {
 		view.Forwarder.AggregatorHost = ahost
@@ -8,12 +8,14 @@ package control
 import (
 	"encoding/json"
 	"net/http"
+	"sync/atomic"
 
 	"opentsp.org/cmd/tsp-controller/config"
 )
 
 type module struct {
-	update, handle func(*config.Config) error
+	update func(*config.Config) error
+	handle func(*http.ServeMux, *config.Config) error
 }
 
 var modules []*module
@@ -24,24 +26,66 @@ var modules []*module
 // settings. It typically decodes ExtraRaw fields, storing decoded data in
 // the corresponding Extra field.
 //
-// The handle function registers module-specific HTTP handlers. It is
-// passed a config that has already been updated using the update
-// function.
-func Register(update, handle func(*config.Config) error) {
+// The handle function registers module-specific HTTP handlers with the
+// given mux. It is passed a config that has already been updated using the
+// update function. It is called again whenever the config is reloaded.
+func Register(update func(*config.Config) error, handle func(*http.ServeMux, *config.Config) error) {
 	modules = append(modules, &module{update, handle})
 	config.Register(update)
 }
 
 // ListenAndServe is a wrapper for http.ListenAndServe that starts
 // handlers for all registered control modules prior to starting the
-// server. The server caches succesful responses forever.
-func ListenAndServe(addr string, config *config.Config) error {
+// server. The server supports conditional and long-poll requests, see
+// conditional. If a TLS certificate is configured, the server uses TLS.
+//
+// In addition, the server tracks view requests, and serves the fleet status
+// at /status.
+func ListenAndServe(addr string, config_ *config.Config) error {
+	if err := Reload(config_); err != nil {
+		return err
+	}
+	handler := &tracker{&conditional{dispatcher{}}}
+	return listenAndServe(addr, handler, *config.CertFile, *config.KeyFile, *config.ClientCAFile)
+}
+
+// served holds the *http.ServeMux of the config in use, and servedConfig the
+// config itself.
+var served, servedConfig atomic.Value
+
+// Reload replaces the config used by the module handlers, wakes up the
+// pending long-poll requests, and forgets the fetches of hosts that are no
+// longer configured. If the module handlers cannot be registered, the config
+// in use is retained.
+func Reload(config_ *config.Config) error {
+	mux, err := newMux(config_)
+	if err != nil {
+		return err
+	}
+	served.Store(mux)
+	servedConfig.Store(config_)
+	pruneFetches(config_)
+	Notify()
+	return nil
+}
+
+func newMux(config_ *config.Config) (*http.ServeMux, error) {
+	mux := http.NewServeMux()
 	for _, m := range modules {
-		if err := m.handle(config); err != nil {
-			return err
+		if err := m.handle(mux, config_); err != nil {
+			return nil, err
 		}
 	}
-	return http.ListenAndServe(addr, nil)
+	mux.Handle("/status", &statusHandler{config_})
+	mux.Handle("/", http.DefaultServeMux)
+	return mux, nil
+}
+
+// dispatcher passes requests to the mux of the config in use.
+type dispatcher struct{}
+
+func (dispatcher) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	served.Load().(*http.ServeMux).ServeHTTP(w, req)
 }
 
 // Marshal is used by modules to marshal response to a view request.
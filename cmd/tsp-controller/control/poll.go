@@ -0,0 +1,270 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package control
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"opentsp.org/cmd/tsp-controller/config"
+)
+
+const (
+	// maxWait limits the duration of long-poll requests.
+	maxWait = 10 * time.Minute
+
+	// recheckInterval determines how often views of the pending long-poll
+	// requests are re-evaluated. This is required because the view may
+	// depend on external data, for example output of the filter program.
+	recheckInterval = 60 * time.Second
+)
+
+// notifyJitter limits the random delay of the re-evaluations that follow a
+// config change. It spreads the load of the requests woken up at once.
+var notifyJitter = 5 * time.Second
+
+var (
+	changedMu sync.Mutex
+	changed   = make(chan struct{})
+
+	// etags holds the entity tags of the views rendered since the last
+	// config change. Only views of configured hosts are cached, and entries
+	// older than recheckInterval are deleted, so the number of entries is
+	// bounded by the config.
+	etags     = make(map[viewKey]*cachedETag)
+	lastPrune time.Time
+)
+
+// viewKey identifies a view: the program path, the requested host id, and
+// the remaining query parameters, if any.
+type viewKey struct {
+	path, host, query string
+}
+
+// parseViewKey returns the key of the view requested by req. It returns false
+// if the request is not for a host configured in the config in use.
+func parseViewKey(req *http.Request) (viewKey, bool) {
+	config_, _ := servedConfig.Load().(*config.Config)
+	if config_ == nil {
+		return viewKey{}, false
+	}
+	query := req.URL.Query()
+	host := query.Get("host")
+	if configuredHost(config_, host) == nil {
+		return viewKey{}, false
+	}
+	query.Del("host")
+	return viewKey{req.URL.Path, host, query.Encode()}, true
+}
+
+// cachedETag is the entity tag of a rendered view.
+type cachedETag struct {
+	etag string
+	time time.Time
+}
+
+// Notify wakes up the pending long-poll requests, causing their views to be
+// re-evaluated. It is called whenever the config changes.
+func Notify() {
+	changedMu.Lock()
+	close(changed)
+	changed = make(chan struct{})
+	etags = make(map[viewKey]*cachedETag)
+	changedMu.Unlock()
+}
+
+func changedChan() <-chan struct{} {
+	changedMu.Lock()
+	defer changedMu.Unlock()
+	return changed
+}
+
+// lookupETag returns the entity tag of the given view, if it was rendered
+// less than recheckInterval ago.
+func lookupETag(key viewKey) (string, bool) {
+	changedMu.Lock()
+	defer changedMu.Unlock()
+	cached, ok := etags[key]
+	if !ok || time.Since(cached.time) >= recheckInterval {
+		return "", false
+	}
+	return cached.etag, true
+}
+
+// storeETag records the entity tag of the given view, unless the config
+// changed since the view was rendered, as indicated by the notify channel
+// obtained before rendering. Expired entries are deleted at most every
+// recheckInterval.
+func storeETag(key viewKey, etag string, notify <-chan struct{}) {
+	changedMu.Lock()
+	defer changedMu.Unlock()
+	if notify != changed {
+		return
+	}
+	now := time.Now()
+	etags[key] = &cachedETag{etag, now}
+	if now.Sub(lastPrune) < recheckInterval {
+		return
+	}
+	for key, cached := range etags {
+		if now.Sub(cached.time) >= recheckInterval {
+			delete(etags, key)
+		}
+	}
+	lastPrune = now
+}
+
+// jitter returns a random duration shorter than d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// conditional adds support for conditional and long-poll requests to view
+// handlers.
+//
+// Every successful response carries an ETag header. If the request carries
+// a matching If-None-Match header, the response is 304 Not Modified. If in
+// addition the request includes the wait query parameter, the response is
+// delayed until the view changes or the wait duration elapses, whichever
+// comes first.
+//
+// Only view requests, whose paths start with /control/, are handled; other
+// requests are passed through unchanged.
+//
+// The entity tags of views of configured hosts are cached, so that a view
+// matching the request is not rendered again until the config changes or
+// recheckInterval elapses.
+type conditional struct {
+	handler http.Handler
+}
+
+func (c *conditional) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !strings.HasPrefix(req.URL.Path, "/control/") {
+		c.handler.ServeHTTP(w, req)
+		return
+	}
+	wait, err := parseWait(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	deadline := time.Now().Add(wait)
+	match := req.Header.Get("If-None-Match")
+	key, cacheable := parseViewKey(req)
+	for {
+		notify := changedChan()
+		var (
+			etag string
+			ok   bool
+		)
+		if cacheable {
+			etag, ok = lookupETag(key)
+		}
+		if !ok || etag != match {
+			resp := newResponse()
+			c.handler.ServeHTTP(resp, req)
+			if resp.status != http.StatusOK {
+				resp.flush(w)
+				return
+			}
+			etag = resp.ETag()
+			if cacheable {
+				storeETag(key, etag, notify)
+			}
+			resp.header.Set("ETag", etag)
+			if etag != match {
+				resp.flush(w)
+				return
+			}
+		}
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if recheck := recheckInterval + jitter(recheckInterval/10); remaining > recheck {
+			remaining = recheck
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-notify:
+			// re-evaluate, after a delay that spreads the load
+			timer.Reset(jitter(notifyJitter))
+			select {
+			case <-timer.C:
+			case <-req.Context().Done():
+				timer.Stop()
+				return
+			}
+		case <-timer.C:
+			// re-evaluate
+		case <-req.Context().Done():
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// parseWait extracts the wait query parameter, removing it from the request
+// to keep it hidden from the view handlers.
+func parseWait(req *http.Request) (time.Duration, error) {
+	query := req.URL.Query()
+	s := query.Get("wait")
+	if s == "" {
+		return 0, nil
+	}
+	query.Del("wait")
+	req.URL.RawQuery = query.Encode()
+	wait, err := time.ParseDuration(s)
+	if err != nil || wait < 0 || wait > maxWait {
+		return 0, fmt.Errorf("invalid query parameter: wait")
+	}
+	return wait, nil
+}
+
+// response is a buffered http.ResponseWriter.
+type response struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponse() *response {
+	return &response{
+		header: make(http.Header),
+		status: http.StatusOK,
+	}
+}
+
+func (r *response) Header() http.Header         { return r.header }
+func (r *response) Write(p []byte) (int, error) { return r.body.Write(p) }
+func (r *response) WriteHeader(status int)      { r.status = status }
+
+// ETag returns the entity tag of the response body.
+func (r *response) ETag() string {
+	h := fnv.New64a()
+	h.Write(r.body.Bytes())
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+// flush writes the buffered response to w.
+func (r *response) flush(w http.ResponseWriter) {
+	for key, val := range r.header {
+		w.Header()[key] = val
+	}
+	w.WriteHeader(r.status)
+	w.Write(r.body.Bytes())
+}
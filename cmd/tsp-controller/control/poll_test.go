@@ -0,0 +1,198 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package control
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"opentsp.org/cmd/tsp-controller/config"
+)
+
+// testView is a handler serving a replaceable view.
+type testView struct {
+	mu      sync.Mutex
+	view    string
+	query   string
+	renders int
+}
+
+func (v *testView) set(view string) {
+	v.mu.Lock()
+	v.view = view
+	v.mu.Unlock()
+}
+
+func (v *testView) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.query = req.URL.RawQuery
+	v.renders++
+	Marshal(w, v.view)
+}
+
+func get(h http.Handler, url, etag string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", url, nil)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestConditional(t *testing.T) {
+	Notify()
+	view := &testView{view: "foo"}
+	h := &conditional{view}
+	resp := get(h, "/control/v1/x?host=a", "")
+	etag := resp.Header().Get("ETag")
+	if resp.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got status %d, etag %q", resp.Code, etag)
+	}
+	resp = get(h, "/control/v1/x?host=a", etag)
+	if resp.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want 304", resp.Code)
+	}
+	view.set("bar")
+	Notify()
+	resp = get(h, "/control/v1/x?host=a", etag)
+	if resp.Code != http.StatusOK || resp.Body.String() != `"bar"` {
+		t.Errorf("got status %d, body %q", resp.Code, resp.Body)
+	}
+	if got := resp.Header().Get("ETag"); got == etag {
+		t.Errorf("etag not updated")
+	}
+}
+
+const testPollConfig = `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="b"/>
+			<host id="c"/>
+		</cluster>
+	</hostgroup>
+</config>`
+
+func reloadPollConfig(t *testing.T) {
+	cfg, err := config.Decode(strings.NewReader(testPollConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConditionalOther(t *testing.T) {
+	Notify()
+	view := &testView{view: "foo"}
+	h := &conditional{view}
+	etag := get(h, "/control/v1/x?host=a", "").Header().Get("ETag")
+	for _, url := range []string{"/status", "/debug/pprof/"} {
+		resp := get(h, url, etag)
+		if resp.Code != http.StatusOK || resp.Header().Get("ETag") != "" {
+			t.Errorf("%s: got status %d, etag %q", url, resp.Code, resp.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestConditionalCache(t *testing.T) {
+	reloadPollConfig(t)
+	view := &testView{view: "foo"}
+	h := &conditional{view}
+	etag := get(h, "/control/v1/x?host=b", "").Header().Get("ETag")
+	// Matching requests are answered using the cached etag.
+	for i := 0; i < 3; i++ {
+		if resp := get(h, "/control/v1/x?host=b&wait=10ms", etag); resp.Code != http.StatusNotModified {
+			t.Errorf("got status %d, want 304", resp.Code)
+		}
+	}
+	if view.renders != 1 {
+		t.Errorf("got %d renders, want 1", view.renders)
+	}
+	// Other requests are not.
+	if resp := get(h, "/control/v1/x?host=b", `"other"`); resp.Code != http.StatusOK {
+		t.Errorf("got status %d, want 200", resp.Code)
+	}
+	if resp := get(h, "/control/v1/x?host=c", etag); resp.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want 304", resp.Code)
+	}
+	if view.renders != 3 {
+		t.Errorf("got %d renders, want 3", view.renders)
+	}
+	// A config change invalidates the cache.
+	view.set("bar")
+	Notify()
+	if resp := get(h, "/control/v1/x?host=b", etag); resp.Code != http.StatusOK || resp.Body.String() != `"bar"` {
+		t.Errorf("got status %d, body %q", resp.Code, resp.Body)
+	}
+}
+
+func TestConditionalCacheBound(t *testing.T) {
+	reloadPollConfig(t)
+	view := &testView{view: "foo"}
+	h := &conditional{view}
+	// Views of unconfigured hosts are not cached.
+	etag := get(h, "/control/v1/x?host=unknown", "").Header().Get("ETag")
+	get(h, "/control/v1/x?host=unknown", etag)
+	if view.renders != 2 {
+		t.Errorf("got %d renders, want 2", view.renders)
+	}
+	if len(etags) != 0 {
+		t.Errorf("got %d cached etags, want 0", len(etags))
+	}
+	// Expired entries are deleted.
+	get(h, "/control/v1/x?host=b", "")
+	changedMu.Lock()
+	etags[viewKey{"/control/v1/x", "b", ""}].time = time.Now().Add(-recheckInterval)
+	lastPrune = time.Time{}
+	changedMu.Unlock()
+	get(h, "/control/v1/x?host=c", "")
+	if _, ok := etags[viewKey{"/control/v1/x", "b", ""}]; ok || len(etags) != 1 {
+		t.Errorf("expired etag not deleted: %v", etags)
+	}
+}
+
+func TestLongPoll(t *testing.T) {
+	defer func(d time.Duration) { notifyJitter = d }(notifyJitter)
+	notifyJitter = 10 * time.Millisecond
+	Notify()
+	view := &testView{view: "foo"}
+	h := &conditional{view}
+	etag := get(h, "/control/v1/x?host=a", "").Header().Get("ETag")
+	// Timeout.
+	start := time.Now()
+	resp := get(h, "/control/v1/x?host=a&wait=50ms", etag)
+	if resp.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want 304", resp.Code)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("returned too early: %v", d)
+	}
+	if view.query != "host=a" {
+		t.Errorf("wait parameter passed to view handler: %q", view.query)
+	}
+	// Change.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		view.set("bar")
+		Notify()
+	}()
+	resp = get(h, "/control/v1/x?host=a&wait=1m", etag)
+	if resp.Code != http.StatusOK || resp.Body.String() != `"bar"` {
+		t.Errorf("got status %d, body %q", resp.Code, resp.Body)
+	}
+	// Invalid.
+	resp = get(h, "/control/v1/x?host=a&wait=forever", etag)
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want 400", resp.Code)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package control
+
+import (
+	"html/template"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"opentsp.org/cmd/tsp-controller/config"
+)
+
+// Fetch records the last view request of a host/program pair. Host is the id
+// of the configured host that the request resolved to.
+type Fetch struct {
+	Host     string
+	Program  string
+	Time     time.Time
+	Age      string `json:",omitempty"`
+	ViewHash string
+	Agent    string
+	Addr     string
+}
+
+type fetchKey struct {
+	host, program string
+}
+
+var fetches = struct {
+	sync.Mutex
+	m map[fetchKey]*Fetch
+}{
+	m: make(map[fetchKey]*Fetch),
+}
+
+func recordFetch(f *Fetch) {
+	fetches.Lock()
+	defer fetches.Unlock()
+	fetches.m[fetchKey{f.Host, f.Program}] = f
+}
+
+// pruneFetches forgets the hosts that are not configured in the given config.
+func pruneFetches(config_ *config.Config) {
+	configured := make(map[string]bool)
+	for _, host := range config_.Hosts.All {
+		configured[host.ID] = true
+	}
+	fetches.Lock()
+	defer fetches.Unlock()
+	for key := range fetches.m {
+		if !configured[key.host] {
+			delete(fetches.m, key)
+		}
+	}
+}
+
+// configuredHost returns the configured host that the given host id resolves
+// to, as in view lookups. It returns nil if there is no such host.
+func configuredHost(config_ *config.Config, id string) *config.Host {
+	if id == "" || config_.Ambiguous(id) {
+		return nil
+	}
+	host, err := config_.Host(id)
+	if err != nil {
+		return nil
+	}
+	for _, registered := range config_.Hosts.All {
+		if registered == host {
+			return host
+		}
+	}
+	return nil
+}
+
+// tracker records view requests that succeed, including those that result
+// in 304 Not Modified. Requests for hosts that are not configured in the
+// config in use are not recorded, so the number of records is bounded by the
+// config.
+type tracker struct {
+	handler http.Handler
+}
+
+func (t *tracker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !strings.HasPrefix(req.URL.Path, "/control/") {
+		t.handler.ServeHTTP(w, req)
+		return
+	}
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	t.handler.ServeHTTP(sw, req)
+	if sw.status != http.StatusOK && sw.status != http.StatusNotModified {
+		return
+	}
+	config_, _ := servedConfig.Load().(*config.Config)
+	if config_ == nil {
+		return
+	}
+	host := configuredHost(config_, req.URL.Query().Get("host"))
+	if host == nil {
+		return
+	}
+	program := path.Base(req.URL.Path)
+	if strings.HasPrefix(program, "tsdb-") {
+		program = strings.Replace(program, "tsdb-", "tsp-", 1)
+	}
+	recordFetch(&Fetch{
+		Host:     host.ID,
+		Program:  program,
+		Time:     time.Now(),
+		ViewHash: strings.Trim(w.Header().Get("ETag"), `"`),
+		Agent:    req.UserAgent(),
+		Addr:     req.RemoteAddr,
+	})
+}
+
+// statusWriter is a http.ResponseWriter that records the status code.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Status represents the fleet status page.
+type Status struct {
+	// Config reports the outcome of config reloads.
+	Config config.ReloadStatus
+
+	// Fetch lists the last view requests, ordered by host and program.
+	Fetch []*Fetch
+
+	// NeverSeen lists the configured hosts that have never requested a view.
+	NeverSeen []string
+}
+
+// newStatus returns the fleet status.
+func newStatus(config_ *config.Config, now time.Time) *Status {
+	status := &Status{
+		Config: config.GetReloadStatus(),
+	}
+	seen := make(map[string]bool)
+	fetches.Lock()
+	for _, f := range fetches.m {
+		cp := *f
+		cp.Age = now.Sub(f.Time).Truncate(time.Second).String()
+		status.Fetch = append(status.Fetch, &cp)
+		seen[f.Host] = true
+	}
+	fetches.Unlock()
+	sort.Slice(status.Fetch, func(i, j int) bool {
+		a, b := status.Fetch[i], status.Fetch[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.Program < b.Program
+	})
+	for _, host := range config_.Hosts.All {
+		if !seen[host.ID] && config_.Network.InScope(host.ID) {
+			status.NeverSeen = append(status.NeverSeen, host.ID)
+		}
+	}
+	sort.Strings(status.NeverSeen)
+	return status
+}
+
+// statusHandler serves the fleet status page, in JSON or, if requested using
+// the format=html query parameter or the Accept header, in HTML.
+type statusHandler struct {
+	config *config.Config
+}
+
+func (h *statusHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	status := newStatus(h.config, time.Now())
+	if req.URL.Query().Get("format") == "html" || strings.Contains(req.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusTemplate.Execute(w, status); err != nil {
+			panic(err)
+		}
+		return
+	}
+	Marshal(w, status)
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>tsp-controller status</title></head>
+<body>
+<h1>Config</h1>
+<p>Loaded {{.Config.Loaded.Format "2006-01-02 15:04:05"}}.
+{{with .Config.Error}}Reload failed at {{$.Config.Attempted.Format "2006-01-02 15:04:05"}}: {{.}}{{end}}</p>
+<h1>Fetches</h1>
+<table>
+<tr><th>Host</th><th>Program</th><th>Time</th><th>Age</th><th>View hash</th><th>Agent</th><th>Address</th></tr>
+{{range .Fetch}}<tr><td>{{.Host}}</td><td>{{.Program}}</td><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Age}}</td><td>{{.ViewHash}}</td><td>{{.Agent}}</td><td>{{.Addr}}</td></tr>
+{{end}}</table>
+<h1>Never seen</h1>
+<ul>
+{{range .NeverSeen}}<li>{{.}}</li>
+{{end}}</ul>
+</body>
+</html>
+`))
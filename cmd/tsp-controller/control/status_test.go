@@ -0,0 +1,130 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package control
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"opentsp.org/cmd/tsp-controller/config"
+)
+
+const testStatusConfig = `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001.example.com"/>
+			<host id="foo002.example.com"/>
+			<host id="foo003.example.com"/>
+			<host id="bar001.example.com"/>
+			<host id="bar001.example.org"/>
+		</cluster>
+	</hostgroup>
+</config>`
+
+func TestStatus(t *testing.T) {
+	cfg, err := config.Decode(strings.NewReader(testStatusConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+	view := &testView{view: "foo"}
+	h := &tracker{&conditional{view}}
+	for _, url := range []string{
+		"/control/v1/tsp-forwarder?host=foo001.example.com",
+		"/control/v1/tsdb-poller?host=foo002",
+		"/control/v1/tsp-poller?host=foo002.example.com",
+		"/control/v1/tsp-forwarder?host=unknown",
+		"/control/v1/tsp-forwarder?host=bar001",
+		"/control/v1/tsp-forwarder",
+	} {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("User-Agent", "tsp-forwarder/1.0.0 (git=abc)")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	status := newStatus(cfg, time.Now())
+	var got []string
+	for _, f := range status.Fetch {
+		got = append(got, f.Host+" "+f.Program+" "+f.Agent)
+		if f.ViewHash == "" {
+			t.Errorf("%s: missing view hash", f.Host)
+		}
+	}
+	want := []string{
+		"foo001.example.com tsp-forwarder tsp-forwarder/1.0.0 (git=abc)",
+		"foo002.example.com tsp-poller tsp-forwarder/1.0.0 (git=abc)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid fetches\ngot:  %q\nwant: %q", got, want)
+	}
+	if want := []string{"bar001.example.com", "bar001.example.org", "foo003.example.com"}; !reflect.DeepEqual(status.NeverSeen, want) {
+		t.Errorf("got never seen %q, want %q", status.NeverSeen, want)
+	}
+	// Serve JSON and HTML.
+	w := httptest.NewRecorder()
+	(&statusHandler{cfg}).ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+	var decoded Status
+	if err := json.Unmarshal(w.Body.Bytes(), &decoded); err != nil || len(decoded.Fetch) != 2 {
+		t.Errorf("invalid JSON status: %v: %s", err, w.Body)
+	}
+	w = httptest.NewRecorder()
+	(&statusHandler{cfg}).ServeHTTP(w, httptest.NewRequest("GET", "/status?format=html", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") || w.Code != http.StatusOK {
+		t.Errorf("invalid HTML status: %d %s", w.Code, ct)
+	}
+	// Forget the hosts removed from config.
+	cfg, err = config.Decode(strings.NewReader(strings.Replace(testStatusConfig, `<host id="foo001.example.com"/>`, "", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+	status = newStatus(cfg, time.Now())
+	if len(status.Fetch) != 1 || status.Fetch[0].Host != "foo002.example.com" {
+		t.Errorf("removed host not forgotten: %+v", status.Fetch)
+	}
+}
+
+func TestReload(t *testing.T) {
+	for _, host := range []string{"bar001", "bar002"} {
+		cfg, err := config.Decode(strings.NewReader(`
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="` + host + `"/>
+		</cluster>
+	</hostgroup>
+</config>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		notify := changedChan()
+		if err := Reload(cfg); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-notify:
+		default:
+			t.Errorf("long-poll requests not notified")
+		}
+		w := httptest.NewRecorder()
+		dispatcher{}.ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+		var status Status
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{host}; !reflect.DeepEqual(status.NeverSeen, want) {
+			t.Errorf("got never seen %q, want %q", status.NeverSeen, want)
+		}
+	}
+}
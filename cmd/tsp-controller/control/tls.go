@@ -0,0 +1,92 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package control
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"opentsp.org/cmd/tsp-controller/config"
+)
+
+// listenAndServe serves plain HTTP, or HTTPS if certFile is set. If in
+// addition clientCAFile is set, clients must present a certificate signed by
+// one of the listed authorities, and may only request the views of the host
+// named in the certificate.
+func listenAndServe(addr string, handler http.Handler, certFile, keyFile, clientCAFile string) error {
+	switch {
+	case certFile == "" && (keyFile != "" || clientCAFile != ""):
+		return fmt.Errorf("TLS certificate not set")
+	case certFile == "":
+		return http.ListenAndServe(addr, handler)
+	}
+	config := new(tls.Config)
+	if clientCAFile != "" {
+		buf, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return fmt.Errorf("%s: no certificates found", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		handler = &authorizer{handler}
+	}
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: config,
+	}
+	return server.ListenAndServeTLS(certFile, keyFile)
+}
+
+// authorizer rejects requests for views of hosts other than the one named
+// in the client certificate. Requests for other resources, for example the
+// fleet status, only require a valid client certificate.
+type authorizer struct {
+	handler http.Handler
+}
+
+func (a *authorizer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		http.Error(w, "client certificate required", http.StatusForbidden)
+		return
+	}
+	cn := req.TLS.PeerCertificates[0].Subject.CommonName
+	host := req.URL.Query().Get("host")
+	config_ := servedConfig.Load().(*config.Config)
+	if strings.HasPrefix(req.URL.Path, "/control/") && !authorized(config_, cn, host) {
+		log.Printf("control: %s: access denied to host %q", cn, host)
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	a.handler.ServeHTTP(w, req)
+}
+
+// authorized reports whether the certificate common name cn permits access
+// to views of the given host. The host is resolved the way the view handlers
+// resolve it, so a short host name, for example "foo" for "foo.example.com",
+// is accepted if it is unambiguous; the common name must equal the resolved
+// host id.
+func authorized(config *config.Config, cn, host string) bool {
+	if host == "" || config.Ambiguous(host) {
+		return false
+	}
+	resolved, err := config.Host(host)
+	if err != nil {
+		return false
+	}
+	return resolved.ID == cn
+}
@@ -0,0 +1,51 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package control
+
+import (
+	"strings"
+	"testing"
+
+	"opentsp.org/cmd/tsp-controller/config"
+)
+
+var testAuthorized = []struct {
+	cn, host string
+	ok       bool
+}{
+	{"foo.example.com", "foo.example.com", true},
+	{"foo.example.com", "foo", true},
+	{"bar", "bar", true}, // not declared
+	{"foo.example.com", "bar.example.com", false},
+	{"foo.example.com", "bar", false},
+	{"foo.example.com", "foo.example", false},
+	{"foo.example.com", "", false},
+	{"", "", false},
+	{"baz.example.com", "baz", false}, // ambiguous
+	{"baz", "baz", false},
+	{"qux.example.com", "qux", false}, // resolves to qux.other.com
+}
+
+func TestAuthorized(t *testing.T) {
+	cfg, err := config.Decode(strings.NewReader(`
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo.example.com"/>
+			<host id="baz.example.com"/>
+			<host id="baz.other.com"/>
+			<host id="qux.other.com"/>
+		</cluster>
+	</hostgroup>
+</config>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range testAuthorized {
+		if got := authorized(cfg, tt.cn, tt.host); got != tt.ok {
+			t.Errorf("#%d. authorized(%q, %q) = %v, want %v", i, tt.cn, tt.host, got, tt.ok)
+		}
+	}
+}
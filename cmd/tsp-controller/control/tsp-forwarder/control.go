@@ -6,6 +6,8 @@
 package control
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"log"
 	"net"
@@ -21,22 +23,92 @@ import (
 
 func init() {
 	control.Register(Update, Handle)
+	for _, program := range []string{"tsp-forwarder", "tsp-poller", "tsp-aggregator"} {
+		control.RegisterView(program, viewFunc(program))
+	}
+	config.RegisterCheck(checkPolls)
 }
 
 // Update decodes the tsp-forwarder(8) parts of the config.
-func Update(config *config.Config) error {
+func Update(config_ *config.Config) error {
+	if err := updateHost(config_); err != nil {
+		return err
+	}
+	if err := updatePolls(config_); err != nil {
+		return err
+	}
 	return nil
 }
 
+// updateHost decodes the host-level <plugin> elements.
+func updateHost(config_ *config.Config) error {
+	return config_.UpdateHost(func(hostID string, elem *config.Element) error {
+		if elem.Name != "plugin" {
+			return nil
+		}
+		p := new(Plugin)
+		if err := xml.Unmarshal(elem.Raw, p); err != nil {
+			return fmt.Errorf("host %s: plugin: %v", hostID, err)
+		}
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("host %s: %v", hostID, err)
+		}
+		for _, other := range hostPlugins(hostID, config_) {
+			if other.Name == p.Name {
+				return fmt.Errorf("host %s: plugin redeclared: %s", hostID, p.Name)
+			}
+		}
+		elem.Value = p
+		return nil
+	})
+}
+
+// A Plugin corresponds to a <plugin> configuration element. It holds the
+// settings passed to a collection plugin via its control channel.
+type Plugin struct {
+	XMLName xml.Name `xml:"plugin"`
+	Name    string   `xml:"name,attr"`
+	Config  string   `xml:",chardata"`
+}
+
+func (p *Plugin) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("missing plugin attribute: name")
+	}
+	if strings.ContainsAny(p.Name, "/\\") {
+		return fmt.Errorf("plugin %s: invalid name", p.Name)
+	}
+	if !json.Valid([]byte(p.Config)) {
+		return fmt.Errorf("plugin %s: invalid JSON config", p.Name)
+	}
+	return nil
+}
+
+// hostPlugins returns the <plugin> elements of the host with the given id.
+func hostPlugins(hostID string, config *config.Config) []*Plugin {
+	var found []*Plugin
+	for _, host := range config.Hosts.All {
+		if host.ID != hostID {
+			continue
+		}
+		for _, elem := range host.Extra {
+			if p, ok := elem.Value.(*Plugin); ok {
+				found = append(found, p)
+			}
+		}
+	}
+	return found
+}
+
 // Handle registers the HTTP handlers.
-func Handle(config *config.Config) error {
+func Handle(mux *http.ServeMux, config *config.Config) error {
 	handler := &handler{config}
-	http.Handle("/control/v1/tsp-forwarder", handler)
-	http.Handle("/control/v1/tsp-aggregator", handler)
-	http.Handle("/control/v1/tsp-poller", handler)
-	http.Handle("/control/v1/tsdb-forwarder", handler)  // legacy
-	http.Handle("/control/v1/tsdb-aggregator", handler) // legacy
-	http.Handle("/control/v1/tsdb-poller", handler)     // legacy
+	mux.Handle("/control/v1/tsp-forwarder", handler)
+	mux.Handle("/control/v1/tsp-aggregator", handler)
+	mux.Handle("/control/v1/tsp-poller", handler)
+	mux.Handle("/control/v1/tsdb-forwarder", handler)  // legacy
+	mux.Handle("/control/v1/tsdb-aggregator", handler) // legacy
+	mux.Handle("/control/v1/tsdb-poller", handler)     // legacy
 	return nil
 }
 
@@ -65,8 +137,9 @@ func parseKey(url *url.URL) (*Key, error) {
 // Relay corresponds to elements of the Relay setting, see tsp-forwarder(8).
 type Relay struct {
 	Host            string
-	DropRepeats     bool `json:",omitempty"`
-	MaxConnsPerHost int  `json:",omitempty"`
+	DropRepeats     bool    `json:",omitempty"`
+	MaxConnsPerHost int     `json:",omitempty"`
+	Filter          []*Rule `json:",omitempty"`
 }
 
 // Rule corresponds to elements of the Filter setting, see tsp-forwarder(8).
@@ -74,13 +147,30 @@ type Rule struct {
 	Match []string `json:",omitempty"`
 	Set   []string `json:",omitempty"`
 	Block bool     `json:",omitempty"`
+	Pass  bool     `json:",omitempty"`
+}
+
+// Process corresponds to elements of the Process setting, see tsp-forwarder(8).
+type Process struct {
+	ID      string
+	Name    string `json:",omitempty"`
+	Cmdline string `json:",omitempty"`
+}
+
+// ScrapeTarget corresponds to elements of the Scrape setting, see tsp-poller(8).
+type ScrapeTarget struct {
+	URL    string
+	Format string
 }
 
 // View corresponds to tsp-forwarder configuration file, see tsp-forwarder(8).
 type View struct {
 	Filter     []*Rule
 	Relay      map[string]*Relay
-	ListenAddr string `json:",omitempty"`
+	Plugin     map[string]json.RawMessage `json:",omitempty"`
+	Scrape     []*ScrapeTarget            `json:",omitempty"`
+	Process    []*Process                 `json:",omitempty"`
+	ListenAddr string                     `json:",omitempty"`
 }
 
 type internalError struct{ error }
@@ -94,11 +184,21 @@ func newView(key *Key, config *config.Config) (*View, error) {
 	view := &View{
 		Relay: make(map[string]*Relay),
 	}
+	// Add declared filter rules.
+	for _, r := range host.Rules(key.Program) {
+		view.Filter = append(view.Filter, &Rule{
+			Match: r.Match,
+			Set:   r.Set,
+			Block: r.Block,
+		})
+	}
 	// Load custom filter rules.
-	err = config.Filter.Run(&view.Filter, key.Program, host.ID, host.ClusterID)
+	var custom []*Rule
+	err = config.Filter.Run(&custom, key.Program, host.ID, host.ClusterID)
 	if err != nil {
 		return nil, &internalError{err}
 	}
+	view.Filter = append(view.Filter, custom...)
 	// Add host tag if missing.
 	view.Filter = append(view.Filter, &Rule{
 		Match: []string{"", "host", "^$"},
@@ -125,6 +225,21 @@ func (h *handler) View(key *Key) (*View, error) {
 	}
 }
 
+// viewFunc returns the control.ViewFunc of the given program.
+func viewFunc(program string) control.ViewFunc {
+	return func(config *config.Config, host string) (interface{}, error) {
+		if program == "tsp-aggregator" && config.Network.FindAggregator(host) == nil {
+			return nil, nil
+		}
+		h := &handler{config}
+		view, err := h.View(&Key{program, host})
+		if err != nil {
+			return nil, err
+		}
+		return view, nil
+	}
+}
+
 // forwarderView returns a tsp-forwarder(8) view.
 func (h *handler) forwarderView(key *Key) (*View, error) {
 	host, err := h.config.Host(key.Host)
@@ -142,15 +257,19 @@ func (h *handler) forwarderView(key *Key) (*View, error) {
 			Set:   []string{"", "cluster", cluster},
 		})
 	}
+	view.Filter = append(view.Filter, labelRules(host)...)
 	// Feed direct subscribers, typically just OpenTSDB.
 	for _, s := range directSubscribers(h.config) {
 		view.Relay[s.ID] = &Relay{
 			Host:        s.Host,
 			DropRepeats: s.Dedup,
+			Filter:      relayFilter(s),
 		}
 	}
+	view.Plugin = pluginConfig(host.ID, h.config)
+	view.Process = processConfig(host)
 	// Feed indirect subscribers.
-	if aggregator := h.config.Network.Aggregator; aggregator != nil {
+	if aggregator := h.config.Network.AggregatorFor(host.ID, host.ClusterID); aggregator != nil {
 		view.Relay["aggregator"] = &Relay{
 			Host: aggregator.Host,
 		}
@@ -158,6 +277,53 @@ func (h *handler) forwarderView(key *Key) (*View, error) {
 	return view, nil
 }
 
+// labelRules returns rules that add the label tags of the given host, if
+// missing.
+func labelRules(host *config.Host) []*Rule {
+	var rules []*Rule
+	for _, l := range host.Labels() {
+		if !l.Tag {
+			continue
+		}
+		rules = append(rules, &Rule{
+			Match: []string{"", l.Name, "^$"},
+			Set:   []string{"", l.Name, l.Value},
+		})
+	}
+	return rules
+}
+
+// processConfig returns the process groups monitored on the given host. The
+// groups correspond to <process> elements that carry a name or cmdline
+// pattern.
+func processConfig(host *config.Host) []*Process {
+	var found []*Process
+	for _, p := range host.Processes() {
+		if p.Name == "" && p.Cmdline == "" {
+			continue
+		}
+		found = append(found, &Process{
+			ID:      p.ID,
+			Name:    p.Name,
+			Cmdline: p.Cmdline,
+		})
+	}
+	return found
+}
+
+// pluginConfig returns the plugin settings of the given host, or nil if none
+// are declared.
+func pluginConfig(hostID string, config *config.Config) map[string]json.RawMessage {
+	var m map[string]json.RawMessage
+	for _, p := range hostPlugins(hostID, config) {
+		if m == nil {
+			m = make(map[string]json.RawMessage)
+		}
+		m[p.Name] = json.RawMessage(p.Config)
+	}
+	return m
+}
+
 func directSubscribers(config *config.Config) []*network.Subscriber {
 	return subscribers(config, func(s *network.Subscriber) bool {
 		return s.Direct
@@ -170,6 +336,20 @@ func indirectSubscribers(config *config.Config) []*network.Subscriber {
 	})
 }
 
+// relayFilter returns the filter that narrows the feed of the given
+// subscriber, or nil if the subscriber receives the whole feed.
+func relayFilter(s *network.Subscriber) []*Rule {
+	var rules []*Rule
+	for _, r := range s.Filter() {
+		rules = append(rules, &Rule{
+			Match: r.Match,
+			Block: r.Block,
+			Pass:  r.Pass,
+		})
+	}
+	return rules
+}
+
 func subscribers(config *config.Config, match func(*network.Subscriber) bool) []*network.Subscriber {
 	var got []*network.Subscriber
 	for _, s := range config.Network.Subscriber {
@@ -202,10 +382,21 @@ func (h *handler) pollerView(key *Key) (*View, error) {
 			Host:            s.Host,
 			DropRepeats:     s.Dedup,
 			MaxConnsPerHost: pollerMaxConnsPerHost,
+			Filter:          relayFilter(s),
 		}
 	}
+	host, err := h.config.Host(key.Host)
+	if err != nil {
+		return nil, err
+	}
+	view.Filter = append(view.Filter, labelRules(host)...)
+	view.Plugin, err = pollConfig(host, h.config, pluginConfig(host.ID, h.config))
+	if err != nil {
+		return nil, &internalError{err}
+	}
+	view.Scrape = scrapeConfig(host, h.config)
 	// Feed indirect subscribers.
-	if aggregator := h.config.Network.Aggregator; aggregator != nil {
+	if aggregator := h.config.Network.AggregatorFor(host.ID, host.ClusterID); aggregator != nil {
 		view.Relay["aggregator"] = &Relay{
 			Host: aggregator.Host,
 		}
@@ -213,43 +404,40 @@ func (h *handler) pollerView(key *Key) (*View, error) {
 	return view, nil
 }
 
-func isAggregator(host string, config *config.Config) bool {
-	aggregator := config.Network.Aggregator
-	if aggregator == nil {
-		return false
-	}
-	want := aggregator.Host
-	if host, _, err := net.SplitHostPort(want); err == nil {
-		want = host
-	}
-	return host == want
-}
-
-func listenAddr(config *config.Config) string {
-	addr := config.Network.Aggregator.Host
-	if _, port, err := net.SplitHostPort(addr); err == nil {
+func listenAddr(aggregator *network.Aggregator) string {
+	if _, port, err := net.SplitHostPort(aggregator.Host); err == nil {
 		return ":" + port
 	}
 	return ":4242"
 }
 
-// aggregatorView returns a tsp-aggregator(8) view.
+// aggregatorView returns a tsp-aggregator(8) view. A shard aggregator feeds
+// the merge aggregator, if any; otherwise, it feeds the indirect subscribers.
 func (h *handler) aggregatorView(key *Key) (*View, error) {
-	if !isAggregator(key.Host, h.config) {
+	aggregator := h.config.Network.FindAggregator(key.Host)
+	if aggregator == nil {
 		return nil, fmt.Errorf("not an aggregator: %v", key.Host)
 	}
 	view, err := newView(key, h.config)
 	if err != nil {
 		return nil, err
 	}
-	// Feed indirect subscribers.
-	for _, s := range indirectSubscribers(h.config) {
-		view.Relay[s.ID] = &Relay{
-			Host:        s.Host,
-			DropRepeats: s.Dedup,
+	if merger := h.config.Network.Merger(); merger != nil && !aggregator.Merge {
+		// Feed the merge aggregator.
+		view.Relay["aggregator"] = &Relay{
+			Host: merger.Host,
+		}
+	} else {
+		// Feed indirect subscribers.
+		for _, s := range indirectSubscribers(h.config) {
+			view.Relay[s.ID] = &Relay{
+				Host:        s.Host,
+				DropRepeats: s.Dedup,
+				Filter:      relayFilter(s),
+			}
 		}
 	}
-	view.ListenAddr = listenAddr(h.config)
+	view.ListenAddr = listenAddr(aggregator)
 	return view, nil
 }
 
@@ -3,3 +3,842 @@
 // found in the LICENSE file.
 
 package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"opentsp.org/cmd/tsp-controller/config"
+)
+
+var testPlugin = []struct {
+	in   string
+	host string
+	out  map[string]string
+	err  string
+}{
+	0: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001"/>
+		</cluster>
+	</hostgroup>
+</config>`,
+		host: "foo001",
+		out:  nil,
+	},
+	1: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001">
+				<plugin name="netscaler">{"Targets": ["lb001"]}</plugin>
+			</host>
+			<host id="foo002"/>
+		</cluster>
+	</hostgroup>
+</config>`,
+		host: "foo001",
+		out: map[string]string{
+			"netscaler": `{"Targets":["lb001"]}`,
+		},
+	},
+	2: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001">
+				<plugin>{}</plugin>
+			</host>
+		</cluster>
+	</hostgroup>
+</config>`,
+		err: "host foo001: missing plugin attribute: name",
+	},
+	3: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001">
+				<plugin name="netscaler">{</plugin>
+			</host>
+		</cluster>
+	</hostgroup>
+</config>`,
+		err: "host foo001: plugin netscaler: invalid JSON config",
+	},
+	4: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001">
+				<plugin name="netscaler">{}</plugin>
+				<plugin name="netscaler">{}</plugin>
+			</host>
+		</cluster>
+	</hostgroup>
+</config>`,
+		err: "host foo001: plugin redeclared: netscaler",
+	},
+	5: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001">
+				<plugin name="../netscaler">{}</plugin>
+			</host>
+		</cluster>
+	</hostgroup>
+</config>`,
+		err: "host foo001: plugin ../netscaler: invalid name",
+	},
+}
+
+func TestPlugin(t *testing.T) {
+	for i, tt := range testPlugin {
+		cfg, err := config.Decode(strings.NewReader(tt.in))
+		if err != nil {
+			if tt.err == "" {
+				t.Errorf("#%d. unexpected error: %v", i, err)
+				continue
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("#%d. invalid error, got: %s, want: %s", i, err, tt.err)
+				continue
+			}
+			continue
+		}
+		if tt.err != "" {
+			t.Errorf("#%d. unexpected success, want error: %v", i, tt.err)
+			continue
+		}
+		h := &handler{cfg}
+		for _, program := range []string{"tsp-forwarder", "tsp-poller"} {
+			view, err := h.View(&Key{program, tt.host})
+			if err != nil {
+				t.Errorf("#%d. %s: unexpected error: %v", i, program, err)
+				continue
+			}
+			var got map[string]string
+			for name, raw := range view.Plugin {
+				if got == nil {
+					got = make(map[string]string)
+				}
+				buf, err := json.Marshal(raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[name] = string(buf)
+			}
+			if !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("#%d. %s: invalid plugin config\ngot:  %v\nwant: %v", i, program, got, tt.out)
+			}
+		}
+	}
+}
+
+func TestUpdateInvalidXML(t *testing.T) {
+	cfg, err := config.Decode(strings.NewReader(`
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001">
+				<plugin name="netscaler">{}</plugin>
+			</host>
+		</cluster>
+	</hostgroup>
+	<poll plugin="netscaler" pollers="foo.live"/>
+</config>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := cfg.Hosts.All[0].Extra[0]
+	host.Value, host.Raw = nil, []byte(`<plugin name="netscaler">&bogus;</plugin>`)
+	if err := Update(cfg); err == nil || !strings.HasPrefix(err.Error(), "host foo001: plugin: XML syntax error") {
+		t.Errorf("invalid plugin error: %v", err)
+	}
+	host.Value, host.Raw = nil, []byte(`<plugin name="netscaler">{}</plugin>`)
+	poll := cfg.Extra[0]
+	poll.Value, poll.Raw = nil, []byte(`<poll plugin="netscaler">&bogus;</poll>`)
+	if err := Update(cfg); err == nil || !strings.HasPrefix(err.Error(), "poll: XML syntax error") {
+		t.Errorf("invalid poll error: %v", err)
+	}
+}
+
+var testProcess = []struct {
+	in   string
+	host string
+	out  []*Process
+}{
+	0: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001">
+				<process id="foo.jmx"/>
+			</host>
+		</cluster>
+	</hostgroup>
+</config>`,
+		host: "foo001",
+		out:  nil,
+	},
+	1: {
+		in: `
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001">
+				<process id="foo.app" name="^java$" cmdline="-Dapp=foo "/>
+				<process id="foo.jmx"/>
+				<process id="foo.proxy" name="^haproxy$"/>
+			</host>
+			<host id="foo002">
+				<process id="foo.db" name="^postgres$"/>
+			</host>
+		</cluster>
+	</hostgroup>
+</config>`,
+		host: "foo001",
+		out: []*Process{
+			{ID: "foo.app", Name: "^java$", Cmdline: "-Dapp=foo "},
+			{ID: "foo.proxy", Name: "^haproxy$"},
+		},
+	},
+}
+
+func TestProcess(t *testing.T) {
+	for i, tt := range testProcess {
+		cfg, err := config.Decode(strings.NewReader(tt.in))
+		if err != nil {
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		}
+		h := &handler{cfg}
+		view, err := h.View(&Key{"tsp-forwarder", tt.host})
+		if err != nil {
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(view.Process, tt.out) {
+			t.Errorf("#%d. invalid process config\ngot:  %+v\nwant: %+v", i, view.Process, tt.out)
+		}
+	}
+}
+
+func TestRule(t *testing.T) {
+	cfg, err := config.Decode(strings.NewReader(`
+<config>
+	<hostgroup id="foo">
+		<rule><match>^foo\.(.*)</match><set>bar.${1}</set></rule>
+		<cluster id="foo.live">
+			<host id="foo001">
+				<rule block="true"><match>^foo\.debug\.</match></rule>
+			</host>
+		</cluster>
+	</hostgroup>
+</config>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handler{cfg}
+	view, err := h.View(&Key{"tsp-forwarder", "foo001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := json.Marshal(view.Filter)
+	want := `[` +
+		`{"Match":["^foo\\.(.*)"],"Set":["bar.${1}"]},` +
+		`{"Match":["^foo\\.debug\\."],"Block":true},` +
+		`{"Match":["","host","^$"],"Set":["","host","foo001"]},` +
+		`{"Match":["","cluster","^$"],"Set":["","cluster","foo.live"]}` +
+		`]`
+	if string(buf) != want {
+		t.Errorf("invalid filter\ngot:  %s\nwant: %s", buf, want)
+	}
+}
+
+func TestLabel(t *testing.T) {
+	cfg, err := config.Decode(strings.NewReader(`
+<config>
+	<hostgroup id="foo">
+		<label name="dc" value="ld5" tag="true"/>
+		<label name="env" value="live"/>
+		<cluster id="foo.live">
+			<host id="foo001">
+				<label name="role" value="web" tag="true"/>
+			</host>
+		</cluster>
+	</hostgroup>
+</config>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handler{cfg}
+	want := map[string]string{
+		"tsp-forwarder": `[` +
+			`{"Match":["","host","^$"],"Set":["","host","foo001"]},` +
+			`{"Match":["","cluster","^$"],"Set":["","cluster","foo.live"]},` +
+			`{"Match":["","dc","^$"],"Set":["","dc","ld5"]},` +
+			`{"Match":["","role","^$"],"Set":["","role","web"]}` +
+			`]`,
+		"tsp-poller": `[` +
+			`{"Match":["","host","^$"],"Set":["","host","foo001"]},` +
+			`{"Match":["","dc","^$"],"Set":["","dc","ld5"]},` +
+			`{"Match":["","role","^$"],"Set":["","role","web"]}` +
+			`]`,
+	}
+	for program, want := range want {
+		view, err := h.View(&Key{program, "foo001"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, _ := json.Marshal(view.Filter)
+		if string(buf) != want {
+			t.Errorf("%s: invalid filter\ngot:  %s\nwant: %s", program, buf, want)
+		}
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	f, err := ioutil.TempFile("", "network")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+<network>
+	<aggregator host="agg001:4242" cluster="foo.live"/>
+	<aggregator host="agg002:4243"/>
+	<aggregator host="agg003:4244" merge="true"/>
+	<subscriber id="site" host="site:4242"/>
+</network>`)
+	f.Close()
+	cfg, err := config.Decode(strings.NewReader(`
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001"/>
+		</cluster>
+		<cluster id="foo.test">
+			<host id="foo002"/>
+		</cluster>
+	</hostgroup>
+	<network path="` + f.Name() + `"/>
+</config>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handler{cfg}
+	var tests = []struct {
+		key    Key
+		relay  string
+		listen string
+	}{
+		{Key{"tsp-forwarder", "foo001"}, "aggregator=agg001:4242", ""},
+		{Key{"tsp-forwarder", "foo002"}, "aggregator=agg002:4243", ""},
+		{Key{"tsp-aggregator", "agg001"}, "aggregator=agg003:4244", ":4242"},
+		{Key{"tsp-aggregator", "agg003"}, "site=site:4242", ":4244"},
+	}
+	for i, tt := range tests {
+		view, err := h.View(&tt.key)
+		if err != nil {
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		}
+		var relay []string
+		for id, r := range view.Relay {
+			relay = append(relay, id+"="+r.Host)
+		}
+		if got := strings.Join(relay, " "); got != tt.relay || view.ListenAddr != tt.listen {
+			t.Errorf("#%d. got relay %q listen %q, want relay %q listen %q", i, got, view.ListenAddr, tt.relay, tt.listen)
+		}
+	}
+	if _, err := h.View(&Key{"tsp-aggregator", "foo001"}); err == nil {
+		t.Errorf("unexpected success")
+	}
+}
+
+func TestSubscriber(t *testing.T) {
+	f, err := ioutil.TempFile("", "network")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+<network>
+	<aggregator host="agg001"/>
+	<subscriber id="tsd" host="tsd:4242" direct="true">
+		<exclude metric="^debug\."/>
+	</subscriber>
+	<subscriber id="capacity" host="cap:4242">
+		<include metric="^proc\."/>
+	</subscriber>
+</network>`)
+	f.Close()
+	cfg, err := config.Decode(strings.NewReader(`
+<config>
+	<network path="` + f.Name() + `"/>
+</config>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handler{cfg}
+	want := map[string]string{
+		"tsp-forwarder": `{` +
+			`"aggregator":{"Host":"agg001"},` +
+			`"tsd":{"Host":"tsd:4242","Filter":[{"Match":["^debug\\."],"Block":true}]}` +
+			`}`,
+		"tsp-poller": `{` +
+			`"aggregator":{"Host":"agg001"},` +
+			`"tsd":{"Host":"tsd:4242","MaxConnsPerHost":12,"Filter":[{"Match":["^debug\\."],"Block":true}]}` +
+			`}`,
+		"tsp-aggregator": `{` +
+			`"capacity":{"Host":"cap:4242","Filter":[{"Match":["^proc\\."],"Pass":true},{"Block":true}]}` +
+			`}`,
+	}
+	for program, want := range want {
+		host := "foo001"
+		if program == "tsp-aggregator" {
+			host = "agg001"
+		}
+		view, err := h.View(&Key{program, host})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, _ := json.Marshal(view.Relay)
+		if string(buf) != want {
+			t.Errorf("%s: invalid relays\ngot:  %s\nwant: %s", program, buf, want)
+		}
+	}
+}
+
+var testPoll = []struct {
+	in  string
+	out map[string]string // poller host => netscaler plugin config
+	err string
+}{
+	0: {
+		in: `
+<config>
+	<hostgroup id="poller">
+		<cluster id="poller.live">
+			<host id="poller001"/>
+			<host id="poller002">
+				<plugin name="netscaler">{"Interval": "10s"}</plugin>
+			</host>
+		</cluster>
+	</hostgroup>
+	<poll plugin="netscaler" pollers="poller.live">
+		<target>lb001</target>
+		<target>lb002</target>
+		<target>lb003</target>
+	</poll>
+</config>`,
+		out: map[string]string{
+			"poller001": `{"Targets":["lb002","lb003"]}`,
+			"poller002": `{"Interval":"10s","Targets":["lb001"]}`,
+		},
+	},
+	1: {
+		in: `
+<config>
+	<hostgroup id="poller">
+		<cluster id="poller.live">
+			<host id="poller001"/>
+		</cluster>
+	</hostgroup>
+	<poll plugin="netscaler" pollers="poller.live"/>
+</config>`,
+		out: map[string]string{
+			"poller001": `{"Targets":[]}`,
+		},
+	},
+	2: {
+		in: `
+<config>
+	<poll plugin="netscaler"/>
+</config>`,
+		err: "poll netscaler: missing attribute: pollers",
+	},
+	3: {
+		in: `
+<config>
+	<poll plugin="netscaler" pollers="poller.live"/>
+</config>`,
+		err: "poll netscaler: undefined: poller.live",
+	},
+	4: {
+		in: `
+<config>
+	<hostgroup id="poller">
+		<cluster id="poller.live">
+			<host id="poller001"/>
+		</cluster>
+	</hostgroup>
+	<poll plugin="netscaler" pollers="poller.live">
+		<target>lb001</target>
+		<target>lb001</target>
+	</poll>
+</config>`,
+		err: "poll netscaler: target redeclared: lb001",
+	},
+	5: {
+		in: `
+<config>
+	<hostgroup id="poller">
+		<cluster id="poller.live">
+			<host id="poller001">
+				<plugin name="netscaler">{"Targets": ["lb001"]}</plugin>
+			</host>
+		</cluster>
+	</hostgroup>
+	<poll plugin="netscaler" pollers="poller">
+		<target>lb002</target>
+	</poll>
+</config>`,
+		err: "host poller001: plugin netscaler: Targets set by poll",
+	},
+	6: {
+		in: `
+<config>
+	<hostgroup id="poller">
+		<cluster id="poller.live">
+			<host id="poller001"/>
+		</cluster>
+	</hostgroup>
+	<poll plugin="netscaler" pollers="poller.live, poller"/>
+	<poll plugin="netscaler" pollers="poller,poller.live"/>
+</config>`,
+		err: "poll redeclared: netscaler pollers=poller,poller.live",
+	},
+	7: {
+		in: `
+<config>
+	<hostgroup id="poller">
+		<cluster id="poller.dc1">
+			<host id="poller001"/>
+		</cluster>
+		<cluster id="poller.dc2">
+			<host id="poller002"/>
+			<host id="poller003"/>
+		</cluster>
+	</hostgroup>
+	<poll plugin="netscaler" pollers=" poller.dc1">
+		<target>lb001.dc1</target>
+		<target>lb002.dc1</target>
+	</poll>
+	<poll plugin="netscaler" pollers="poller.dc2 ,poller001">
+		<target>lb001.dc2</target>
+	</poll>
+</config>`,
+		out: map[string]string{
+			"poller001": `{"Targets":["lb001.dc1","lb002.dc1"]}`,
+			"poller002": `{"Targets":["lb001.dc2"]}`,
+			"poller003": `{"Targets":[]}`,
+		},
+	},
+	8: {
+		in: `
+<config>
+	<hostgroup id="poller">
+		<cluster id="poller.dc1">
+			<host id="poller001"/>
+		</cluster>
+		<cluster id="poller.dc2">
+			<host id="poller002"/>
+		</cluster>
+	</hostgroup>
+	<poll plugin="netscaler" pollers="poller.dc1">
+		<target>lb001</target>
+	</poll>
+	<poll plugin="netscaler" pollers="poller.dc2">
+		<target>lb001</target>
+	</poll>
+</config>`,
+		err: "poll netscaler: target redeclared: lb001",
+	},
+}
+
+func TestPoll(t *testing.T) {
+	for i, tt := range testPoll {
+		cfg, err := config.Decode(strings.NewReader(tt.in))
+		if err != nil {
+			if tt.err == "" {
+				t.Errorf("#%d. unexpected error: %v", i, err)
+				continue
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("#%d. invalid error, got: %s, want: %s", i, err, tt.err)
+			}
+			continue
+		}
+		if tt.err != "" {
+			t.Errorf("#%d. unexpected success, want error: %v", i, tt.err)
+			continue
+		}
+		h := &handler{cfg}
+		for host, want := range tt.out {
+			view, err := h.View(&Key{"tsp-poller", host})
+			if err != nil {
+				t.Errorf("#%d. unexpected error: %v", i, err)
+				continue
+			}
+			if got := string(view.Plugin["netscaler"]); got != want {
+				t.Errorf("#%d. %s: invalid plugin config\ngot:  %s\nwant: %s", i, host, got, want)
+			}
+			view, err = h.View(&Key{"tsp-forwarder", host})
+			if err != nil {
+				t.Errorf("#%d. unexpected error: %v", i, err)
+				continue
+			}
+			if got := string(view.Plugin["netscaler"]); strings.Contains(got, "Targets") {
+				t.Errorf("#%d. %s: targets served to tsp-forwarder: %s", i, host, got)
+			}
+		}
+	}
+}
+
+var testPollScrape = []struct {
+	in  string
+	out map[string]string // poller host => Scrape setting
+	err string
+}{
+	0: {
+		in: `
+<config>
+	<hostgroup id="poller">
+		<cluster id="poller.live">
+			<host id="poller001"/>
+			<host id="poller002"/>
+		</cluster>
+	</hostgroup>
+	<poll scrape="prometheus" pollers="poller.live">
+		<target>http://app001:9100/metrics</target>
+		<target>http://app002:9100/metrics</target>
+	</poll>
+	<poll scrape="json" pollers="poller002">
+		<target>http://app001:8080/debug/vars</target>
+	</poll>
+</config>`,
+		out: map[string]string{
+			"poller001": `[{"URL":"http://app002:9100/metrics","Format":"prometheus"}]`,
+			"poller002": `[{"URL":"http://app001:9100/metrics","Format":"prometheus"},{"URL":"http://app001:8080/debug/vars","Format":"json"}]`,
+		},
+	},
+	1: {
+		in: `
+<config>
+	<hostgroup id="poller">
+		<cluster id="poller.live">
+			<host id="poller001"/>
+		</cluster>
+	</hostgroup>
+	<poll scrape="prometheus" pollers="poller.live"/>
+</config>`,
+		out: map[string]string{
+			"poller001": `null`,
+		},
+	},
+	2: {
+		in: `
+<config>
+	<poll plugin="netscaler" scrape="json" pollers="poller.live"/>
+</config>`,
+		err: "poll netscaler: plugin and scrape are mutually exclusive",
+	},
+	3: {
+		in: `
+<config>
+	<poll scrape="xml" pollers="poller.live"/>
+</config>`,
+		err: "poll scrape:xml: unsupported scrape format",
+	},
+	4: {
+		in: `
+<config>
+	<hostgroup id="poller">
+		<cluster id="poller.live">
+			<host id="poller001"/>
+		</cluster>
+	</hostgroup>
+	<poll scrape="prometheus" pollers="poller.live">
+		<target>app001:9100</target>
+	</poll>
+</config>`,
+		err: "poll scrape:prometheus: invalid URL: app001:9100",
+	},
+	5: {
+		in: `
+<config>
+	<hostgroup id="poller">
+		<cluster id="poller.live">
+			<host id="poller001"/>
+		</cluster>
+	</hostgroup>
+	<poll scrape="prometheus" pollers="poller.live">
+		<target>http://app001:9100/metrics</target>
+	</poll>
+	<poll scrape="json" pollers="poller.live">
+		<target>http://app001:9100/metrics</target>
+	</poll>
+</config>`,
+		err: "poll scrape:json: target redeclared: http://app001:9100/metrics",
+	},
+	6: {
+		in: `
+<config>
+	<hostgroup id="poller">
+		<cluster id="poller.live">
+			<host id="poller001"/>
+		</cluster>
+	</hostgroup>
+	<poll scrape="json" pollers="poller.live"/>
+	<poll scrape="json" pollers="poller.live"/>
+</config>`,
+		err: "poll redeclared: scrape:json pollers=poller.live",
+	},
+	7: {
+		in: `
+<config>
+	<hostgroup id="poller">
+		<cluster id="poller.dc1">
+			<host id="poller001"/>
+		</cluster>
+		<cluster id="poller.dc2">
+			<host id="poller002"/>
+		</cluster>
+	</hostgroup>
+	<poll scrape="json" pollers="poller.dc1">
+		<target>http://app001.dc1:8080/debug/vars</target>
+	</poll>
+	<poll scrape="json" pollers="poller.dc2">
+		<target>http://app001.dc2:8080/debug/vars</target>
+	</poll>
+</config>`,
+		out: map[string]string{
+			"poller001": `[{"URL":"http://app001.dc1:8080/debug/vars","Format":"json"}]`,
+			"poller002": `[{"URL":"http://app001.dc2:8080/debug/vars","Format":"json"}]`,
+		},
+	},
+}
+
+// TestPollInventory checks that pollers may be declared by an inventory.
+func TestPollInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/inventory.csv"
+	if err := ioutil.WriteFile(path, []byte("poller001,poller.live,poller\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	problems := config.Lint([]byte(`<config>
+	<inventory type="file" path="` + path + `"/>
+	<poll plugin="netscaler" pollers="poller.live">
+		<target>lb001</target>
+	</poll>
+	<poll plugin="f5" pollers="poller.typo"/>
+</config>`))
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{"warning: poll f5 pollers=poller.typo: undefined: poller.typo"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid problems\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+func TestPollScrape(t *testing.T) {
+	for i, tt := range testPollScrape {
+		cfg, err := config.Decode(strings.NewReader(tt.in))
+		if err != nil {
+			if tt.err == "" {
+				t.Errorf("#%d. unexpected error: %v", i, err)
+				continue
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("#%d. invalid error, got: %s, want: %s", i, err, tt.err)
+			}
+			continue
+		}
+		if tt.err != "" {
+			t.Errorf("#%d. unexpected success, want error: %v", i, tt.err)
+			continue
+		}
+		h := &handler{cfg}
+		for host, want := range tt.out {
+			view, err := h.View(&Key{"tsp-poller", host})
+			if err != nil {
+				t.Errorf("#%d. unexpected error: %v", i, err)
+				continue
+			}
+			if buf, _ := json.Marshal(view.Scrape); string(buf) != want {
+				t.Errorf("#%d. %s: invalid scrape config\ngot:  %s\nwant: %s", i, host, buf, want)
+			}
+			if view.Plugin != nil {
+				t.Errorf("#%d. %s: unexpected plugin config: %v", i, host, view.Plugin)
+			}
+		}
+	}
+}
+
+func TestPollBalance(t *testing.T) {
+	p := new(Poll)
+	for i := 0; i < 100; i++ {
+		p.Target = append(p.Target, fmt.Sprintf("lb%03d", i))
+	}
+	pollers := []string{"poller001", "poller002", "poller003"}
+	before := p.assign(pollers)
+	seen := make(map[string]bool)
+	for _, poller := range pollers {
+		if n := len(before[poller]); n < 33 || n > 34 {
+			t.Errorf("%s: unbalanced, got %d targets", poller, n)
+		}
+		for _, target := range before[poller] {
+			seen[target] = true
+		}
+	}
+	if len(seen) != len(p.Target) {
+		t.Errorf("got %d targets assigned, want %d", len(seen), len(p.Target))
+	}
+	// Adding or removing a poller moves at most about twice the minimum
+	// number of targets.
+	moved := func(pollers []string) int {
+		after := p.assign(pollers)
+		n := 0
+		for _, poller := range pollers {
+			kept := make(map[string]bool)
+			for _, target := range before[poller] {
+				kept[target] = true
+			}
+			for _, target := range after[poller] {
+				if !kept[target] {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	if n := moved(append(pollers, "poller004")); n > 2*len(p.Target)/4 {
+		t.Errorf("adding a poller: too many targets moved: %d", n)
+	}
+	if n := moved(pollers[1:]); n > 2*len(before[pollers[0]]) {
+		t.Errorf("removing a poller: too many targets moved: %d", n)
+	}
+}
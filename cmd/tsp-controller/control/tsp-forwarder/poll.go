@@ -0,0 +1,337 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package control
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"strings"
+
+	"opentsp.org/cmd/tsp-controller/config"
+)
+
+// A Poll corresponds to a <poll> configuration element. It assigns the remote
+// targets of a tsp-poller(8) plugin, such as load balancers or URLs, to the
+// poller hosts enclosed by the elements listed in Pollers. If Scrape is set
+// instead of Plugin, the targets are URLs of documents in the given format,
+// scraped by tsp-poller itself.
+type Poll struct {
+	XMLName xml.Name  `xml:"poll"`
+	Plugin  string    `xml:"plugin,attr"`
+	Scrape  string    `xml:"scrape,attr"`
+	Pollers string    `xml:"pollers,attr"`
+	Target  []string  `xml:"target"`
+	Any     *xml.Name `xml:",any"`
+}
+
+// pollTarget identifies a target of a plugin, or, if plugin is empty, a
+// scraped URL.
+type pollTarget struct {
+	plugin, target string
+}
+
+// updatePolls decodes the global <poll> elements. A plugin or scrape format
+// may be polled by several polls, for example one per datacentre, provided
+// they list different pollers.
+func updatePolls(config_ *config.Config) error {
+	ns := make(config.Namespace)
+	assigned := make(map[pollTarget]bool)
+	for _, elem := range config_.Extra {
+		if elem.Value != nil || elem.Name != "poll" {
+			continue
+		}
+		p := new(Poll)
+		if err := xml.Unmarshal(elem.Raw, p); err != nil {
+			return fmt.Errorf("poll: %v", err)
+		}
+		if err := p.validate(config_); err != nil {
+			return err
+		}
+		if err := ns.Add(p.id()); err != nil {
+			return fmt.Errorf("poll redeclared: %s", p.id())
+		}
+		// A target is polled by one poll per plugin, and a URL is scraped in
+		// one format only.
+		for _, target := range p.Target {
+			key := pollTarget{p.Plugin, target}
+			if assigned[key] {
+				return fmt.Errorf("poll %s: target redeclared: %s", p.name(), target)
+			}
+			assigned[key] = true
+		}
+		elem.Value = p
+	}
+	return nil
+}
+
+// Scrape formats, see tsp-poller(8).
+var scrapeFormats = map[string]bool{
+	"json":       true,
+	"prometheus": true,
+}
+
+// name returns the name used to identify the poll: the plugin name, or
+// "scrape:" followed by the scrape format.
+func (p *Poll) name() string {
+	if p.Scrape != "" {
+		return "scrape:" + p.Scrape
+	}
+	return p.Plugin
+}
+
+// id returns the name of the poll followed by its sorted pollers, which
+// identifies the poll among the polls of the same name.
+func (p *Poll) id() string {
+	ids := p.pollerIDs()
+	sort.Strings(ids)
+	return p.name() + " pollers=" + strings.Join(ids, ",")
+}
+
+func (p *Poll) validate(config *config.Config) error {
+	switch {
+	case p.Plugin == "" && p.Scrape == "":
+		return fmt.Errorf("missing poll attribute: plugin")
+	case p.Plugin != "" && p.Scrape != "":
+		return fmt.Errorf("poll %s: plugin and scrape are mutually exclusive", p.Plugin)
+	}
+	if any := p.Any; any != nil {
+		return fmt.Errorf("poll %s: invalid element: %s", p.name(), any.Local)
+	}
+	if strings.ContainsAny(p.Plugin, "/\\") {
+		return fmt.Errorf("poll %s: invalid plugin name", p.Plugin)
+	}
+	if p.Scrape != "" && !scrapeFormats[p.Scrape] {
+		return fmt.Errorf("poll %s: unsupported scrape format", p.name())
+	}
+	if p.Pollers == "" {
+		return fmt.Errorf("poll %s: missing attribute: pollers", p.name())
+	}
+	// The pollers may be declared by an inventory, which is not merged
+	// yet, in which case undefined pollers are reported by checkPolls.
+	for _, id := range p.pollerIDs() {
+		if !config.Hosts.NS[id] && len(config.Inventory) == 0 {
+			return fmt.Errorf("poll %s: undefined: %s", p.name(), id)
+		}
+	}
+	seen := make(map[string]bool)
+	for i, target := range p.Target {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			return fmt.Errorf("poll %s: empty target", p.name())
+		}
+		if seen[target] {
+			return fmt.Errorf("poll %s: target redeclared: %s", p.name(), target)
+		}
+		seen[target] = true
+		p.Target[i] = target
+	}
+	if p.Scrape != "" {
+		for _, target := range p.Target {
+			u, err := url.Parse(target)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("poll %s: invalid URL: %s", p.name(), target)
+			}
+		}
+		return nil
+	}
+	// The targets are served in the Targets field of the plugin settings,
+	// which must be a JSON object that does not set the field itself.
+	for _, host := range config.Hosts.All {
+		if !p.selects(host) {
+			continue
+		}
+		for _, plugin := range hostPlugins(host.ID, config) {
+			if plugin.Name != p.Plugin {
+				continue
+			}
+			var settings map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(plugin.Config), &settings); err != nil {
+				return fmt.Errorf("host %s: plugin %s: config not a JSON object", host.ID, plugin.Name)
+			}
+			if _, ok := settings["Targets"]; ok {
+				return fmt.Errorf("host %s: plugin %s: Targets set by poll", host.ID, plugin.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Poll) pollerIDs() []string {
+	var ids []string
+	for _, id := range strings.Split(p.Pollers, ",") {
+		ids = append(ids, strings.TrimSpace(id))
+	}
+	return ids
+}
+
+// selects reports whether the host is enclosed by any of the Pollers.
+func (p *Poll) selects(host *config.Host) bool {
+	for _, want := range p.pollerIDs() {
+		for _, got := range host.Tags {
+			if got == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pollers returns the ids of the selected hosts within the network
+// restrictions, in sorted order.
+func (p *Poll) pollers(config *config.Config) []string {
+	var ids []string
+	for _, host := range config.Hosts.All {
+		if p.selects(host) && config.Network.InScope(host.ID) {
+			ids = append(ids, host.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// assign returns the targets assigned to each of the given pollers. The
+// targets are balanced using rendezvous hashing with bounded load: a target
+// is assigned to the highest-scoring poller that has not reached its share
+// of the targets. Unlike with a modulo assignment, adding or removing a
+// poller does not reshuffle all the targets: it moves at most about twice as
+// many as the minimum needed to rebalance them.
+func (p *Poll) assign(pollers []string) map[string][]string {
+	m := make(map[string][]string)
+	if len(pollers) == 0 {
+		return m
+	}
+	targets := append([]string(nil), p.Target...)
+	sort.Strings(targets)
+	share := (len(targets) + len(pollers) - 1) / len(pollers)
+	for _, target := range targets {
+		ranked := append([]string(nil), pollers...)
+		score := make(map[string]uint64)
+		for _, poller := range ranked {
+			h := fnv.New64a()
+			h.Write([]byte(target))
+			h.Write([]byte{0})
+			h.Write([]byte(poller))
+			score[poller] = h.Sum64()
+		}
+		sort.Slice(ranked, func(i, j int) bool {
+			return score[ranked[i]] > score[ranked[j]]
+		})
+		for _, poller := range ranked {
+			if len(m[poller]) < share {
+				m[poller] = append(m[poller], target)
+				break
+			}
+		}
+	}
+	return m
+}
+
+// Targets returns the targets assigned to the given host, and whether the
+// host is one of the pollers.
+func (p *Poll) Targets(host *config.Host, config *config.Config) ([]string, bool) {
+	if !p.selects(host) {
+		return nil, false
+	}
+	targets := p.assign(p.pollers(config))[host.ID]
+	if targets == nil {
+		targets = []string{}
+	}
+	return targets, true
+}
+
+// pollConfig adds the targets assigned to the given poller host to the plugin
+// settings m, which may be nil. The targets of all the polls of a plugin that
+// select the host are merged. It returns the updated settings.
+func pollConfig(host *config.Host, config *config.Config, m map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	var plugins []string
+	merged := make(map[string][]string)
+	for _, elem := range config.Extra {
+		p, ok := elem.Value.(*Poll)
+		if !ok || p.Plugin == "" {
+			continue
+		}
+		targets, ok := p.Targets(host, config)
+		if !ok {
+			continue
+		}
+		if _, ok := merged[p.Plugin]; !ok {
+			plugins = append(plugins, p.Plugin)
+		}
+		merged[p.Plugin] = append(merged[p.Plugin], targets...)
+	}
+	for _, plugin := range plugins {
+		targets := merged[plugin]
+		if targets == nil {
+			targets = []string{}
+		}
+		sort.Strings(targets)
+		settings := make(map[string]json.RawMessage)
+		if raw, ok := m[plugin]; ok {
+			if err := json.Unmarshal(raw, &settings); err != nil {
+				return nil, fmt.Errorf("plugin %s: %v", plugin, err)
+			}
+		}
+		buf, err := json.Marshal(targets)
+		if err != nil {
+			return nil, err
+		}
+		settings["Targets"] = buf
+		if buf, err = json.Marshal(settings); err != nil {
+			return nil, err
+		}
+		if m == nil {
+			m = make(map[string]json.RawMessage)
+		}
+		m[plugin] = buf
+	}
+	return m, nil
+}
+
+// scrapeConfig returns the scrape targets assigned to the given poller host,
+// or nil if none are assigned.
+func scrapeConfig(host *config.Host, config *config.Config) []*ScrapeTarget {
+	var found []*ScrapeTarget
+	for _, elem := range config.Extra {
+		p, ok := elem.Value.(*Poll)
+		if !ok || p.Scrape == "" {
+			continue
+		}
+		targets, _ := p.Targets(host, config)
+		for _, target := range targets {
+			found = append(found, &ScrapeTarget{
+				URL:    target,
+				Format: p.Scrape,
+			})
+		}
+	}
+	return found
+}
+
+// checkPolls warns about polls that list undefined pollers or select no
+// pollers.
+func checkPolls(config *config.Config) []error {
+	var warnings []error
+	for _, elem := range config.Extra {
+		p, ok := elem.Value.(*Poll)
+		if !ok {
+			continue
+		}
+		undefined := false
+		for _, id := range p.pollerIDs() {
+			if !config.Hosts.NS[id] {
+				warnings = append(warnings, fmt.Errorf("poll %s: undefined: %s", p.id(), id))
+				undefined = true
+			}
+		}
+		if !undefined && len(p.pollers(config)) == 0 {
+			warnings = append(warnings, fmt.Errorf("poll %s: pollers match no hosts", p.id()))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,108 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"opentsp.org/cmd/tsp-controller/config"
+)
+
+// ViewFunc returns the view of a program for the given host, that is the
+// settings served in response to the host's view request. It returns a nil
+// view if the program does not run on the host.
+type ViewFunc func(config *config.Config, host string) (interface{}, error)
+
+var views = make(map[string]ViewFunc)
+
+// RegisterView is used by modules to register the view function of a
+// program, making its views available to Render.
+func RegisterView(program string, fn ViewFunc) {
+	if _, ok := views[program]; ok {
+		panic("control: view redeclared: " + program)
+	}
+	views[program] = fn
+}
+
+// Programs returns the programs whose views are registered, in sorted order.
+func Programs() []string {
+	var programs []string
+	for program := range views {
+		programs = append(programs, program)
+	}
+	sort.Strings(programs)
+	return programs
+}
+
+// Render returns the view of the given program for the given host.
+func Render(config *config.Config, program, host string) (interface{}, error) {
+	fn, ok := views[program]
+	if !ok {
+		return nil, fmt.Errorf("unknown program: %s, want one of: %s", program, strings.Join(Programs(), ", "))
+	}
+	return fn(config, host)
+}
+
+// Dump writes the views of all registered programs for all hosts within the
+// network restrictions to the given directory, which must be empty or not
+// exist. The view of a program for a host is written in indented JSON to the
+// file dir/program/host.json. Failed views are logged and skipped; if any
+// view failed, an error is returned once the dump is complete.
+func Dump(config *config.Config, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if names, err := ioutil.ReadDir(dir); err != nil {
+		return err
+	} else if len(names) > 0 {
+		return fmt.Errorf("dump: directory not empty: %s", dir)
+	}
+	failed := 0
+	for _, program := range Programs() {
+		for _, host := range config.Hosts.All {
+			if !config.Network.InScope(host.ID) {
+				continue
+			}
+			if err := dumpView(config, dir, program, host.ID); err != nil {
+				log.Printf("dump: %s: host %s: %v", program, host.ID, err)
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("dump: %d views failed", failed)
+	}
+	return nil
+}
+
+func dumpView(config *config.Config, dir, program, host string) error {
+	if host == "." || host == ".." || strings.ContainsAny(host, `/\`) {
+		return fmt.Errorf("invalid host id")
+	}
+	view, err := Render(config, program, host)
+	if err != nil {
+		return err
+	}
+	if view == nil {
+		return nil
+	}
+	buf, err := json.MarshalIndent(view, "", "\t")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	path := filepath.Join(dir, program, host+".json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0644)
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package control
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"opentsp.org/cmd/tsp-controller/config"
+)
+
+func TestRender(t *testing.T) {
+	RegisterView("test-program", func(config *config.Config, host string) (interface{}, error) {
+		if host != "foo001" {
+			return nil, nil
+		}
+		return "view of " + host, nil
+	})
+	defer delete(views, "test-program")
+	cfg, err := config.Decode(strings.NewReader(`<config></config>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Programs(), []string{"test-program"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid programs, got: %v, want: %v", got, want)
+	}
+	if view, err := Render(cfg, "test-program", "foo001"); err != nil || view != "view of foo001" {
+		t.Errorf("got view %v, error %v", view, err)
+	}
+	if view, err := Render(cfg, "test-program", "foo002"); err != nil || view != nil {
+		t.Errorf("got view %v, error %v, want nil view", view, err)
+	}
+	if _, err := Render(cfg, "unknown", "foo001"); err == nil {
+		t.Errorf("unexpected success")
+	}
+}
+
+func TestDump(t *testing.T) {
+	RegisterView("test-program", func(config *config.Config, host string) (interface{}, error) {
+		switch host {
+		case "foo002":
+			return nil, nil
+		case "foo003":
+			return nil, fmt.Errorf("failed")
+		}
+		return map[string]string{"Host": host}, nil
+	})
+	defer delete(views, "test-program")
+	cfg, err := config.Decode(strings.NewReader(`
+<config>
+	<hostgroup id="foo">
+		<cluster id="foo.live">
+			<host id="foo001"/>
+			<host id="foo002"/>
+			<host id="foo003"/>
+		</cluster>
+	</hostgroup>
+</config>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := Dump(cfg, dir); err == nil || !strings.Contains(err.Error(), "1 views failed") {
+		t.Errorf("invalid error: %v", err)
+	}
+	var got []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			buf, _ := ioutil.ReadFile(path)
+			rel, _ := filepath.Rel(dir, path)
+			got = append(got, rel+" "+string(buf))
+		}
+		return err
+	})
+	want := []string{"test-program/foo001.json {\n\t\"Host\": \"foo001\"\n}\n"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid dump\ngot:  %q\nwant: %q", got, want)
+	}
+	if err := Dump(cfg, dir); err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Errorf("invalid error: %v", err)
+	}
+	for _, host := range []string{"../foo001", "..", `foo\foo001`} {
+		if err := dumpView(cfg, dir, "test-program", host); err == nil {
+			t.Errorf("%s: unexpected success", host)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "foo001.json")); !os.IsNotExist(err) {
+		t.Errorf("view written outside program directory")
+	}
+}
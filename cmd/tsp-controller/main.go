@@ -6,7 +6,11 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"os"
+	"strings"
 
 	"opentsp.org/cmd/tsp-controller/config"
 	"opentsp.org/cmd/tsp-controller/control"
@@ -18,6 +22,42 @@ import (
 
 func main() {
 	config.Load()
+	if *config.ViewMode != "" {
+		if err := printView(*config.ViewMode); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if dir := *config.DumpMode; dir != "" {
+		if err := control.Dump(config.Loaded, dir); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	go config.Watch(config.Loaded, control.Reload)
 	err := control.ListenAndServe(*config.ListenAddr, config.Loaded)
 	log.Fatal(err)
 }
+
+// printView prints the view of the program:host pair given by spec.
+func printView(spec string) error {
+	i := strings.Index(spec, ":")
+	if i == -1 {
+		return fmt.Errorf("invalid view: %s, want program:host", spec)
+	}
+	program, host := spec[:i], spec[i+1:]
+	view, err := control.Render(config.Loaded, program, host)
+	if err != nil {
+		return fmt.Errorf("%s: %v", program, err)
+	}
+	if view == nil {
+		return fmt.Errorf("%s: does not run on host %s", program, host)
+	}
+	buf, err := json.MarshalIndent(view, "", "\t")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	_, err = os.Stdout.Write(buf)
+	return err
+}
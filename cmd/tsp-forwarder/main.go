@@ -13,7 +13,9 @@ import (
 
 	"opentsp.org/internal/collect"
 	"opentsp.org/internal/flag"
+	"opentsp.org/internal/hoststat"
 	"opentsp.org/internal/logfile"
+	"opentsp.org/internal/procstat"
 	"opentsp.org/internal/relay"
 	"opentsp.org/internal/stats"
 	"opentsp.org/internal/tsdb"
@@ -29,6 +31,8 @@ func init() {
 	log.SetOutput(w)
 	if flag.DebugMode {
 		collect.Debug = log.New(w, "debug: collect: ", 0)
+		hoststat.Debug = log.New(w, "debug: hoststat: ", 0)
+		procstat.Debug = log.New(w, "debug: procstat: ", 0)
 		filter.Debug = log.New(w, "debug: filter: ", 0)
 	}
 	log.Print("start pid=", os.Getpid())
@@ -36,14 +40,24 @@ func init() {
 
 func main() {
 	var (
-		plugins = collect.NewPool(cfg.CollectPath)
+		plugins = collect.NewPool(cfg.CollectPath, cfg.Collect)
+		host    = hoststat.Collect(cfg.HostStat)
+		process = procstat.Collect(cfg.ProcStat, cfg.Process)
 		self    = stats.Self("tsp.forwarder.")
-		joined  = tsdb.Join(plugins.C, self)
-		final   = filter.Series(cfg.Filter, joined)
+		joined  = tsdb.Join(plugins.C, host, process, self)
+		final   = newFilter(cfg.Filter, joined)
 		relays  = relay.NewPool(cfg.Relay, final)
 	)
-	go Restart(func() {
+	go Reload(final, relays, func() {
 		plugins.Kill()
 	})
 	relays.Broadcast()
 }
+
+func newFilter(rules []filter.Rule, series tsdb.Series) *filter.Switch {
+	s, err := filter.NewSwitch(rules, series)
+	if err != nil {
+		log.Panicf("internal error: %v", err)
+	}
+	return s
+}
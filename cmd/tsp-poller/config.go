@@ -1,24 +1,35 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 
+	"opentsp.org/internal/collect"
 	"opentsp.org/internal/config"
 	"opentsp.org/internal/flag"
+	"opentsp.org/internal/httpstat"
+	"opentsp.org/internal/pprof"
 	"opentsp.org/internal/relay"
 	"opentsp.org/internal/restart"
+	"opentsp.org/internal/snmpstat"
 	"opentsp.org/internal/tsdb/filter"
 	"opentsp.org/internal/validate"
 )
 
 type Config struct {
-	Filter      []filter.Rule            `config:"dynamic"`
-	Relay       map[string]*relay.Config `config:"dynamic"`
+	Filter      []filter.Rule              `config:"dynamic"`
+	Relay       map[string]*relay.Config   `config:"dynamic"`
+	Plugin      map[string]json.RawMessage `config:"dynamic,secret"`
+	Scrape      []*httpstat.Target         `config:"dynamic"`
 	CollectPath string
+	Collect     *collect.Config
+	SNMP        *snmpstat.Config
+	HTTP        *httpstat.Config
 	LogPath     string
 }
 
@@ -28,14 +39,18 @@ func load(path string) *Config {
 	}
 	cfg := new(Config)
 	config.Load(cfg, path, "tsp-poller?host={{.Hostname}}")
+	pprof.Handle("/debug/config", http.HandlerFunc(config.ServeHistory))
 	if flag.TestMode {
 		cfg.Dump(os.Stdout)
+		config.DumpSources(os.Stderr, cfg)
 		os.Exit(0)
 	}
 	go func() {
-		dummy := new(Config)
-		config.Next(dummy)
-		restartCause <- "config updated"
+		for {
+			next := new(Config)
+			config.Next(next)
+			configUpdate <- next
+		}
 	}()
 	return cfg
 }
@@ -43,8 +58,7 @@ func load(path string) *Config {
 func (c *Config) Reset() { *c = *defaultConfig }
 
 func (c *Config) Dump(w io.Writer) {
-	buf, _ := json.MarshalIndent(c, "", "\t")
-	fmt.Fprintln(w, string(buf))
+	config.Dump(w, c)
 }
 
 func (c *Config) Validate() error {
@@ -56,14 +70,83 @@ func (c *Config) Validate() error {
 	if err := validate.Relay(c.Relay); err != nil {
 		return err
 	}
+	c.Collect, err = validate.Collect(c.Collect)
+	if err != nil {
+		return err
+	}
+	if err := validate.Plugin(c.Plugin); err != nil {
+		return err
+	}
+	c.SNMP, err = validate.SNMP(c.SNMP)
+	if err != nil {
+		return err
+	}
+	c.HTTP, err = validate.HTTP(c.HTTP)
+	if err != nil {
+		return err
+	}
+	// The Scrape targets are merged when the collector is started, see main.
+	merged, _ := c.mergeScrape()
+	if _, err := validate.HTTP(merged); err != nil {
+		return err
+	}
+	c.Collect.Plugin = c.Plugin
 	return nil
 }
 
-var restartCause = make(chan string)
+// mergeScrape returns the HTTP setting extended with the Scrape targets,
+// and the Scrape targets skipped. Targets declared locally take precedence
+// over those served by the controller. The HTTP setting is left unchanged.
+func (c *Config) mergeScrape() (merged *httpstat.Config, skipped []*httpstat.Target) {
+	cp := *c.HTTP
+	cp.Target = append([]*httpstat.Target(nil), c.HTTP.Target...)
+	skipped = cp.Merge(c.Scrape)
+	return &cp, skipped
+}
+
+var (
+	restartCause = make(chan string)
+	configUpdate = make(chan *Config)
+)
 
-func Restart(shutdown func()) {
-	cause := <-restartCause
+// Reload applies config updates received from the controller. The Filter and
+// Relay settings are applied live. Changes to other settings cause a restart.
+func Reload(final *filter.Switch, relays *relay.Pool, shutdown func()) {
+	var cause string
+	for cause == "" {
+		select {
+		case cause = <-restartCause:
+		case next := <-configUpdate:
+			if changed(cfg.Plugin, next.Plugin) || changed(cfg.Scrape, next.Scrape) {
+				cause = "config updated"
+				break
+			}
+			if err := apply(next, final, relays); err != nil {
+				cause = fmt.Sprintf("config updated: %v", err)
+			}
+		}
+	}
 	log.Printf("restarting... (%s)", cause)
 	shutdown()
 	restart.Do()
 }
+
+func apply(next *Config, final *filter.Switch, relays *relay.Pool) error {
+	if err := final.Set(next.Filter); err != nil {
+		return err
+	}
+	if err := relays.Update(next.Relay); err != nil {
+		return err
+	}
+	cfg.Filter = next.Filter
+	cfg.Relay = next.Relay
+	log.Print("config updated")
+	return nil
+}
+
+// changed reports whether the given setting values differ.
+func changed(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return !bytes.Equal(x, y)
+}
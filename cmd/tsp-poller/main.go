@@ -13,8 +13,10 @@ import (
 
 	"opentsp.org/internal/collect"
 	"opentsp.org/internal/flag"
+	"opentsp.org/internal/httpstat"
 	"opentsp.org/internal/logfile"
 	"opentsp.org/internal/relay"
+	"opentsp.org/internal/snmpstat"
 	"opentsp.org/internal/stats"
 	"opentsp.org/internal/tsdb"
 	"opentsp.org/internal/tsdb/filter"
@@ -30,20 +32,36 @@ func init() {
 	if flag.DebugMode {
 		collect.Debug = log.New(w, "debug: collect: ", 0)
 		filter.Debug = log.New(w, "debug: filter: ", 0)
+		snmpstat.Debug = log.New(w, "debug: snmpstat: ", 0)
+		httpstat.Debug = log.New(w, "debug: httpstat: ", 0)
 	}
 	log.Print("start pid=", os.Getpid())
 }
 
 func main() {
+	httpConfig, skipped := cfg.mergeScrape()
+	for _, t := range skipped {
+		log.Printf("ignoring Scrape target %s: declared in HTTP setting", t.URL)
+	}
 	var (
-		plugins = collect.NewPool(cfg.CollectPath)
+		plugins = collect.NewPool(cfg.CollectPath, cfg.Collect)
+		snmp    = snmpstat.Collect(cfg.SNMP)
+		http    = httpstat.Collect(httpConfig)
 		self    = stats.Self("tsp.poller.")
-		joined  = tsdb.Join(plugins.C, self)
-		final   = filter.Series(cfg.Filter, joined)
+		joined  = tsdb.Join(plugins.C, snmp, http, self)
+		final   = newFilter(cfg.Filter, joined)
 		relays  = relay.NewPool(cfg.Relay, final)
 	)
-	go Restart(func() {
+	go Reload(final, relays, func() {
 		plugins.Kill()
 	})
 	relays.Broadcast()
 }
+
+func newFilter(rules []filter.Rule, series tsdb.Series) *filter.Switch {
+	s, err := filter.NewSwitch(rules, series)
+	if err != nil {
+		log.Panicf("internal error: %v", err)
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package collect
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Control message types.
+const (
+	msgConfig   = "config"
+	msgShutdown = "shutdown"
+)
+
+// maxControlQueue limits the number of control messages pending delivery.
+const maxControlQueue = 4
+
+// message is a control message. Messages are delivered to plugin's standard
+// input, one JSON object per line, for example:
+//
+//	{"Type":"config","Config":{"Targets":["lb001"]}}
+//	{"Type":"shutdown"}
+//
+// The shutdown message is followed by end of file.
+type message struct {
+	Type   string
+	Config json.RawMessage `json:",omitempty"`
+}
+
+// control is a control channel to a plugin process.
+type control struct {
+	w    io.WriteCloser
+	msg  chan *message
+	once sync.Once
+}
+
+// newControl returns a control channel writing to w. The channel delivers
+// the given plugin config as its first message.
+func newControl(w io.WriteCloser, config json.RawMessage) *control {
+	c := &control{
+		w:   w,
+		msg: make(chan *message, maxControlQueue),
+	}
+	c.msg <- &message{Type: msgConfig, Config: config}
+	go c.loop()
+	return c
+}
+
+func (c *control) loop() {
+	defer c.w.Close()
+	enc := json.NewEncoder(c.w)
+	for msg := range c.msg {
+		if err := enc.Encode(msg); err != nil {
+			// The plugin has exited or closed its standard input.
+			statErrors.Add("type=Control", 1)
+			return
+		}
+	}
+}
+
+// Shutdown requests the plugin to exit, and closes the channel.
+func (c *control) Shutdown() {
+	c.once.Do(func() {
+		c.msg <- &message{Type: msgShutdown}
+		close(c.msg)
+	})
+}
+
+// Close closes the channel without requesting shutdown.
+func (c *control) Close() {
+	c.once.Do(func() {
+		close(c.msg)
+	})
+}
@@ -0,0 +1,167 @@
+// Copyright 2014 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package collect
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"opentsp.org/internal/tsdb"
+	"opentsp.org/internal/tsdb/tsdbutil"
+)
+
+// fairReader merges per-process queues into a single channel. The queues are
+// visited in round-robin order, one point at a time, so that a chatty process
+// cannot starve the rest.
+type fairReader struct {
+	mu     sync.Mutex
+	queues []*queue
+	wake   chan bool
+	out    chan<- *tsdb.Point
+}
+
+func newFairReader(out chan<- *tsdb.Point) *fairReader {
+	r := &fairReader{
+		wake: make(chan bool, 1),
+		out:  out,
+	}
+	go r.loop()
+	return r
+}
+
+// NewQueue adds a queue that holds at most size points produced by the program
+// at the given path.
+func (r *fairReader) NewQueue(path string, size int) *queue {
+	q := &queue{
+		name: statName(path),
+		ch:   make(chan *tsdb.Point, size),
+		wake: r.wake,
+		quit: make(chan bool),
+	}
+	statQueueMu.Lock()
+	statQueue.Set("plugin="+q.name, q)
+	statQueueMu.Unlock()
+	r.mu.Lock()
+	r.queues = append(r.queues, q)
+	r.mu.Unlock()
+	return q
+}
+
+func (r *fairReader) loop() {
+	for {
+		r.mu.Lock()
+		queues := r.queues
+		r.mu.Unlock()
+		idle := true
+		for _, q := range queues {
+			select {
+			case point := <-q.ch:
+				r.out <- point
+				idle = false
+			default:
+				if q.isClosed() {
+					r.remove(q)
+				}
+			}
+		}
+		if idle {
+			<-r.wake
+		}
+	}
+}
+
+// remove removes a drained queue. The queues slice is replaced rather than
+// modified in place because loop iterates over a copy of the slice header.
+func (r *fairReader) remove(q *queue) {
+	// A restarted program may have registered its new queue already.
+	statQueueMu.Lock()
+	if statQueue.Get("plugin="+q.name) == q {
+		statQueue.Delete("plugin=" + q.name)
+	}
+	statQueueMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tmp []*queue
+	for _, other := range r.queues {
+		if other != q {
+			tmp = append(tmp, other)
+		}
+	}
+	r.queues = tmp
+}
+
+// statQueueMu serializes the registration and removal of queue stats.
+var statQueueMu sync.Mutex
+
+// queue is a bounded queue of points produced by a single program. It
+// implements expvar.Var, reporting the number of queued points.
+type queue struct {
+	name string
+	ch   chan *tsdb.Point
+	wake chan bool
+	quit chan bool
+}
+
+// Put enqueues a point. If the queue is full, Put blocks until the point is
+// read or the queue is closed. Points put to a closed queue are discarded.
+func (q *queue) Put(point *tsdb.Point) {
+	if q.isClosed() {
+		statErrors.Add("type=Closed", 1)
+		point.Free()
+		return
+	}
+	select {
+	case q.ch <- point:
+		// ok
+	default:
+		statErrors.Add("type=Enqueue", 1)
+		select {
+		case q.ch <- point:
+			// ok
+		case <-q.quit:
+			statErrors.Add("type=Closed", 1)
+			point.Free()
+			return
+		}
+	}
+	q.notify()
+}
+
+// Close marks the queue for removal. The points already queued are still
+// delivered.
+func (q *queue) Close() {
+	close(q.quit)
+	q.notify()
+}
+
+func (q *queue) String() string {
+	return strconv.Itoa(len(q.ch))
+}
+
+func (q *queue) isClosed() bool {
+	select {
+	case <-q.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *queue) notify() {
+	select {
+	case q.wake <- true:
+		// ok
+	default:
+		// reader already notified
+	}
+}
+
+// statName returns the program path, stripped of characters that are invalid
+// in tag values. The full path is used because programs in different
+// directories may share a name.
+func statName(path string) string {
+	return tsdbutil.TagValue(filepath.ToSlash(path))
+}
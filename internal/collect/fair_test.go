@@ -0,0 +1,121 @@
+// Copyright 2014 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package collect
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"opentsp.org/internal/tsdb"
+)
+
+func TestFairReader(t *testing.T) {
+	out := make(chan *tsdb.Point)
+	r := newFairReader(out)
+	chatty := r.NewQueue("/etc/tsp/collect.d/chatty", 100)
+	quiet := r.NewQueue("/etc/tsp/collect.d/quiet", 100)
+	defer chatty.Close()
+	defer quiet.Close()
+	for i := 0; i < 100; i++ {
+		chatty.Put(newTestPoint(t, "chatty"))
+	}
+	quiet.Put(newTestPoint(t, "quiet"))
+	// The quiet point must not wait for the whole chatty backlog.
+	for i := 0; i < 3; i++ {
+		select {
+		case point := <-out:
+			if string(point.Metric()) == "quiet" {
+				return
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	t.Errorf("quiet queue starved")
+}
+
+func TestFairReaderClose(t *testing.T) {
+	out := make(chan *tsdb.Point)
+	r := newFairReader(out)
+	q := r.NewQueue("foo", 1)
+	q.Put(newTestPoint(t, "foo"))
+	q.Close()
+	select {
+	case point := <-out:
+		if got := string(point.Metric()); got != "foo" {
+			t.Errorf("got %q, want %q", got, "foo")
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("queued point lost after Close")
+	}
+	// A Put blocked on a full closed queue must not block forever.
+	done := make(chan bool)
+	go func() {
+		q.Put(newTestPoint(t, "foo"))
+		q.Put(newTestPoint(t, "foo"))
+		close(done)
+	}()
+	select {
+	case <-done:
+		// ok
+	case <-out:
+		<-done
+	case <-time.After(1 * time.Second):
+		t.Fatal("Put blocked on closed queue")
+	}
+}
+
+func TestFairReaderRestart(t *testing.T) {
+	out := make(chan *tsdb.Point)
+	r := newFairReader(out)
+	old := r.NewQueue("/etc/tsp/collect.d/restarted", 1)
+	q := r.NewQueue("/etc/tsp/collect.d/restarted", 1)
+	defer q.Close()
+	old.Close()
+	r.remove(old)
+	if statQueue.Get("plugin=/etc/tsp/collect.d/restarted") != q {
+		t.Errorf("stat of the new queue removed")
+	}
+	before := counter("type=Closed")
+	old.Put(newTestPoint(t, "foo"))
+	if got := counter("type=Closed") - before; got != 1 {
+		t.Errorf("got %d points counted as dropped, want 1", got)
+	}
+	select {
+	case <-out:
+		t.Errorf("point put to closed queue delivered")
+	default:
+		// ok
+	}
+}
+
+func counter(key string) int64 {
+	v, ok := statErrors.Get(key).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
+
+func TestStatName(t *testing.T) {
+	for in, want := range map[string]string{
+		"/etc/tsp/collect.d/cpu.sh": "/etc/tsp/collect.d/cpu.sh",
+		"collect.d/foo bar":         "collect.d/foo_bar",
+		"x=y":                       "x_y",
+	} {
+		if got := statName(in); got != want {
+			t.Errorf("statName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func newTestPoint(t *testing.T, metric string) *tsdb.Point {
+	point, err := tsdb.NewPoint(time.Now(), 1, metric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return point
+}
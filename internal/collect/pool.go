@@ -1,8 +1,11 @@
 package collect
 
 import (
+	"encoding/json"
 	"expvar"
+	"fmt"
 	"log"
+	"path/filepath"
 	"time"
 
 	"opentsp.org/internal/config"
@@ -10,19 +13,92 @@ import (
 )
 
 const (
-	// MaxQueue limits the number of points queued in a Pool.
-	MaxQueue = 10000
+	// DefaultMaxQueue is the default limit on the number of points queued
+	// in a Pool.
+	DefaultMaxQueue = 10000
 
-	// MaxProc limits the number of processes running in parallel.
-	MaxProc = 128
+	// DefaultMaxProc is the default limit on the number of processes running
+	// in parallel.
+	DefaultMaxProc = 128
+
+	// DefaultMaxProcQueue is the default limit on the number of points queued
+	// by a single process.
+	DefaultMaxProcQueue = 100
+
+	// DefaultGracePeriod is the default time allowed for a process to exit
+	// after being asked to.
+	DefaultGracePeriod = 5 * time.Second
+)
+
+const (
+	maxMaxQueue     = 1000000
+	maxMaxProc      = 8192
+	maxMaxProcQueue = 100000
+	maxGracePeriod  = 5 * time.Minute
 )
 
+// Config represents the Pool settings. Zero values select the defaults.
+type Config struct {
+	MaxProc      int    `json:",omitempty"`
+	MaxQueue     int    `json:",omitempty"`
+	MaxProcQueue int    `json:",omitempty"`
+	GracePeriod  string `json:",omitempty"`
+
+	// Plugin holds plugin settings keyed by program file name. A plugin
+	// with settings receives control messages via standard input, see
+	// message. It is typically served by tsp-controller as part of the
+	// dynamic config.
+	Plugin map[string]json.RawMessage `json:"-"`
+
+	gracePeriod time.Duration
+}
+
+func (c *Config) Validate() error {
+	switch max := c.MaxProc; {
+	default:
+		return fmt.Errorf("MaxProc out of range: %d", max)
+	case max == 0:
+		c.MaxProc = DefaultMaxProc
+	case 0 < max && max <= maxMaxProc:
+		// ok
+	}
+	switch max := c.MaxQueue; {
+	default:
+		return fmt.Errorf("MaxQueue out of range: %d", max)
+	case max == 0:
+		c.MaxQueue = DefaultMaxQueue
+	case 0 < max && max <= maxMaxQueue:
+		// ok
+	}
+	switch max := c.MaxProcQueue; {
+	default:
+		return fmt.Errorf("MaxProcQueue out of range: %d", max)
+	case max == 0:
+		c.MaxProcQueue = DefaultMaxProcQueue
+	case 0 < max && max <= maxMaxProcQueue:
+		// ok
+	}
+	c.gracePeriod = DefaultGracePeriod
+	if c.GracePeriod != "" {
+		d, err := time.ParseDuration(c.GracePeriod)
+		if err != nil {
+			return fmt.Errorf("invalid GracePeriod: %v", err)
+		}
+		if d <= 0 || d > maxGracePeriod {
+			return fmt.Errorf("GracePeriod out of range: %v", d)
+		}
+		c.gracePeriod = d
+	}
+	return nil
+}
+
 // Pool represents a pool of plugin processes.
 type Pool struct {
 	C         tsdb.Chan
+	config    *Config
 	directory *config.Directory
 	byPath    map[string]*directoryEntry
-	next      chan *tsdb.Point
+	reader    *fairReader
 	quit      chan bool
 }
 
@@ -30,15 +106,23 @@ type Pool struct {
 // held in the given directory. Pool automatically starts/terminates processes
 // in response to directory events.
 //
-// The pool has bounded process count, see MaxProc. An attempt to create
+// The pool has bounded process count, see Config. An attempt to create
 // additional process is logged and ignored.
-func NewPool(path string) *Pool {
-	ch := make(chan *tsdb.Point, MaxQueue)
+//
+// Each process writes to its own bounded queue. The queues are read in
+// round-robin order, so a process that outpaces the pool's consumer is
+// blocked without affecting the others.
+func NewPool(path string, config_ *Config) *Pool {
+	if err := config_.Validate(); err != nil {
+		log.Panicf("internal error: %v", err)
+	}
+	ch := make(chan *tsdb.Point, config_.MaxQueue)
 	pool := &Pool{
 		C:         ch,
+		config:    config_,
 		directory: config.WatchDirectory(path),
 		byPath:    make(map[string]*directoryEntry),
-		next:      ch,
+		reader:    newFairReader(ch),
 		quit:      make(chan bool),
 	}
 	statQueue.Set("", expvar.Func(func() interface{} {
@@ -89,11 +173,12 @@ func (pool *Pool) loop() {
 
 // add adds a directory entry to the pool.
 func (pool *Pool) add(path string) {
-	if max := MaxProc; len(pool.byPath) == max {
+	if max := pool.config.MaxProc; len(pool.byPath) == max {
 		log.Printf("pool: error adding %s: process limit reached (%d)", path, max)
 		return
 	}
-	entry := newEntry(path, pool.next)
+	w := pool.reader.NewQueue(path, pool.config.MaxProcQueue)
+	entry := newEntry(path, w, pool.config.Plugin[filepath.Base(path)], pool.config.gracePeriod)
 	pool.byPath[path] = entry
 }
 
@@ -106,15 +191,19 @@ func (pool *Pool) del(path string) {
 type directoryEntry struct {
 	path         string
 	event        chan *config.DirectoryEvent
-	w            chan<- *tsdb.Point
+	w            *queue
+	config       json.RawMessage
+	grace        time.Duration
 	RestartDelay <-chan time.Time
 }
 
-func newEntry(path string, w chan<- *tsdb.Point) *directoryEntry {
+func newEntry(path string, w *queue, config_ json.RawMessage, grace time.Duration) *directoryEntry {
 	entry := &directoryEntry{
-		path:  path,
-		event: make(chan *config.DirectoryEvent),
-		w:     w,
+		path:   path,
+		event:  make(chan *config.DirectoryEvent),
+		w:      w,
+		config: config_,
+		grace:  grace,
 	}
 	go entry.mainloop()
 	return entry
@@ -131,7 +220,8 @@ func (entry *directoryEntry) Kill() {
 
 func (entry *directoryEntry) mainloop() {
 	defer close(entry.event)
-	process := startProcess(entry.path, entry.w)
+	defer entry.w.Close()
+	process := startProcess(entry.path, entry.w, entry.config, entry.grace)
 	for {
 		select {
 		case event := <-entry.event:
@@ -145,7 +235,7 @@ func (entry *directoryEntry) mainloop() {
 					<-process.Exit
 				}
 				entry.RestartDelay = nil // cancel the restart
-				process = startProcess(entry.path, entry.w)
+				process = startProcess(entry.path, entry.w, entry.config, entry.grace)
 			case event.IsRemove:
 				if entry.RestartDelay == nil {
 					if event != killRequest {
@@ -160,7 +250,7 @@ func (entry *directoryEntry) mainloop() {
 			entry.RestartDelay = restart(process, err)
 		case <-entry.RestartDelay:
 			entry.RestartDelay = nil
-			process = startProcess(entry.path, entry.w)
+			process = startProcess(entry.path, entry.w, entry.config, entry.grace)
 		}
 	}
 }
@@ -7,6 +7,7 @@ package collect
 
 import (
 	"bufio"
+	"encoding/json"
 	"expvar"
 	"fmt"
 	"io"
@@ -35,22 +36,29 @@ var (
 	statPoints       = expvar.NewInt("collect.Points")
 	statProcessCount = expvar.NewInt("collect.ProcessCount")
 	statQueue        = expvar.NewMap("collect.Queue")
+	statRestarts     = expvar.NewMap("collect.Restarts")
 )
 
 // process represents a running collection program.
 type process struct {
 	path       string
+	grace      time.Duration
 	cmd        *exec.Cmd
 	closePipes func()
+	control    *control
 	killChan   chan bool
 	Start      time.Time
 	Exit       chan error
 }
 
 // startProcess starts a new process corresponding to the given directory path.
-func startProcess(path string, w chan<- *tsdb.Point) *process {
+// If config is non-nil, the process is passed control messages via standard
+// input, starting with the config. The process is given the grace period to
+// exit once terminated, see terminate.
+func startProcess(path string, w *queue, config json.RawMessage, grace time.Duration) *process {
 	p := &process{
 		path:     path,
+		grace:    grace,
 		killChan: make(chan bool, 1),
 		Start:    time.Now(),
 		Exit:     make(chan error, 1),
@@ -69,24 +77,52 @@ func startProcess(path string, w chan<- *tsdb.Point) *process {
 		p.Exit <- &startError{err}
 		return p
 	}
+	var stdinRead, stdinWrite *os.File
+	if config != nil {
+		stdinRead, stdinWrite, err = os.Pipe()
+		if err != nil {
+			stdoutRead.Close()
+			stdoutWrite.Close()
+			stderrRead.Close()
+			stderrWrite.Close()
+			statErrors.Add("type=Start", 1)
+			p.Exit <- &startError{err}
+			return p
+		}
+	}
 	p.cmd = exec.Command(path)
 	p.cmd.Env = safeEnviron()
+	p.cmd.SysProcAttr = sysProcAttr()
 	p.cmd.Stdout = stdoutWrite
 	p.cmd.Stderr = stderrWrite
+	if stdinRead != nil {
+		p.cmd.Stdin = stdinRead
+	}
 	if err := p.cmd.Start(); err != nil {
 		stdoutWrite.Close()
 		stderrWrite.Close()
 		stdoutRead.Close()
 		stderrRead.Close()
+		if stdinRead != nil {
+			stdinRead.Close()
+			stdinWrite.Close()
+		}
 		statErrors.Add("type=Start", 1)
 		p.Exit <- &startError{err}
 	} else {
 		statProcessCount.Add(1)
 		stdoutWrite.Close()
 		stderrWrite.Close()
+		if stdinRead != nil {
+			stdinRead.Close()
+			p.control = newControl(stdinWrite, config)
+		}
 		p.closePipes = func() {
 			stdoutRead.Close()
 			stderrRead.Close()
+			if p.control != nil {
+				p.control.Close()
+			}
 		}
 		go p.decode(stdoutRead, w)
 		go p.stderrLogger(stderrRead)
@@ -112,7 +148,7 @@ func (p *process) Printf(format string, arg ...interface{}) {
 }
 
 // decode decodes data points errors available via stdout.
-func (p *process) decode(r io.Reader, w chan<- *tsdb.Point) {
+func (p *process) decode(r io.Reader, w *queue) {
 	dec := newDecoder(r, idleTimeout)
 	for {
 		point, err := dec.Decode()
@@ -130,13 +166,7 @@ func (p *process) decode(r io.Reader, w chan<- *tsdb.Point) {
 			}
 		}
 		statPoints.Add(1)
-		select {
-		case w <- point:
-			// ok
-		default:
-			statErrors.Add("type=Enqueue", 1)
-			w <- point
-		}
+		w.Put(point)
 	}
 }
 
@@ -152,42 +182,100 @@ func (p *process) stderrLogger(r io.Reader) {
 
 // handleKill handles termination of the process.
 func (p *process) handleKill() {
-	wait := make(chan error)
+	exited := make(chan exit, 1)
 	go func() {
-		err := p.cmd.Wait()
-		if err == nil {
-			err = &cleanExit{}
-		}
-		wait <- err
+		exited <- waitExit(p.cmd)
 	}()
 	select {
-	case err := <-wait:
+	case e := <-exited:
 		// Process died on its own, no need to kill.
 		p.closePipes()
-		p.Exit <- err
+		p.Exit <- p.reap(e)
 	case <-p.killChan:
 		// Process alive but required to die.
-		if err := p.cmd.Process.Kill(); err != nil {
-			p.Printf("kill error: %v", err)
+		p.Exit <- p.terminate(exited)
+	}
+	statProcessCount.Add(-1)
+}
+
+// exit reports the exit of a process, see waitExit.
+type exit struct {
+	reaped bool
+	err    error // exit status, if reaped
+}
+
+// reap reaps the exited process, unless already reaped, and returns its exit
+// status.
+func (p *process) reap(e exit) error {
+	err := e.err
+	if !e.reaped {
+		err = p.cmd.Wait()
+	}
+	if err == nil {
+		err = &cleanExit{}
+	}
+	return err
+}
+
+// terminate terminates the process, returning its exit status. A process
+// that accepts control messages is first requested to shut down. Next, the
+// process group is sent a termination signal, followed by a kill signal if
+// the process fails to exit within the grace period. The grace period covers
+// both requests: the shutdown request is given half of it. The process group
+// is killed even if the process exits in time, to clean up its children; to
+// ensure the group id is not reused, this is done before the process is
+// reaped, and skipped on platforms that cannot wait for a process without
+// reaping it.
+func (p *process) terminate(exited chan exit) error {
+	deadline := time.Now().Add(p.grace)
+	if p.control != nil {
+		p.control.Shutdown()
+		select {
+		case e := <-exited:
+			return p.cleanup(e)
+		case <-time.After(p.grace / 2):
+			p.Printf("shutdown timeout (%v), terminating", p.grace/2)
 		}
-		// Cause "broken pipe" error on all pipe writers. The set of all writers
-		// may be larger than the process just killed (think fd inheritance).
-		p.closePipes()
-		// Block until the process is reaped.
-		var err error
-		for {
-			select {
-			case err = <-wait:
-				// ok
-			case <-time.After(exitTimeout):
-				p.Printf("slow exit, still waiting...")
-				continue
-			}
-			break
+	}
+	if err := terminate(p.cmd.Process); err != nil {
+		p.Printf("terminate error: %v", err)
+	}
+	select {
+	case e := <-exited:
+		return p.cleanup(e)
+	case <-time.After(time.Until(deadline)):
+		statErrors.Add("type=Kill", 1)
+		p.Printf("terminate timeout (%v), killing", p.grace)
+	}
+	p.killGroup()
+	// Cause "broken pipe" error on all pipe writers. The set of all writers
+	// may be larger than the process just killed (think fd inheritance).
+	p.closePipes()
+	// Block until the process is reaped.
+	for {
+		select {
+		case e := <-exited:
+			return p.reap(e)
+		case <-time.After(exitTimeout):
+			p.Printf("slow exit, still waiting...")
 		}
-		p.Exit <- err
 	}
-	statProcessCount.Add(-1)
+}
+
+// cleanup kills the remaining members of the process group of the exited
+// process, and reaps the process.
+func (p *process) cleanup(e exit) error {
+	if !e.reaped {
+		p.killGroup()
+	}
+	p.closePipes()
+	return p.reap(e)
+}
+
+func (p *process) killGroup() {
+	if err := kill(p.cmd.Process); err != nil {
+		p.Printf("kill error: %v", err)
+	}
 }
 
 type startError struct {
@@ -202,6 +290,7 @@ func (*cleanExit) Error() string {
 
 // restart returns a schedule for process restart based on its exit status.
 func restart(process *process, err error) <-chan time.Time {
+	statRestarts.Add("plugin="+statName(process.path), 1)
 	delay := retryDelay
 	switch err.(type) {
 	default:
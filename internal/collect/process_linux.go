@@ -0,0 +1,31 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package collect
+
+import (
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+const _P_PID = 1 // idtype_t of waitid(2)
+
+// waitExit blocks until the process exits. The process is left unreaped, so
+// that its process group id cannot be reused until it is reaped.
+func waitExit(cmd *exec.Cmd) exit {
+	var info [128]byte // siginfo_t
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, _P_PID, uintptr(cmd.Process.Pid),
+			uintptr(unsafe.Pointer(&info[0])), syscall.WEXITED|syscall.WNOWAIT, 0, 0)
+		switch errno {
+		case 0:
+			return exit{}
+		case syscall.EINTR:
+			continue
+		}
+		// Fall back to reaping the process.
+		return exit{reaped: true, err: cmd.Wait()}
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+// +build !linux
+
+package collect
+
+import "os/exec"
+
+// waitExit blocks until the process exits, and reaps it. Unlike on Linux,
+// the process cannot be left unreaped, so its process group is not killed
+// once the process has exited.
+func waitExit(cmd *exec.Cmd) exit {
+	return exit{reaped: true, err: cmd.Wait()}
+}
@@ -7,6 +7,7 @@
 package collect
 
 import (
+	"os"
 	"os/exec"
 	"syscall"
 )
@@ -21,3 +22,28 @@ func reschedule(err error) bool {
 	}
 	return false
 }
+
+// sysProcAttr places each plugin in its own process group, so that the
+// plugin's children can be signalled together with the plugin.
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminate sends SIGTERM to the process group led by the given process.
+func terminate(process *os.Process) error {
+	return signalGroup(process, syscall.SIGTERM)
+}
+
+// kill sends SIGKILL to the process group led by the given process.
+func kill(process *os.Process) error {
+	return signalGroup(process, syscall.SIGKILL)
+}
+
+func signalGroup(process *os.Process, sig syscall.Signal) error {
+	err := syscall.Kill(-process.Pid, sig)
+	if err == syscall.ESRCH {
+		// The group is gone already.
+		return nil
+	}
+	return err
+}
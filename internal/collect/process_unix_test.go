@@ -0,0 +1,126 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package collect
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"opentsp.org/internal/tsdb"
+)
+
+// TestKillGroup tests that killing a plugin also kills its children.
+func TestKillGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collecttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "child.pid")
+	script := filepath.Join(dir, "plugin")
+	body := "#!/bin/sh\ntrap '' TERM\nsleep 1000 &\necho $! >" + pidFile + "\nwait\n"
+	if err := ioutil.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r := newFairReader(make(chan *tsdb.Point))
+	w := r.NewQueue(script, 1)
+	defer w.Close()
+	p := startProcess(script, w, nil, 100*time.Millisecond)
+	var child int
+	for i := 0; i < 100 && child == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		buf, _ := ioutil.ReadFile(pidFile)
+		child, _ = strconv.Atoi(strings.TrimSpace(string(buf)))
+	}
+	if child == 0 {
+		t.Fatal("child not started")
+	}
+	p.Kill()
+	select {
+	case <-p.Exit:
+		// ok
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin not killed")
+	}
+	for i := 0; i < 100; i++ {
+		if err := syscall.Kill(child, 0); err == syscall.ESRCH || isZombie(child) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("child %d survived plugin kill", child)
+}
+
+// isZombie reports if the given process has exited but was not reaped, which
+// happens if the init process of the test environment does not reap orphans.
+func isZombie(pid int) bool {
+	buf, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	i := strings.LastIndex(string(buf), ")")
+	return i > 0 && strings.HasPrefix(string(buf[i:]), ") Z")
+}
+
+// TestTerminateGroup tests that the children of a plugin that exits in time
+// are killed, and that the shutdown request and the termination signal
+// share the grace period.
+func TestTerminateGroup(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process group not killed after exit on " + runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "collecttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "child.pid")
+	script := filepath.Join(dir, "plugin")
+	body := "#!/bin/sh\n(trap '' TERM; sleep 1000) &\necho $! >" + pidFile + "\nsleep 1000\n"
+	if err := ioutil.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r := newFairReader(make(chan *tsdb.Point))
+	w := r.NewQueue(script, 1)
+	defer w.Close()
+	grace := 400 * time.Millisecond
+	p := startProcess(script, w, json.RawMessage(`{}`), grace)
+	var child int
+	for i := 0; i < 100 && child == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		buf, _ := ioutil.ReadFile(pidFile)
+		child, _ = strconv.Atoi(strings.TrimSpace(string(buf)))
+	}
+	if child == 0 {
+		t.Fatal("child not started")
+	}
+	start := time.Now()
+	p.Kill()
+	select {
+	case <-p.Exit:
+		if d := time.Since(start); d > grace {
+			t.Errorf("plugin stopped after %v, want at most %v", d, grace)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin not terminated")
+	}
+	for i := 0; i < 100; i++ {
+		if err := syscall.Kill(child, 0); err == syscall.ESRCH || isZombie(child) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("child %d survived plugin exit", child)
+}
@@ -19,5 +19,15 @@ func sysProcAttr() *syscall.SysProcAttr {
 // BUG(masiulaniecj): On Windows, plugins must run in single process, i.e. must not
 // have child processes themselves.
 func kill(process *os.Process) error {
+	err := process.Kill()
+	if err == os.ErrProcessDone {
+		return nil
+	}
+	return err
+}
+
+// terminate kills the process: Windows has no equivalent of SIGTERM, so the
+// plugin is not given the grace period.
+func terminate(process *os.Process) error {
 	return process.Kill()
 }
@@ -0,0 +1,119 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"expvar"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultCacheDir is the default directory of server payload cache files. It
+// may be overridden using the CONTROL_CACHE_DIR environment variable.
+const defaultCacheDir = "/var/cache/tsp"
+
+// cacheTimeout determines how long the initial load waits for the server
+// before falling back to the cached payload.
+const cacheTimeout = 30 * time.Second
+
+func init() {
+	expvar.Publish("config.Age", expvar.Func(func() interface{} {
+		t := atomic.LoadInt64(&confirmed)
+		if t == 0 {
+			return 0
+		}
+		return int64(time.Since(time.Unix(0, t)) / time.Second)
+	}))
+}
+
+// confirmed holds the time, in Unix nanoseconds, when the server last
+// confirmed the config. The config.Age stat reports the number of seconds
+// since then.
+var confirmed int64
+
+func confirm(t time.Time) {
+	for {
+		old := atomic.LoadInt64(&confirmed)
+		if t.UnixNano() <= old {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&confirmed, old, t.UnixNano()) {
+			return
+		}
+	}
+}
+
+// cache persists the last server payload that produced a valid config,
+// enabling startup during server outages.
+type cache struct {
+	path string
+}
+
+// newCache returns the cache for the given server path.
+func newCache(serverPath string) *cache {
+	dir := os.Getenv("CONTROL_CACHE_DIR")
+	if dir == "" {
+		dir = defaultCacheDir
+	}
+	name := serverPath
+	if i := strings.Index(name, "?"); i != -1 {
+		name = name[:i]
+	}
+	name = strings.Replace(name, "/", "_", -1)
+	return &cache{filepath.Join(dir, name+".json")}
+}
+
+// Load returns the cached payload, or nil if none is available.
+func (c *cache) Load() []byte {
+	fi, err := os.Stat(c.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			statLoadErrors.Add("type=Cache", 1)
+			log.Printf("config: cache error: %v", err)
+		}
+		return nil
+	}
+	buf, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		statLoadErrors.Add("type=Cache", 1)
+		log.Printf("config: cache error: %v", err)
+		return nil
+	}
+	confirm(fi.ModTime())
+	return buf
+}
+
+// Store replaces the cached payload.
+func (c *cache) Store(buf []byte) {
+	if err := c.store(buf); err != nil {
+		statLoadErrors.Add("type=Cache", 1)
+		log.Printf("config: cache error: %v", err)
+	}
+}
+
+func (c *cache) store(buf []byte) error {
+	dir := filepath.Dir(c.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
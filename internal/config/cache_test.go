@@ -0,0 +1,36 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("CONTROL_CACHE_DIR", filepath.Join(dir, "cache"))
+	defer os.Unsetenv("CONTROL_CACHE_DIR")
+	c := newCache("tsp-forwarder?host={{.Hostname}}")
+	if want := filepath.Join(dir, "cache", "tsp-forwarder.json"); c.path != want {
+		t.Errorf("got path %q, want %q", c.path, want)
+	}
+	if buf := c.Load(); buf != nil {
+		t.Errorf("unexpected payload in empty cache: %q", buf)
+	}
+	c.Store([]byte(`{"Relay": {}}`))
+	if buf := c.Load(); string(buf) != `{"Relay": {}}` {
+		t.Errorf("got payload %q", buf)
+	}
+	if confirmed == 0 {
+		t.Errorf("cache load did not set config age")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// document represents a config file decoded from a format other than JSON.
+// The value is built of maps, slices, strings, numbers, booleans and nil, as
+// in values decoded by encoding/json.
+type document struct {
+	value interface{}
+
+	// lines maps value paths to line numbers in the source file.
+	lines map[string]int
+}
+
+func newDocument() *document {
+	return &document{lines: make(map[string]int)}
+}
+
+// joinPath returns the path of the element of the node at the given path.
+func joinPath(path, elem string) string {
+	if path == "" {
+		return elem
+	}
+	return path + "." + elem
+}
+
+// span maps the JSON encoding of a value to its source line.
+type span struct {
+	start, end int // byte offsets
+	line       int
+}
+
+// encode returns the JSON encoding of the document, along with spans of the
+// values whose source line is known.
+func (d *document) encode() ([]byte, []span) {
+	var (
+		buf   bytes.Buffer
+		spans []span
+	)
+	d.encodeValue(&buf, &spans, d.value, "")
+	return buf.Bytes(), spans
+}
+
+func (d *document) encodeValue(buf *bytes.Buffer, spans *[]span, v interface{}, path string) {
+	start := buf.Len()
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, _ := json.Marshal(key)
+			buf.Write(k)
+			buf.WriteByte(':')
+			d.encodeValue(buf, spans, v[key], joinPath(path, key))
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			d.encodeValue(buf, spans, elem, joinPath(path, fmt.Sprint(i)))
+		}
+		buf.WriteByte(']')
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			panic(err)
+		}
+		buf.Write(b)
+	}
+	if line, ok := d.lines[path]; ok && path != "" {
+		*spans = append(*spans, span{start, buf.Len(), line})
+	}
+}
+
+// decode decodes the document into the given config struct. Type errors are
+// reported with the line number of the offending value.
+func (d *document) decode(config interface{}) error {
+	buf, spans := d.encode()
+	err := json.Unmarshal(buf, config)
+	if err == nil {
+		return nil
+	}
+	typeErr, ok := err.(*json.UnmarshalTypeError)
+	if !ok {
+		return err
+	}
+	// The error offset points past the offending value. Find the innermost
+	// value that contains it.
+	var found *span
+	for i := range spans {
+		s := &spans[i]
+		if s.start < int(typeErr.Offset) && int(typeErr.Offset) <= s.end {
+			if found == nil || s.start > found.start {
+				found = s
+			}
+		}
+	}
+	if found == nil {
+		return err
+	}
+	return fmt.Errorf("line %d: %v", found.line, err)
+}
+
+// keys returns the top-level keys of the document.
+func (d *document) keys() []string {
+	m, _ := d.value.(map[string]interface{})
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,241 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historySize is the number of configs kept in history.
+const historySize = 10
+
+// maxDiffLog limits the number of diff lines logged per config update.
+const maxDiffLog = 50
+
+// historyEntry represents a config that has been loaded.
+type historyEntry struct {
+	Time   time.Time
+	Diff   []string `json:",omitempty"` // changes relative to the previous entry
+	Config json.RawMessage
+}
+
+var history struct {
+	sync.Mutex
+	entries []*historyEntry // newest first
+	path    string          // persistent copy, if any
+}
+
+// historyPath returns the path of the persistent copy of history, which is
+// kept next to the server payload cache. It survives restarts that follow
+// config updates.
+func historyPath(c *cache) string {
+	return strings.TrimSuffix(c.path, ".json") + ".history.json"
+}
+
+// loadHistory reads the persistent copy of history.
+func loadHistory(path string) {
+	history.Lock()
+	defer history.Unlock()
+	history.path = path
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("config: history error: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(buf, &history.entries); err != nil {
+		log.Printf("config: history error: %s: %v", path, err)
+		history.entries = nil
+	}
+}
+
+// record adds the given config to history, and logs its differences from the
+// previous config. A config equal to the previous one is not recorded. Secret
+// fields are masked, see redact.
+func record(config Struct, t time.Time) {
+	buf, err := json.Marshal(redact(config))
+	if err != nil {
+		log.Panic(err)
+	}
+	history.Lock()
+	defer history.Unlock()
+	entry := &historyEntry{Time: t, Config: buf}
+	if len(history.entries) > 0 {
+		last := history.entries[0]
+		if bytes.Equal(last.Config, buf) {
+			return
+		}
+		entry.Diff = diffJSON(last.Config, buf)
+		logDiff(entry.Diff)
+	}
+	history.entries = append([]*historyEntry{entry}, history.entries...)
+	if len(history.entries) > historySize {
+		history.entries = history.entries[:historySize]
+	}
+	if history.path != "" {
+		buf, _ := json.Marshal(history.entries)
+		c := &cache{history.path}
+		if err := c.store(buf); err != nil {
+			log.Printf("config: history error: %v", err)
+		}
+	}
+}
+
+func logDiff(diff []string) {
+	for i, line := range diff {
+		if i == maxDiffLog {
+			log.Printf("config: changed: ... (%d more)", len(diff)-i)
+			break
+		}
+		log.Printf("config: changed: %s", line)
+	}
+}
+
+// ServeHistory serves the config history in JSON encoding, newest config
+// first. Each config is accompanied by the load time and the list of changes
+// relative to the previous config. Secret fields are masked.
+func ServeHistory(w http.ResponseWriter, req *http.Request) {
+	history.Lock()
+	buf, err := json.MarshalIndent(history.entries, "", "\t")
+	history.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(buf)
+}
+
+// diffJSON returns the field-level differences between the given JSON
+// values, one per line.
+func diffJSON(a, b []byte) []string {
+	var x, y interface{}
+	json.Unmarshal(a, &x)
+	json.Unmarshal(b, &y)
+	var diff []string
+	diffValue(&diff, "", x, y)
+	return diff
+}
+
+func diffValue(diff *[]string, path string, a, b interface{}) {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		if b, ok := b.(map[string]interface{}); ok {
+			var keys []string
+			for key := range a {
+				keys = append(keys, key)
+			}
+			for key := range b {
+				if _, ok := a[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				x, inA := a[key]
+				y, inB := b[key]
+				switch {
+				case !inA:
+					*diff = append(*diff, fmt.Sprintf("%s: added %s", joinPath(path, key), compact(y)))
+				case !inB:
+					*diff = append(*diff, fmt.Sprintf("%s: removed %s", joinPath(path, key), compact(x)))
+				default:
+					diffValue(diff, joinPath(path, key), x, y)
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok {
+			diffArray(diff, path, a, b)
+			return
+		}
+	}
+	x, y := compact(a), compact(b)
+	if x != y {
+		*diff = append(*diff, fmt.Sprintf("%s: %s -> %s", path, x, y))
+	}
+}
+
+// diffArray reports the differences between arrays element by element, so
+// that an insertion does not show as a change of every element that follows.
+// Elements common to both arrays are matched using the longest common
+// subsequence. Between matches, the remaining elements are paired up and
+// diffed; the rest are reported as added, with their new index, or removed,
+// with their old index.
+func diffArray(diff *[]string, path string, a, b []interface{}) {
+	x := make([]string, len(a))
+	for i := range a {
+		x[i] = compact(a[i])
+	}
+	y := make([]string, len(b))
+	for j := range b {
+		y[j] = compact(b[j])
+	}
+	// lcs[i][j] is the length of the longest common subsequence of
+	// x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			switch {
+			case x[i] == y[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		if i < len(x) && j < len(y) && x[i] == y[j] {
+			i++
+			j++
+			continue
+		}
+		// Find the end of the gap before the next match.
+		gi, gj := i, j
+		for gi < len(x) && gj < len(y) && x[gi] != y[gj] {
+			if lcs[gi+1][gj] >= lcs[gi][gj+1] {
+				gi++
+			} else {
+				gj++
+			}
+		}
+		if gi == len(x) || gj == len(y) {
+			gi, gj = len(x), len(y)
+		}
+		for ; i < gi && j < gj; i, j = i+1, j+1 {
+			diffValue(diff, joinPath(path, strconv.Itoa(j)), a[i], b[j])
+		}
+		for ; i < gi; i++ {
+			*diff = append(*diff, fmt.Sprintf("%s: removed %s", joinPath(path, strconv.Itoa(i)), x[i]))
+		}
+		for ; j < gj; j++ {
+			*diff = append(*diff, fmt.Sprintf("%s: added %s", joinPath(path, strconv.Itoa(j)), y[j]))
+		}
+	}
+}
+
+func compact(v interface{}) string {
+	buf, _ := json.Marshal(v)
+	return string(buf)
+}
@@ -0,0 +1,149 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testDiffJSON = []struct {
+	a, b string
+	want []string
+}{
+	{`{"Foo": "x"}`, `{"Foo": "x"}`, nil},
+	{`{"Foo": "x"}`, `{"Foo": "y"}`, []string{`Foo: "x" -> "y"`}},
+	{
+		`{"Filter": [{"Match": ["a"]}, {"Block": true}]}`,
+		`{"Filter": [{"Match": ["b"]}]}`,
+		[]string{
+			`Filter.0.Match.0: "a" -> "b"`,
+			`Filter.1: removed {"Block":true}`,
+		},
+	},
+	{
+		`{"Relay": {"a": {"Host": "x"}}}`,
+		`{"Relay": {"a": {"Host": "x", "Port": 1}, "b": {"Host": "y"}}}`,
+		[]string{
+			`Relay.a.Port: added 1`,
+			`Relay.b: added {"Host":"y"}`,
+		},
+	},
+	{`{"Bar": [1]}`, `{"Bar": null}`, []string{`Bar: [1] -> null`}},
+	{`{"Bar": [1, 2, 3]}`, `{"Bar": [0, 1, 2, 3]}`, []string{`Bar.0: added 0`}},
+	{`{"Bar": [1, 2, 3]}`, `{"Bar": [1, 3]}`, []string{`Bar.1: removed 2`}},
+	{`{"Bar": [1, 2, 3]}`, `{"Bar": [3, 1, 2]}`, []string{`Bar.0: added 3`, `Bar.2: removed 3`}},
+	{
+		`{"Filter": [{"Block": true}, {"Match": ["a"]}, {"Block": false}]}`,
+		`{"Filter": [{"Set": {}}, {"Block": true}, {"Match": ["b", "a"]}, {"Block": false}, {}]}`,
+		[]string{
+			`Filter.0: added {"Set":{}}`,
+			`Filter.2.Match.0: added "b"`,
+			`Filter.4: added {}`,
+		},
+	},
+}
+
+func TestDiffJSON(t *testing.T) {
+	for i, tt := range testDiffJSON {
+		got := diffJSON([]byte(tt.a), []byte(tt.b))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d. invalid diff\ngot:  %q\nwant: %q", i, got, tt.want)
+		}
+	}
+}
+
+type testSecretConfig struct {
+	Foo    string
+	Secret string                     `config:"secret"`
+	Unset  string                     `config:"secret"`
+	Plugin map[string]json.RawMessage `config:"dynamic,secret"`
+	User   *testUser
+	Users  []testUser
+}
+
+type testUser struct {
+	Name     string
+	Password string `config:"secret"`
+}
+
+func (c *testSecretConfig) Reset()          { *c = testSecretConfig{} }
+func (c *testSecretConfig) Validate() error { return nil }
+
+func TestRedact(t *testing.T) {
+	config := &testSecretConfig{
+		Foo:    "x",
+		Secret: "s",
+		Plugin: map[string]json.RawMessage{"foo": json.RawMessage(`{"Password": "p"}`)},
+		User:   &testUser{"u", "p"},
+		Users:  []testUser{{"v", "q"}},
+	}
+	buf, err := json.Marshal(redact(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Foo":"x","Secret":"******","Unset":"","Plugin":{"foo":"******"},` +
+		`"User":{"Name":"u","Password":"******"},"Users":[{"Name":"v","Password":"******"}]}`
+	if string(buf) != want {
+		t.Errorf("invalid redacted config\ngot:  %s\nwant: %s", buf, want)
+	}
+	if config.Secret != "s" || config.User.Password != "p" || config.Users[0].Password != "q" ||
+		string(config.Plugin["foo"]) != `{"Password": "p"}` {
+		t.Errorf("config modified: %+v", config)
+	}
+}
+
+func TestRecordSecret(t *testing.T) {
+	defer func() { history.entries = nil }()
+	history.entries = nil
+	record(&testSecretConfig{Secret: "a"}, time.Unix(1, 0))
+	record(&testSecretConfig{Secret: "b", User: &testUser{"u", "p"}}, time.Unix(2, 0))
+	for _, entry := range history.entries {
+		if strings.Contains(string(entry.Config), `"a"`) || strings.Contains(string(entry.Config), `"b"`) ||
+			strings.Contains(string(entry.Config), `"p"`) {
+			t.Errorf("secret recorded: %s", entry.Config)
+		}
+	}
+	want := []string{`User: null -> {"Name":"u","Password":"******"}`}
+	if got := history.entries[0].Diff; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid diff\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.json")
+	loadHistory(path)
+	defer func() {
+		history.entries = nil
+		history.path = ""
+	}()
+	for i := 0; i < historySize+2; i++ {
+		record(&testConfig{Quux: i}, time.Unix(int64(i), 0))
+		record(&testConfig{Quux: i}, time.Unix(int64(i), 0))
+	}
+	if len(history.entries) != historySize {
+		t.Fatalf("got %d entries, want %d", len(history.entries), historySize)
+	}
+	want := []string{`Quux: 10 -> 11`}
+	if got := history.entries[0].Diff; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid diff\ngot:  %q\nwant: %q", got, want)
+	}
+	saved := history.entries
+	loadHistory(path)
+	if !reflect.DeepEqual(history.entries, saved) {
+		t.Errorf("history not persisted")
+	}
+}
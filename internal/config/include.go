@@ -0,0 +1,147 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// includeDir returns the include directory of the given config file.
+func includeDir(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".d"
+}
+
+// includePaths returns paths of the files in the include directory of the
+// given config file, in lexical order. Files with extensions other than
+// .json, .yaml, .yml, and .toml are ignored.
+func includePaths(path string) ([]string, error) {
+	dir, err := os.Open(includeDir(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var paths []string
+	for _, name := range names {
+		ext := filepath.Ext(name)
+		if ext != ".json" && parsers[ext] == nil {
+			continue
+		}
+		paths = append(paths, filepath.Join(includeDir(path), name))
+	}
+	return paths, nil
+}
+
+// canonicalKeys renames the top-level keys that match config struct fields to
+// the field names, so that keys differing in case are merged.
+func canonicalKeys(config interface{}, m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	for key, v := range m {
+		if name := fieldNames(config, []string{key}); name != nil {
+			key = name[0]
+		}
+		out[key] = v
+	}
+	return out
+}
+
+// mergeValue merges src into dst. Objects are merged, arrays are appended
+// to, and other values are replaced.
+func mergeValue(dst, src interface{}, path string) (interface{}, error) {
+	switch src := src.(type) {
+	case map[string]interface{}:
+		switch dst := dst.(type) {
+		case nil:
+			return src, nil
+		case map[string]interface{}:
+			for key, v := range src {
+				var err error
+				dst[key], err = mergeValue(dst[key], v, joinPath(path, key))
+				if err != nil {
+					return nil, err
+				}
+			}
+			return dst, nil
+		}
+	case []interface{}:
+		switch dst := dst.(type) {
+		case nil:
+			return src, nil
+		case []interface{}:
+			return append(dst, src...), nil
+		}
+	default:
+		if src == nil || kind(dst) == "scalar" {
+			return src, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: cannot merge %s into %s", path, kind(src), kind(dst))
+}
+
+func kind(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return "scalar"
+}
+
+var envRE = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandValue expands environment variable references and template actions
+// in the string values found in v.
+func expandValue(v interface{}) (interface{}, error) {
+	var err error
+	switch v := v.(type) {
+	case string:
+		return expandString(v)
+	case map[string]interface{}:
+		for key, elem := range v {
+			if v[key], err = expandValue(elem); err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+		}
+	case []interface{}:
+		for i, elem := range v {
+			if v[i], err = expandValue(elem); err != nil {
+				return nil, fmt.Errorf("%d: %v", i, err)
+			}
+		}
+	}
+	return v, nil
+}
+
+func expandString(s string) (string, error) {
+	var err error
+	s = envRE.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envRE.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("undefined environment variable: %s", name)
+		}
+		return v
+	})
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	return expandTemplate(s)
+}
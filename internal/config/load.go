@@ -7,37 +7,43 @@ package config
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"expvar"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"text/template"
 	"time"
+
+	"opentsp.org/internal/version"
 )
 
 var Debug *log.Logger
 
-var httpClient = &http.Client{
-	Transport: &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true, // TODO: make this configurable.
-		},
-	},
-	Timeout: 15 * time.Second,
-}
+// requestTimeout limits duration of server config lookups, excluding the
+// long-poll wait.
+const requestTimeout = 15 * time.Second
 
-// getInterval determines frequency of server config lookups.
+// getInterval determines frequency of server config lookups if the server
+// does not support long-poll requests. Otherwise, it limits the frequency.
 const getInterval = 60 * time.Second
 
+// getWait is the long-poll wait duration requested from the server.
+const getWait = 5 * time.Minute
+
+// maxRetryDelay limits the delay of lookup retries following errors.
+const maxRetryDelay = 60 * time.Second
+
 // maxBytes limits the size of server config response.
 const maxBytes = 1 << 20
 
@@ -56,10 +62,21 @@ var saved *loadPath
 type loadPath struct {
 	filePath   string
 	serverPath string
+	cache      *cache
 }
 
+// applied holds the server payload of the config in use.
+var applied []byte
+
 // Load loads the config struct with values stored in the given file. The file's
-// encoding is JSON.
+// encoding is JSON, unless its name ends in .yaml, .yml, or .toml, in which case
+// the encoding is YAML or TOML, respectively.
+//
+// The file is merged with the files found in its include directory, whose path
+// is the file path with extension replaced by ".d". The included files are
+// merged in lexical order: objects are merged, arrays are appended to, and other
+// values are replaced. String values may refer to environment variables using
+// ${NAME}, and may include the template action {{.Hostname}}.
 //
 // In addition, depending on environment details, a second file is loaded over
 // network. The remote file overrides those settings of the local file which have
@@ -76,6 +93,16 @@ type loadPath struct {
 //
 // The test for config=dynamic tag is not performed recursively.
 //
+// Fields holding credentials should be tagged with config=secret, for example
+// `config:"dynamic,secret"`. Their values are masked in history and in the
+// logged changes.
+//
+// The last server payload that produced a valid config is cached on disk. If
+// the server does not respond in time, Load uses the cached payload.
+//
+// The last few loaded configs are kept in history, see ServeHistory. The
+// changes introduced by each config are logged.
+//
 // At least one path must be provided. If a path is empty, it is ignored.
 func Load(config Struct, filePath, serverPath string) {
 	if saved != nil {
@@ -84,7 +111,11 @@ func Load(config Struct, filePath, serverPath string) {
 	if serverPath != "" && os.Getenv("CONTROL_HOST") == "" {
 		serverPath = ""
 	}
-	saved = &loadPath{filePath, serverPath}
+	saved = &loadPath{filePath, serverPath, nil}
+	if serverPath != "" {
+		saved.cache = newCache(serverPath)
+		loadHistory(historyPath(saved.cache))
+	}
 	load(config, filePath, serverPath)
 }
 
@@ -97,17 +128,16 @@ func Next(config Struct) {
 	if saved.serverPath == "" {
 		select {}
 	}
-	load(config, saved.filePath, saved.serverPath)
+	loadServer(config, saved.filePath, dial(saved.serverPath), false)
 }
 
 func load(config Struct, filePath, serverPath string) {
-	rateLimit := time.NewTicker(1 * time.Second)
-	defer rateLimit.Stop()
 	switch {
 	default:
 		log.Fatalf("config: no path defined")
 	case filePath != "" && serverPath == "":
 		config.Reset()
+		resetSources()
 		ok := decodeFileTry(config, filePath)
 		if !ok {
 			os.Exit(1)
@@ -115,38 +145,63 @@ func load(config Struct, filePath, serverPath string) {
 		if !isValid(config) {
 			os.Exit(1)
 		}
-	case filePath == "" && serverPath != "":
+		record(config, time.Now())
+	case serverPath != "":
 		client := dial(serverPath)
-		for ; ; <-rateLimit.C {
-			config.Reset()
-			ok := decodeServerTry(config, client)
-			if !ok {
-				continue
-			}
-			if !isValid(config) {
+		loadServer(config, filePath, client, true)
+		defaultClient = client
+	}
+}
+
+// loadServer loads the config struct with values stored in the given file
+// overridden by the next server payload. If fallback is true and the server
+// does not respond in time, the cached payload is used instead.
+func loadServer(config Struct, filePath string, client *client, fallback bool) {
+	rateLimit := time.NewTicker(1 * time.Second)
+	defer rateLimit.Stop()
+	var timeout <-chan time.Time
+	if fallback {
+		timeout = time.After(cacheTimeout)
+	}
+	for ; ; <-rateLimit.C {
+		var buf []byte
+		source := sourceServer
+		select {
+		case buf = <-client.nextUpdate:
+			// ok
+		case <-timeout:
+			timeout = nil
+			buf = saved.cache.Load()
+			if buf == nil {
 				continue
 			}
-			break
+			log.Printf("config: server unavailable, using cached config")
+			source = sourceCache
 		}
-		defaultClient = client
-	case filePath != "" && serverPath != "":
-		client := dial(serverPath)
-		for ; ; <-rateLimit.C {
-			config.Reset()
+		if bytes.Equal(buf, applied) {
+			continue
+		}
+		config.Reset()
+		resetSources()
+		if filePath != "" {
 			ok := decodeFileTry(config, filePath)
 			if !ok {
 				continue
 			}
-			ok = decodeServerTry(config, client)
-			if !ok {
-				continue
-			}
-			if !isValid(config) {
-				continue
-			}
-			break
 		}
-		defaultClient = client
+		ok := decodeServerTry(config, buf, source)
+		if !ok {
+			continue
+		}
+		if !isValid(config) {
+			continue
+		}
+		if source != sourceCache {
+			saved.cache.Store(buf)
+		}
+		applied = buf
+		record(config, time.Now())
+		break
 	}
 }
 
@@ -160,27 +215,97 @@ func decodeFileTry(config Struct, path string) (ok bool) {
 	return
 }
 
+// decodeFile decodes the given file merged with the files of its include
+// directory.
 func decodeFile(config Struct, path string) error {
-	file, err := os.Open(path)
+	paths, err := includePaths(path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
 		return err
 	}
-	defer file.Close()
-	buf, err := ioutil.ReadAll(file)
+	var merged interface{}
+	for _, path := range append([]string{path}, paths...) {
+		v, err := readFile(config, path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if v == nil {
+			continue
+		}
+		if _, err := expandValue(v); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		merged, err = mergeValue(merged, canonicalKeys(config, v), "")
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if merged == nil {
+		return nil
+	}
+	buf, err := json.Marshal(merged)
 	if err != nil {
 		return err
 	}
-	if err := unmarshal(config, buf); err != nil {
+	if err := json.Unmarshal(buf, config); err != nil {
 		return err
 	}
+	m, _ := merged.(map[string]interface{})
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	setSource(fieldNames(config, keys), sourceFile)
 	return nil
 }
 
-func decodeServerTry(config Struct, client *client) (ok bool) {
-	if err := decodeServer(config, client); err != nil {
+// readFile reads the given config file, and returns its decoded value. The
+// value is first decoded into a scratch config struct to report type errors
+// with line numbers. If the file does not exist, readFile returns nil.
+func readFile(config Struct, path string) (map[string]interface{}, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	scratch := reflect.New(reflect.Indirect(reflect.ValueOf(config)).Type()).Interface()
+	var doc *document
+	if parse := parsers[filepath.Ext(path)]; parse != nil {
+		doc, err = parse(buf)
+		if err != nil {
+			return nil, err
+		}
+		if err := doc.decode(scratch); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := unmarshal(scratch, buf); err != nil {
+			return nil, err
+		}
+		doc = newDocument()
+		dec := json.NewDecoder(bytes.NewReader(buf))
+		dec.UseNumber()
+		if err := dec.Decode(&doc.value); err != nil {
+			return nil, err
+		}
+	}
+	m, ok := doc.value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not an object")
+	}
+	return m, nil
+}
+
+// parsers maps file name extensions to parsers of non-JSON encodings.
+var parsers = map[string]func([]byte) (*document, error){
+	".yaml": parseYAML,
+	".yml":  parseYAML,
+	".toml": parseTOML,
+}
+
+func decodeServerTry(config Struct, buf []byte, source string) (ok bool) {
+	if err := decodeServer(config, buf, source); err != nil {
 		statLoadErrors.Add("type=Decode", 1)
 		log.Printf("config: server decode error: %v", err)
 		return
@@ -189,12 +314,12 @@ func decodeServerTry(config Struct, client *client) (ok bool) {
 	return
 }
 
-func decodeServer(config Struct, client *client) error {
-	buf := client.NextUpdate()
+func decodeServer(config Struct, buf []byte, source string) error {
 	filteredStruct := newFieldFilter(config)
 	if err := unmarshal(filteredStruct, buf); err != nil {
 		return err
 	}
+	setSource(filteredStruct.set, source)
 	return nil
 }
 
@@ -216,11 +341,16 @@ func isValid(config Struct) bool {
 }
 
 func addLineNum(err error, buf []byte) error {
-	syntax, ok := err.(*json.SyntaxError)
-	if !ok {
+	var offset int64
+	switch err := err.(type) {
+	default:
 		return err
+	case *json.SyntaxError:
+		offset = err.Offset
+	case *json.UnmarshalTypeError:
+		offset = err.Offset
 	}
-	start := bytes.LastIndex(buf[:syntax.Offset], []byte("\n")) + 1
+	start := bytes.LastIndex(buf[:offset], []byte("\n")) + 1
 	num := bytes.Count(buf[:start], []byte("\n")) + 1
 	return fmt.Errorf("line %d: %v", num, err)
 }
@@ -230,59 +360,107 @@ var defaultClient *client
 type client struct {
 	addr, path string
 	nextUpdate chan []byte
+	etag       string // entity tag of the last payload
+	http       *http.Client
+	strict     bool // disables plain HTTP fallback
 }
 
 func dial(path string) *client {
 	if defaultClient != nil {
 		return defaultClient
 	}
+	env, err := getTLSEnv()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	httpClient, err := newHTTPClient(env)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
 	c := &client{
 		addr:       os.Getenv("CONTROL_HOST"),
 		path:       path,
 		nextUpdate: make(chan []byte),
+		http:       httpClient,
+		strict:     env.Strict,
 	}
 	go c.mainloop()
 	return c
 }
 
-// NexUpdate blocks until a payload arrives that differs from the previous one.
-func (c *client) NextUpdate() []byte {
-	return <-c.nextUpdate
-}
-
+// mainloop looks up the server config. If the server supports conditional
+// requests, the lookups are long-poll requests that return as soon as the
+// config changes. Otherwise, the server is polled every getInterval.
+//
+// Lookups are never started more often than every getInterval, so that a
+// server that ignores the long-poll wait is not flooded with requests.
 func (c *client) mainloop() {
-	var last []byte
-	tick := time.Tick(getInterval)
-	for ; ; <-tick {
+	var (
+		last  []byte
+		retry = newRetryDelay()
+	)
+	for {
+		start := time.Now()
 		buf, ok := c.getTry()
 		if !ok {
+			time.Sleep(retry())
 			continue
 		}
-		if last != nil && bytes.Equal(buf, last) {
-			continue
+		confirm(time.Now())
+		retry = newRetryDelay()
+		if buf != nil && !bytes.Equal(buf, last) {
+			c.nextUpdate <- buf
+			last = buf
 		}
-		c.nextUpdate <- buf
-		last = buf
+		time.Sleep(getInterval - time.Since(start))
 	}
 }
 
+// newRetryDelay returns a function that returns exponentially increasing
+// retry delays, with jitter to spread out the load on the server.
+func newRetryDelay() func() time.Duration {
+	delay := 1 * time.Second
+	return func() time.Duration {
+		d := time.Duration(float64(delay) * (0.5 + rand.Float64()))
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		return d
+	}
+}
+
+// get returns the server config, or nil if it is unchanged since the last
+// call.
 func (c *client) get() (buf []byte, err error) {
 	resp, err := c.getPlainOrTLS()
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
 	r := io.LimitReader(resp.Body, maxBytes)
-	return ioutil.ReadAll(r)
+	buf, err = ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c.etag = resp.Header.Get("ETag")
+	return buf, nil
 }
 
 func (c *client) requestURL(scheme string) string {
 	i := strings.Index(c.path, "?")
+	query := expand(c.path[i+1:])
+	if c.etag != "" {
+		query += "&wait=" + getWait.String()
+	}
 	URL := url.URL{
 		Scheme:   scheme,
 		Host:     c.addr,
 		Path:     path.Join("/control/v1", c.path[:i]),
-		RawQuery: expand(c.path[i+1:]),
+		RawQuery: query,
 	}
 	return URL.String()
 }
@@ -290,7 +468,13 @@ func (c *client) requestURL(scheme string) string {
 func (c *client) getPlainOrTLS() (*http.Response, error) {
 	requests := []string{
 		c.requestURL("https"),
-		c.requestURL("http"),
+	}
+	if !c.strict {
+		requests = append(requests, c.requestURL("http"))
+	}
+	timeout := requestTimeout
+	if c.etag != "" {
+		timeout += getWait
 	}
 	var (
 		ok     *http.Response
@@ -300,12 +484,13 @@ func (c *client) getPlainOrTLS() (*http.Response, error) {
 		if Debug != nil {
 			Debug.Printf("get %s", URL)
 		}
-		resp, err := httpClient.Get(URL)
+		resp, err := c.do(URL, timeout)
 		if err != nil {
 			errors = append(errors, err)
 			continue
 		}
-		if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+			resp.Body.Close()
 			err := fmt.Errorf("Get %s: got status code %d (%q)",
 				URL, resp.StatusCode, resp.Status)
 			errors = append(errors, err)
@@ -320,21 +505,77 @@ func (c *client) getPlainOrTLS() (*http.Response, error) {
 	return ok, nil
 }
 
+// do sends a GET request, conditional on the entity tag of the last payload.
+func (c *client) do(URL string, timeout time.Duration) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if c.etag != "" {
+		req.Header.Set("If-None-Match", c.etag)
+	}
+	req.Header.Set("User-Agent", c.userAgent())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{resp.Body, cancel}
+	return resp, nil
+}
+
+// userAgent identifies the program and its version, for example
+// "tsp-forwarder/1.2.0 (git=0123abc)".
+func (c *client) userAgent() string {
+	program := c.path
+	if i := strings.Index(program, "?"); i != -1 {
+		program = program[:i]
+	}
+	return fmt.Sprintf("%s/%s (git=%s)", path.Base(program), version.Version, version.GitCommit)
+}
+
+// cancelBody releases the request context once the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
 func expand(s string) string {
+	s, err := expandTemplate(s)
+	if err != nil {
+		log.Panic(err)
+	}
+	return s
+}
+
+// expandTemplate executes the template actions in s. The template data
+// holds the local host name.
+func expandTemplate(s string) (string, error) {
 	var data struct {
 		Hostname string
 	}
 	var err error
 	data.Hostname, err = os.Hostname()
 	if err != nil {
-		log.Panic(err)
+		return "", err
+	}
+	t, err := template.New("config").Parse(s)
+	if err != nil {
+		return "", err
 	}
-	t := template.Must(template.New("query").Parse(s))
 	var buf bytes.Buffer
 	if err := t.Execute(&buf, data); err != nil {
-		log.Panic(err)
+		return "", err
 	}
-	return buf.String()
+	return buf.String(), nil
 }
 
 func (c *client) getTry() (buf []byte, ok bool) {
@@ -352,6 +593,7 @@ func (c *client) getTry() (buf []byte, ok bool) {
 type fieldFilter struct {
 	v     interface{}
 	field []string
+	set   []string // fields present in the input
 }
 
 func newFieldFilter(v interface{}) *fieldFilter {
@@ -362,7 +604,7 @@ func newFieldFilter(v interface{}) *fieldFilter {
 	filter := &fieldFilter{v: v}
 	for i := 0; i < typ.NumField(); i++ {
 		f := typ.Field(i)
-		if f.Tag.Get("config") == "dynamic" {
+		if hasTagOption(f, "dynamic") {
 			filter.field = append(filter.field, f.Name)
 		}
 	}
@@ -381,8 +623,9 @@ func (filter *fieldFilter) UnmarshalJSON(buf []byte) error {
 		}
 		buf := []byte(fmt.Sprintf("{%q: %s}", f, raw))
 		if err := json.Unmarshal(buf, filter.v); err != nil {
-			return err
+			return fmt.Errorf("%s: %v", f, err)
 		}
+		filter.set = append(filter.set, f)
 	}
 	return nil
 }
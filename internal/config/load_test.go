@@ -0,0 +1,285 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testConfig struct {
+	Foo   string
+	Bar   []int `config:"dynamic"`
+	Baz   map[string]string
+	Quux  int `config:"dynamic"`
+	Other int `config:"dynamic"`
+}
+
+func (c *testConfig) Reset()          { *c = testConfig{} }
+func (c *testConfig) Validate() error { return nil }
+
+var testDecodeFile = []struct {
+	name string
+	in   string
+	err  string
+}{
+	{"a.json", `{"foo": "x", "Bar": [1, 2], "Baz": {"a": "b"}}`, ""},
+	{"a.yaml", "foo: x\nBar: [1, 2]\nBaz:\n  a: b\n", ""},
+	{"a.yml", "foo: x\nBar:\n- 1\n- 2\nBaz: {a: b}\n", ""},
+	{"a.toml", "foo = 'x'\nBar = [1, 2]\n[Baz]\na = 'b'\n", ""},
+	{"b.json", "{\n\"Bar\": [\n1,\n\"x\"\n]\n}", "line 4: "},
+	{"b.yaml", "Foo: x\nBar:\n- 1\n- x\n", "line 4: "},
+	{"b.toml", "Foo = 'x'\nBar = [\n1,\n'x',\n]\n", "line 2: "},
+	{"c.yaml", "Foo: x\nBaz:\n  a: [1]\n", "line 3: "},
+	{"c.toml", "Foo = 'x'\n[Baz]\na = 'b'\n[Baz.c]\nd = 1\n", "line 4: "},
+}
+
+func TestDecodeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i, tt := range testDecodeFile {
+		path := filepath.Join(dir, tt.name)
+		if err := ioutil.WriteFile(path, []byte(tt.in), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got := new(testConfig)
+		resetSources()
+		err := decodeFile(got, path)
+		switch {
+		case err == nil && tt.err != "":
+			t.Errorf("#%d. unexpected success, want error: %v", i, tt.err)
+			continue
+		case err != nil && tt.err == "":
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		case err != nil && !strings.HasPrefix(err.Error(), path+": "+tt.err):
+			t.Errorf("#%d. invalid error, got: %s, want: %s", i, err, tt.err)
+			continue
+		case err != nil:
+			continue
+		}
+		if got.Foo != "x" || len(got.Bar) != 2 || got.Bar[1] != 2 || got.Baz["a"] != "b" {
+			t.Errorf("#%d. invalid value: %+v", i, got)
+		}
+	}
+}
+
+var testInclude = []struct {
+	files map[string]string
+	want  testConfig
+	err   string
+}{
+	{
+		files: map[string]string{
+			"config":              `{"Foo": "x", "Bar": [1], "Baz": {"a": "b"}}`,
+			"config.d/20-b.yaml":  "bar: [3]\nBaz:\n  c: ${TEST_INCLUDE}\n",
+			"config.d/10-a.toml":  "Bar = [2]\nQuux = 1\n[Baz]\na = 'B'\n",
+			"config.d/30-c.json":  `{"Foo": "{{len .Hostname | printf \"%T\"}}"}`,
+			"config.d/40-d.json~": `{"Foo": "ignored"}`,
+		},
+		want: testConfig{
+			Foo:  "int",
+			Bar:  []int{1, 2, 3},
+			Baz:  map[string]string{"a": "B", "c": "env"},
+			Quux: 1,
+		},
+	},
+	{
+		files: map[string]string{
+			"config.d/a.json": `{"Foo": "x"}`,
+		},
+		want: testConfig{Foo: "x"},
+	},
+	{
+		files: map[string]string{
+			"config":          `{"Bar": [1]}`,
+			"config.d/a.json": `{"Bar": {"x": 1}}`,
+		},
+		err: "config.d/a.json: line 1: ",
+	},
+	{
+		files: map[string]string{
+			"config": `{"Foo": "${TEST_NO_SUCH_VARIABLE}"}`,
+		},
+		err: "config: Foo: undefined environment variable: TEST_NO_SUCH_VARIABLE",
+	},
+}
+
+func TestInclude(t *testing.T) {
+	os.Setenv("TEST_INCLUDE", "env")
+	defer os.Unsetenv("TEST_INCLUDE")
+	for i, tt := range testInclude {
+		dir, err := ioutil.TempDir("", "configtest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		os.Mkdir(filepath.Join(dir, "config.d"), 0755)
+		for name, content := range tt.files {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got := new(testConfig)
+		err = decodeFile(got, filepath.Join(dir, "config"))
+		switch {
+		case err == nil && tt.err != "":
+			t.Errorf("#%d. unexpected success, want error: %v", i, tt.err)
+		case err != nil && tt.err == "":
+			t.Errorf("#%d. unexpected error: %v", i, err)
+		case err != nil && !strings.HasPrefix(err.Error(), filepath.Join(dir, tt.err)):
+			t.Errorf("#%d. invalid error, got: %s, want: %s", i, err, tt.err)
+		case err == nil && !reflect.DeepEqual(*got, tt.want):
+			t.Errorf("#%d. invalid value\ngot:  %+v\nwant: %+v", i, *got, tt.want)
+		}
+	}
+}
+
+func TestDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.yaml")
+	if err := ioutil.WriteFile(path, []byte("foo: x\nbar: [1]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := new(testConfig)
+	resetSources()
+	if err := decodeFile(cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := decodeServer(cfg, []byte(`{"Bar": [2], "Foo": "y", "Quux": 1}`), sourceServer); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	Dump(&buf, cfg)
+	var got testConfig
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.Bytes())
+	}
+	if got.Foo != "x" {
+		t.Errorf("invalid value: %+v", got)
+	}
+	buf.Reset()
+	DumpSources(&buf, cfg)
+	out := buf.String()
+	for _, want := range []string{
+		"# Foo:   file\n",
+		"# Bar:   controller\n",
+		"# Baz:   default\n",
+		"# Quux:  controller\n",
+		"# Other: default\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestClientLongPoll(t *testing.T) {
+	requests := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests <- req
+		w.Header().Set("ETag", `"1"`)
+		if req.Header.Get("If-None-Match") == `"1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`{"Filter": []}`))
+	}))
+	defer server.Close()
+	c := &client{
+		addr: strings.TrimPrefix(server.URL, "http://"),
+		path: "tsp-forwarder?host=foo",
+		http: http.DefaultClient,
+	}
+	buf, ok := c.getTry()
+	if !ok || string(buf) != `{"Filter": []}` {
+		t.Fatalf("unexpected response: %q", buf)
+	}
+	req := <-requests
+	if got := req.URL.Query().Get("wait"); got != "" {
+		t.Errorf("unexpected wait in initial request: %q", got)
+	}
+	buf, ok = c.getTry()
+	if !ok || buf != nil {
+		t.Fatalf("unexpected response: %q", buf)
+	}
+	req = <-requests
+	if got := req.URL.Query().Get("wait"); got != getWait.String() {
+		t.Errorf("got wait %q, want %q", got, getWait)
+	}
+	if got := req.Header.Get("If-None-Match"); got != `"1"` {
+		t.Errorf("got If-None-Match %q", got)
+	}
+}
+
+func TestClientStrict(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	c := &client{
+		addr:   strings.TrimPrefix(server.URL, "http://"),
+		path:   "tsp-forwarder?host=foo",
+		http:   http.DefaultClient,
+		strict: true,
+	}
+	if _, err := c.get(); err == nil {
+		t.Errorf("unexpected success, plain HTTP used in strict mode")
+	}
+}
+
+func TestHTTPClientVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "configtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	pemBlock := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, pemBlock, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range []struct {
+		env *tlsEnv
+		ok  bool
+	}{
+		{&tlsEnv{}, true},
+		{&tlsEnv{Strict: true}, false}, // verified against the system roots
+		{&tlsEnv{CA: ca}, true},
+		{&tlsEnv{CA: ca, Strict: true}, true},
+	} {
+		client, err := newHTTPClient(tt.env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("#%d. got success %v, want %v (err=%v)", i, ok, tt.ok, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// secretMask replaces the values of secret fields.
+const secretMask = "******"
+
+var rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+
+// hasTagOption reports if the config tag of the given field includes the
+// given option, for example `config:"dynamic,secret"`.
+func hasTagOption(f reflect.StructField, option string) bool {
+	for _, s := range strings.Split(f.Tag.Get("config"), ",") {
+		if s == option {
+			return true
+		}
+	}
+	return false
+}
+
+// redact returns a copy of the given config in which the values of fields
+// tagged with config=secret are masked. Unlike the test for config=dynamic,
+// the test is performed recursively.
+func redact(config interface{}) interface{} {
+	return redactValue(reflect.ValueOf(config)).Interface()
+}
+
+func redactValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(redactValue(v.Elem()))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(redactValue(v.Elem()))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" {
+				continue // unexported
+			}
+			if hasTagOption(f, "secret") {
+				c.Field(i).Set(maskValue(v.Field(i)))
+			} else {
+				c.Field(i).Set(redactValue(v.Field(i)))
+			}
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() || v.Type().Elem().Kind() == reflect.Uint8 {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(redactValue(v.Index(i)))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(redactValue(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, key := range v.MapKeys() {
+			c.SetMapIndex(key, redactValue(v.MapIndex(key)))
+		}
+		return c
+	}
+	return v
+}
+
+// maskValue masks the value of a secret field. Strings and raw JSON values
+// are replaced by secretMask, map values are masked individually so that the
+// keys remain visible, and other values are zeroed. Zero values are kept, so
+// that it remains visible whether the secret is set.
+func maskValue(v reflect.Value) reflect.Value {
+	if v.IsZero() {
+		return v
+	}
+	switch {
+	case v.Type() == rawMessageType:
+		return reflect.ValueOf(json.RawMessage(strconv.Quote(secretMask)))
+	case v.Kind() == reflect.String:
+		return reflect.ValueOf(secretMask).Convert(v.Type())
+	case v.Kind() == reflect.Map:
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, key := range v.MapKeys() {
+			c.SetMapIndex(key, maskValue(v.MapIndex(key)))
+		}
+		return c
+	case v.Kind() == reflect.Ptr:
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(maskValue(v.Elem()))
+		return c
+	}
+	return reflect.Zero(v.Type())
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Config value sources.
+const (
+	sourceFile    = "file"
+	sourceServer  = "controller"
+	sourceCache   = "controller (cached)"
+	sourceDefault = "default"
+)
+
+var sources = struct {
+	sync.Mutex
+	m map[string]string // field name => source
+}{
+	m: make(map[string]string),
+}
+
+func resetSources() {
+	sources.Lock()
+	sources.m = make(map[string]string)
+	sources.Unlock()
+}
+
+func setSource(fields []string, source string) {
+	sources.Lock()
+	for _, f := range fields {
+		sources.m[f] = source
+	}
+	sources.Unlock()
+}
+
+func getSource(field string) string {
+	sources.Lock()
+	defer sources.Unlock()
+	if s, ok := sources.m[field]; ok {
+		return s
+	}
+	return sourceDefault
+}
+
+// fieldNames returns names of the struct fields matched by the given keys.
+// As in encoding/json, the match is case-insensitive.
+func fieldNames(config interface{}, keys []string) []string {
+	typ := reflect.Indirect(reflect.ValueOf(config)).Type()
+	var names []string
+	for _, key := range keys {
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if f.PkgPath == "" && strings.EqualFold(f.Name, key) {
+				names = append(names, f.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// jsonKeys returns the top-level keys of the given JSON object.
+func jsonKeys(buf []byte) []string {
+	var m map[string]json.RawMessage
+	json.Unmarshal(buf, &m)
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Dump writes the config struct in indented JSON encoding.
+func Dump(w io.Writer, config Struct) {
+	buf, _ := json.MarshalIndent(config, "", "\t")
+	fmt.Fprintln(w, string(buf))
+}
+
+// DumpSources writes the source of each field of the config struct: the file,
+// the controller, or the default value.
+func DumpSources(w io.Writer, config Struct) {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	typ := reflect.Indirect(reflect.ValueOf(config)).Type()
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		fmt.Fprintf(tw, "# %s:\t%s\n", f.Name, getSource(f.Name))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// The environment variables below control TLS settings of server config
+// lookups:
+//
+//	CONTROL_CA      path to a PEM bundle of certificate authorities trusted
+//	                to sign the server certificate; if unset, the server
+//	                certificate is not verified, except in strict mode
+//	CONTROL_CERT    path to a PEM client certificate, enables mutual TLS
+//	CONTROL_KEY     path to the PEM private key of the client certificate
+//	CONTROL_STRICT  if true, the plain HTTP fallback is disabled, and the
+//	                server certificate is always verified, using the
+//	                system roots if CONTROL_CA is unset
+type tlsEnv struct {
+	CA, Cert, Key string
+	Strict        bool
+}
+
+func getTLSEnv() (*tlsEnv, error) {
+	env := &tlsEnv{
+		CA:   os.Getenv("CONTROL_CA"),
+		Cert: os.Getenv("CONTROL_CERT"),
+		Key:  os.Getenv("CONTROL_KEY"),
+	}
+	if s := os.Getenv("CONTROL_STRICT"); s != "" {
+		strict, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONTROL_STRICT: %q", s)
+		}
+		env.Strict = strict
+	}
+	if (env.Cert == "") != (env.Key == "") {
+		return nil, fmt.Errorf("CONTROL_CERT and CONTROL_KEY must be set together")
+	}
+	return env, nil
+}
+
+// newHTTPClient returns a client for server config lookups.
+func newHTTPClient(env *tlsEnv) (*http.Client, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: env.CA == "" && !env.Strict,
+	}
+	if env.CA != "" {
+		pool, err := loadCertPool(env.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if env.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(env.Cert, env.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: config,
+		},
+	}
+	return client, nil
+}
+
+// loadCertPool loads a PEM bundle of certificates.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(buf) {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	"opentsp.org/internal/github.com/pelletier/go-toml"
+)
+
+// parseTOML parses a TOML document. Values that have no JSON equivalent,
+// such as infinite numbers, are not supported; dates and times are converted
+// to strings. The parser does not record the position of array elements and
+// inline tables, so these are reported at the line of the enclosing key.
+func parseTOML(buf []byte) (*document, error) {
+	tree, err := toml.LoadBytes(buf)
+	if err != nil {
+		return nil, tomlError(err)
+	}
+	doc := newDocument()
+	v, err := doc.tomlTree(tree, "", 1)
+	if err != nil {
+		return nil, err
+	}
+	doc.value = v
+	return doc, nil
+}
+
+// tomlTree converts the TOML table defined on the given line to the
+// equivalent of a value decoded by encoding/json, recording the source lines.
+func (d *document) tomlTree(tree *toml.Tree, path string, line int) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	for _, key := range tree.Keys() {
+		keyPath := joinPath(path, key)
+		line := line
+		if pos := tree.GetPositionPath([]string{key}); !pos.Invalid() {
+			line = pos.Line
+		}
+		d.lines[keyPath] = line
+		v, err := d.tomlValue(tree.GetPath([]string{key}), keyPath, line)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// tomlValue converts a TOML value defined on the given line. Numbers are
+// returned as json.Number.
+func (d *document) tomlValue(v interface{}, path string, line int) (interface{}, error) {
+	switch v := v.(type) {
+	case *toml.Tree:
+		return d.tomlTree(v, path, line)
+	case []*toml.Tree:
+		s := make([]interface{}, 0, len(v))
+		for i, tree := range v {
+			elemPath := joinPath(path, strconv.Itoa(i))
+			elemLine := line
+			if pos := tree.Position(); !pos.Invalid() {
+				elemLine = pos.Line
+			}
+			d.lines[elemPath] = elemLine
+			m, err := d.tomlTree(tree, elemPath, elemLine)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, m)
+		}
+		return s, nil
+	case []interface{}:
+		s := make([]interface{}, 0, len(v))
+		for i, elem := range v {
+			elemPath := joinPath(path, strconv.Itoa(i))
+			d.lines[elemPath] = line
+			x, err := d.tomlValue(elem, elemPath, line)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, x)
+		}
+		return s, nil
+	case bool, string:
+		return v, nil
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	case uint64:
+		return json.Number(strconv.FormatUint(v, 10)), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			break
+		}
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64)), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case toml.LocalDate, toml.LocalTime, toml.LocalDateTime:
+		return fmt.Sprint(v), nil
+	}
+	return nil, fmt.Errorf("line %d: toml: unsupported value: %v", line, v)
+}
+
+var tomlErrorRE = regexp.MustCompile(`^\(([0-9]+), [0-9]+\): `)
+
+// tomlError rewrites a parser error to start with the line number, as do
+// other errors in config files.
+func tomlError(err error) error {
+	msg := err.Error()
+	m := tomlErrorRE.FindStringSubmatch(msg)
+	if m == nil {
+		return fmt.Errorf("toml: %s", msg)
+	}
+	return fmt.Errorf("line %s: toml: %s", m[1], msg[len(m[0]):])
+}
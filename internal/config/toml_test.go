@@ -0,0 +1,116 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+var testParseTOML = []struct {
+	in   string
+	want string
+	err  string
+}{
+	{
+		in:   "",
+		want: `{}`,
+	},
+	{
+		in:   "# comment\nfoo = \"bar\" # comment\n'baz' = 'C:\\x'\n",
+		want: `{"baz":"C:\\x","foo":"bar"}`,
+	},
+	{
+		in:   "a = 1\nb = -2.5\nc = 1_000\nd = true\ne = \"a\\tb\"\n",
+		want: `{"a":1,"b":-2.5,"c":1000,"d":true,"e":"a\tb"}`,
+	},
+	{
+		in: `
+LogPath = "/var/log/tsp/forwarder.log"
+
+[[Filter]]
+Match = [
+	"foo.bar", # comment
+	"",
+	"host=web.*",
+]
+Set = {type = "x"}
+
+[[Filter]]
+Block = true
+
+[Relay.tsd]
+Host = "tsd.example.com:4242"
+`,
+		want: `{"Filter":[{"Match":["foo.bar","","host=web.*"],"Set":{"type":"x"}},{"Block":true}],"LogPath":"/var/log/tsp/forwarder.log","Relay":{"tsd":{"Host":"tsd.example.com:4242"}}}`,
+	},
+	{
+		in:   "a.b.c = 1\n[x]\ny.z = 2\n",
+		want: `{"a":{"b":{"c":1}},"x":{"y":{"z":2}}}`,
+	},
+	{
+		in:   "a = 0x1F\nb = 5e-1\nc = \"\"\"\nx\ny\"\"\"\n",
+		want: `{"a":31,"b":0.5,"c":"x\ny"}`,
+	},
+	{
+		in:   "t = 1979-05-27T07:32:00Z\n",
+		want: `{"t":"1979-05-27T07:32:00Z"}`,
+	},
+	{
+		in:  "b = .5\n",
+		err: "line 1: toml: ",
+	},
+	{
+		in:  "a = 1\na = 2\n",
+		err: "line 2: toml: ",
+	},
+	{
+		in:  "[a]\n[a]\n",
+		err: "line 2: toml: ",
+	},
+	{
+		in:  "a = 1 2\n",
+		err: "line 1: toml: ",
+	},
+	{
+		in:  "a = [\n1,\n2\n",
+		err: "line 4: toml: ",
+	},
+	{
+		in:  "\n\nd = 1979-05-27 x\n",
+		err: "line 3: toml: ",
+	},
+	{
+		in:  "a = 1\n[[a]]\n",
+		err: "line 2: toml: ",
+	},
+	{
+		in:  "a = inf\n",
+		err: "line 1: toml: unsupported value: ",
+	},
+}
+
+func TestParseTOML(t *testing.T) {
+	for i, tt := range testParseTOML {
+		doc, err := parseTOML([]byte(tt.in))
+		switch {
+		case err == nil && tt.err != "":
+			t.Errorf("#%d. unexpected success, want error: %v", i, tt.err)
+			continue
+		case err != nil && tt.err == "":
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		case err != nil && !strings.HasPrefix(err.Error(), tt.err):
+			t.Errorf("#%d. invalid error, got: %s, want: %s", i, err, tt.err)
+			continue
+		case err != nil:
+			continue
+		}
+		got, _ := doc.encode()
+		if string(got) != tt.want {
+			t.Errorf("#%d. invalid value\ngot:  %s\nwant: %s", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	"opentsp.org/internal/gopkg.in/yaml.v3"
+)
+
+// parseYAML parses a YAML document. Multiple documents are not supported,
+// and neither are values that have no JSON equivalent, such as binary data
+// or infinite numbers.
+func parseYAML(buf []byte) (*document, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	var root yaml.Node
+	if err := dec.Decode(&root); err != nil {
+		if err == io.EOF {
+			doc := newDocument()
+			doc.value = map[string]interface{}{}
+			return doc, nil
+		}
+		return nil, yamlError(err)
+	}
+	var next yaml.Node
+	if err := dec.Decode(&next); err != io.EOF {
+		if err != nil {
+			return nil, yamlError(err)
+		}
+		return nil, fmt.Errorf("line %d: yaml: multiple documents not supported", next.Line)
+	}
+	doc := newDocument()
+	v, err := doc.yamlValue(&root, "")
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		v = map[string]interface{}{}
+	}
+	doc.value = v
+	return doc, nil
+}
+
+// yamlValue converts the YAML node at the given path to the equivalent of a
+// value decoded by encoding/json, recording the source lines.
+func (d *document) yamlValue(node *yaml.Node, path string) (interface{}, error) {
+	d.lines[path] = node.Line
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return d.yamlValue(node.Content[0], path)
+	case yaml.AliasNode:
+		return d.yamlValue(node.Alias, path)
+	case yaml.MappingNode:
+		m := make(map[string]interface{})
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: yaml: unsupported mapping key", key.Line)
+			}
+			if _, ok := m[key.Value]; ok {
+				return nil, fmt.Errorf("line %d: yaml: duplicate key: %s", key.Line, key.Value)
+			}
+			v, err := d.yamlValue(value, joinPath(path, key.Value))
+			if err != nil {
+				return nil, err
+			}
+			m[key.Value] = v
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		s := make([]interface{}, 0, len(node.Content))
+		for i, elem := range node.Content {
+			v, err := d.yamlValue(elem, joinPath(path, strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, v)
+		}
+		return s, nil
+	case yaml.ScalarNode:
+		if node.ShortTag() == "!!binary" {
+			return nil, fmt.Errorf("line %d: yaml: unsupported value: %s", node.Line, node.Value)
+		}
+		var v interface{}
+		if err := node.Decode(&v); err != nil {
+			return nil, fmt.Errorf("line %d: %v", node.Line, err)
+		}
+		return yamlScalar(node, v)
+	}
+	return nil, fmt.Errorf("line %d: yaml: unsupported node", node.Line)
+}
+
+// yamlScalar converts a decoded YAML scalar. Numbers are returned as
+// json.Number.
+func yamlScalar(node *yaml.Node, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, bool, string:
+		return v, nil
+	case int:
+		return json.Number(strconv.Itoa(v)), nil
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	case uint64:
+		return json.Number(strconv.FormatUint(v, 10)), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			break
+		}
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64)), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	}
+	return nil, fmt.Errorf("line %d: yaml: unsupported value: %s", node.Line, node.Value)
+}
+
+var yamlErrorRE = regexp.MustCompile(`^yaml: (line ([0-9]+): )?`)
+
+// yamlError rewrites a parser error to start with the line number, as do
+// other errors in config files. The parser omits the number of the first
+// line.
+func yamlError(err error) error {
+	msg := err.Error()
+	m := yamlErrorRE.FindStringSubmatch(msg)
+	if m == nil {
+		return err
+	}
+	line := m[2]
+	if line == "" {
+		line = "1"
+	}
+	return fmt.Errorf("line %s: yaml: %s", line, msg[len(m[0]):])
+}
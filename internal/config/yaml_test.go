@@ -0,0 +1,116 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+var testParseYAML = []struct {
+	in   string
+	want string
+	err  string
+}{
+	{
+		in:   "",
+		want: `{}`,
+	},
+	{
+		in:   "# comment\nfoo: bar # comment\nbaz: 'qu''x'\n",
+		want: `{"baz":"qu'x","foo":"bar"}`,
+	},
+	{
+		in:   "---\na: 1\nb: -2.5\nc: 1_000\nd: true\ne: ~\nf: 10s\ng: \"x#y\"\n",
+		want: `{"a":1,"b":-2.5,"c":1000,"d":true,"e":null,"f":"10s","g":"x#y"}`,
+	},
+	{
+		in: `
+Filter:
+  - Match: [foo.bar, "", host=web.*]
+    Set: {type: x}
+  - Block: true
+Relay:
+  tsd:
+    Host: tsd.example.com:4242
+`,
+		want: `{"Filter":[{"Match":["foo.bar","","host=web.*"],"Set":{"type":"x"}},{"Block":true}],"Relay":{"tsd":{"Host":"tsd.example.com:4242"}}}`,
+	},
+	{
+		in:   "list:\n- a\n- b\nnext: c\n",
+		want: `{"list":["a","b"],"next":"c"}`,
+	},
+	{
+		in:   "list:\n  -\n  - - a\n    - b\n",
+		want: `{"list":[null,["a","b"]]}`,
+	},
+	{
+		in:   "flow: [a,\n  b,\n  c]\nx: y\n",
+		want: `{"flow":["a","b","c"],"x":"y"}`,
+	},
+	{
+		in:   "a: 0x1F\nb: .5\nc: 1e3\nd: 2015-01-02\ne: 'yes'\nf: yes\n",
+		want: `{"a":31,"b":0.5,"c":1000,"d":"2015-01-02T00:00:00Z","e":"yes","f":"yes"}`,
+	},
+	{
+		in:   "a: &x\n  b: 1\nc: *x\nd: |\n  x\n  y\n",
+		want: `{"a":{"b":1},"c":{"b":1},"d":"x\ny\n"}`,
+	},
+	{
+		in:  "a: 1\na: 2\n",
+		err: "line 2: yaml: ",
+	},
+	{
+		in:  "Key: a: b\n",
+		err: "line 1: yaml: ",
+	},
+	{
+		in:  "a:\n  b: 1\n   c: 2\n",
+		err: "line 3: yaml: ",
+	},
+	{
+		in:  "a: 1\n\tb: 2\n",
+		err: "line 2: yaml: ",
+	},
+	{
+		in:  "a: [1, 2\nb: 3\n",
+		err: "line 1: yaml: ",
+	},
+	{
+		in:  "a: .inf\n",
+		err: "line 1: yaml: unsupported value: .inf",
+	},
+	{
+		in:  "a: !!binary aGVsbG8=\n",
+		err: "line 1: yaml: unsupported value: ",
+	},
+	{
+		in:  "a: 1\n---\nb: 2\n",
+		err: "line 2: yaml: multiple documents not supported",
+	},
+}
+
+func TestParseYAML(t *testing.T) {
+	for i, tt := range testParseYAML {
+		doc, err := parseYAML([]byte(tt.in))
+		switch {
+		case err == nil && tt.err != "":
+			t.Errorf("#%d. unexpected success, want error: %v", i, tt.err)
+			continue
+		case err != nil && tt.err == "":
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		case err != nil && !strings.HasPrefix(err.Error(), tt.err):
+			t.Errorf("#%d. invalid error, got: %s, want: %s", i, err, tt.err)
+			continue
+		case err != nil:
+			continue
+		}
+		got, _ := doc.encode()
+		if string(got) != tt.want {
+			t.Errorf("#%d. invalid value\ngot:  %s\nwant: %s", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package hoststat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"opentsp.org/internal/tsdb"
+)
+
+// cpuTypes names the columns of the cpu lines of /proc/stat.
+var cpuTypes = []string{
+	"user", "nice", "system", "idle", "iowait",
+	"irq", "softirq", "steal", "guest", "guest_nice",
+}
+
+// statCounters maps single-value lines of /proc/stat to metric names.
+var statCounters = map[string]string{
+	"intr":          "proc.stat.intr",
+	"ctxt":          "proc.stat.ctxt",
+	"processes":     "proc.stat.processes",
+	"procs_running": "proc.stat.procs_running",
+	"procs_blocked": "proc.stat.procs_blocked",
+}
+
+func collectCPU(root string, t time.Time, emit func(*tsdb.Point)) error {
+	f, err := openFile(root, "stat")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := parseStat(f, t, emit); err != nil {
+		return fmt.Errorf("stat: %v", err)
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(root, "loadavg"))
+	if err != nil {
+		return err
+	}
+	if err := parseLoadavg(string(buf), t, emit); err != nil {
+		return fmt.Errorf("loadavg: %v", err)
+	}
+	return nil
+}
+
+// parseStat parses /proc/stat.
+func parseStat(r io.Reader, t time.Time, emit func(*tsdb.Point)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20) // the intr line may be long
+	for scanner.Scan() {
+		field := strings.Fields(scanner.Text())
+		if len(field) < 2 {
+			continue
+		}
+		key := field[0]
+		switch {
+		case key == "cpu":
+			if err := emitCPU(t, emit, "proc.stat.cpu", field[1:]); err != nil {
+				return err
+			}
+		case strings.HasPrefix(key, "cpu"):
+			cpu := strings.TrimPrefix(key, "cpu")
+			if err := emitCPU(t, emit, "proc.stat.cpu.percpu", field[1:], "cpu", cpu); err != nil {
+				return err
+			}
+		case statCounters[key] != "":
+			p, err := newPoint(t, field[1], statCounters[key])
+			if err != nil {
+				return err
+			}
+			emit(p)
+		}
+	}
+	return scanner.Err()
+}
+
+func emitCPU(t time.Time, emit func(*tsdb.Point), metric string, value []string, tags ...string) error {
+	for i, v := range value {
+		if i == len(cpuTypes) {
+			break
+		}
+		keyval := append([]string{"type", cpuTypes[i]}, tags...)
+		p, err := newPoint(t, v, metric, keyval...)
+		if err != nil {
+			return err
+		}
+		emit(p)
+	}
+	return nil
+}
+
+// parseLoadavg parses /proc/loadavg, for example:
+//
+//	0.20 0.18 0.12 1/80 11206
+func parseLoadavg(s string, t time.Time, emit func(*tsdb.Point)) error {
+	field := strings.Fields(s)
+	if len(field) < 4 {
+		return fmt.Errorf("invalid format: %q", s)
+	}
+	threads := strings.SplitN(field[3], "/", 2)
+	if len(threads) != 2 {
+		return fmt.Errorf("invalid format: %q", s)
+	}
+	for _, x := range []struct {
+		metric, value string
+	}{
+		{"proc.loadavg.1min", field[0]},
+		{"proc.loadavg.5min", field[1]},
+		{"proc.loadavg.15min", field[2]},
+		{"proc.loadavg.runnable", threads[0]},
+		{"proc.loadavg.total_threads", threads[1]},
+	} {
+		p, err := newPoint(t, x.value, x.metric)
+		if err != nil {
+			return err
+		}
+		emit(p)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package hoststat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"opentsp.org/internal/tsdb"
+)
+
+// diskstatsColumns names the columns of /proc/diskstats that follow the
+// major, minor, and device name columns. Columns added by newer kernels
+// are ignored.
+var diskstatsColumns = []string{
+	"iostat.disk.read_requests",
+	"iostat.disk.read_merged",
+	"iostat.disk.read_sectors",
+	"iostat.disk.msec_read",
+	"iostat.disk.write_requests",
+	"iostat.disk.write_merged",
+	"iostat.disk.write_sectors",
+	"iostat.disk.msec_write",
+	"iostat.disk.ios_in_progress",
+	"iostat.disk.msec_total",
+	"iostat.disk.msec_weighted_total",
+}
+
+// diskIgnored lists prefixes of virtual devices that are not reported.
+var diskIgnored = []string{"ram", "loop"}
+
+func collectDisk(root string, t time.Time, emit func(*tsdb.Point)) error {
+	f, err := openFile(root, "diskstats")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := parseDiskstats(f, t, emit); err != nil {
+		return fmt.Errorf("diskstats: %v", err)
+	}
+	return nil
+}
+
+// parseDiskstats parses /proc/diskstats.
+func parseDiskstats(r io.Reader, t time.Time, emit func(*tsdb.Point)) error {
+	scanner := bufio.NewScanner(r)
+Next:
+	for scanner.Scan() {
+		field := strings.Fields(scanner.Text())
+		if len(field) < 3+len(diskstatsColumns) {
+			// Partitions on kernels older than 2.6.25 report fewer
+			// columns; skip them.
+			continue
+		}
+		dev := field[2]
+		for _, prefix := range diskIgnored {
+			if strings.HasPrefix(dev, prefix) {
+				continue Next
+			}
+		}
+		for i, metric := range diskstatsColumns {
+			p, err := newPoint(t, field[3+i], metric, "dev", dev)
+			if err != nil {
+				return err
+			}
+			emit(p)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,132 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package hoststat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"opentsp.org/internal/tsdb"
+)
+
+// fsUsage represents filesystem usage as reported by statfs(2).
+type fsUsage struct {
+	Bytes, BytesFree   uint64
+	Inodes, InodesFree uint64
+}
+
+// statfs reports usage of the filesystem mounted at the given path.
+// Replaced in tests.
+var statfs = sysStatfs
+
+// fsIgnored lists filesystem types that are not reported. The list
+// includes pseudo filesystems and network filesystems, which may block.
+var fsIgnored = map[string]bool{
+	"autofs":      true,
+	"binfmt_misc": true,
+	"cgroup":      true,
+	"cgroup2":     true,
+	"cifs":        true,
+	"configfs":    true,
+	"debugfs":     true,
+	"devpts":      true,
+	"devtmpfs":    true,
+	"fusectl":     true,
+	"hugetlbfs":   true,
+	"mqueue":      true,
+	"nfs":         true,
+	"nfs4":        true,
+	"nfsd":        true,
+	"proc":        true,
+	"pstore":      true,
+	"rootfs":      true,
+	"rpc_pipefs":  true,
+	"securityfs":  true,
+	"sysfs":       true,
+	"tracefs":     true,
+}
+
+func collectFilesystem(root string, t time.Time, emit func(*tsdb.Point)) error {
+	f, err := openFile(root, "mounts")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := parseMounts(f, t, emit); err != nil {
+		return fmt.Errorf("mounts: %v", err)
+	}
+	return nil
+}
+
+// parseMounts parses /proc/mounts, reporting usage of every listed
+// filesystem.
+func parseMounts(r io.Reader, t time.Time, emit func(*tsdb.Point)) error {
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		field := strings.Fields(scanner.Text())
+		if len(field) < 3 {
+			continue
+		}
+		mount, fstype := unescapeMount(field[1]), field[2]
+		if fsIgnored[fstype] || seen[mount] {
+			continue
+		}
+		seen[mount] = true
+		usage, err := statfs(mount)
+		if err != nil {
+			statErrors.Add("type=Statfs", 1)
+			if Debug != nil {
+				Debug.Printf("statfs %s: %v", mount, err)
+			}
+			continue
+		}
+		if usage.Bytes == 0 {
+			continue
+		}
+		for _, x := range []struct {
+			metric string
+			value  uint64
+		}{
+			{"df.bytes.total", usage.Bytes},
+			{"df.bytes.used", usage.Bytes - usage.BytesFree},
+			{"df.bytes.free", usage.BytesFree},
+			{"df.inodes.total", usage.Inodes},
+			{"df.inodes.used", usage.Inodes - usage.InodesFree},
+			{"df.inodes.free", usage.InodesFree},
+		} {
+			p, err := newPoint(t, x.value, x.metric, "mount", mount, "fstype", fstype)
+			if err != nil {
+				return err
+			}
+			emit(p)
+		}
+	}
+	return scanner.Err()
+}
+
+// unescapeMount decodes the octal escapes used in /proc/mounts, for example
+// "\040" for space.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				buf = append(buf, byte(n))
+				i += 3
+				continue
+			}
+		}
+		buf = append(buf, s[i])
+	}
+	return string(buf)
+}
@@ -0,0 +1,149 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+// Package hoststat implements built-in collection of Linux host metrics.
+//
+// The series follow the tcollector naming conventions, so that they can
+// replace the output of the equivalent shell plugins. Counters are reported
+// as found in /proc, i.e. cumulative; rate calculation is left to the
+// consumer.
+package hoststat
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"opentsp.org/internal/tsdb"
+	"opentsp.org/internal/tsdb/tsdbutil"
+)
+
+var Debug *log.Logger
+
+// DefaultInterval is the default interval between collections.
+const DefaultInterval = 10 * time.Second
+
+const maxInterval = 1 * time.Hour
+
+var statErrors = expvar.NewMap("hoststat.Errors")
+
+// Config represents the collector settings. All subsystems are disabled by
+// default.
+type Config struct {
+	CPU        Subsystem
+	Memory     Subsystem
+	Network    Subsystem
+	Disk       Subsystem
+	Filesystem Subsystem
+
+	// root is the mount point of procfs.
+	root string
+}
+
+// Subsystem represents settings of a single subsystem.
+type Subsystem struct {
+	Enabled  bool
+	Interval string `json:",omitempty"`
+
+	interval time.Duration
+}
+
+func (c *Config) Validate() error {
+	for _, s := range c.subsystems() {
+		if err := s.config.validate(); err != nil {
+			return fmt.Errorf("%s: %v", s.name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Subsystem) validate() error {
+	s.interval = DefaultInterval
+	if s.Interval == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(s.Interval)
+	if err != nil {
+		return fmt.Errorf("invalid Interval: %v", err)
+	}
+	if d < 1*time.Second || d > maxInterval {
+		return fmt.Errorf("Interval out of range: %v", d)
+	}
+	s.interval = d
+	return nil
+}
+
+// subsystem binds subsystem settings to the collection function.
+type subsystem struct {
+	name    string
+	config  *Subsystem
+	collect collectFunc
+}
+
+// collectFunc emits points based on the procfs mounted at root.
+type collectFunc func(root string, t time.Time, emit func(*tsdb.Point)) error
+
+func (c *Config) subsystems() []subsystem {
+	return []subsystem{
+		{"CPU", &c.CPU, collectCPU},
+		{"Memory", &c.Memory, collectMemory},
+		{"Network", &c.Network, collectNetwork},
+		{"Disk", &c.Disk, collectDisk},
+		{"Filesystem", &c.Filesystem, collectFilesystem},
+	}
+}
+
+// Collect returns a tsdb.Chan that carries host metrics of the enabled
+// subsystems. Each subsystem is refreshed according to its interval.
+func Collect(config *Config) tsdb.Chan {
+	if err := config.Validate(); err != nil {
+		log.Panicf("internal error: %v", err)
+	}
+	root := config.root
+	if root == "" {
+		root = "/proc"
+	}
+	ch := make(chan *tsdb.Point)
+	for _, s := range config.subsystems() {
+		if !s.config.Enabled {
+			continue
+		}
+		go s.loop(root, ch)
+	}
+	return ch
+}
+
+func (s subsystem) loop(root string, w chan<- *tsdb.Point) {
+	tick := tsdb.Tick(s.config.interval)
+	for {
+		now := <-tick
+		if Debug != nil {
+			Debug.Printf("collect %s", s.name)
+		}
+		err := s.collect(root, now, func(p *tsdb.Point) {
+			w <- p
+		})
+		if err != nil {
+			statErrors.Add("type="+s.name, 1)
+			log.Printf("hoststat: %s: %v", s.name, err)
+		}
+	}
+}
+
+// openFile opens the named file under the procfs root.
+func openFile(root, name string) (*os.File, error) {
+	return os.Open(filepath.Join(root, name))
+}
+
+// newPoint is like tsdb.NewPoint except tag values are made valid by
+// replacing unsupported characters.
+func newPoint(t time.Time, value interface{}, metric string, keyval ...string) (*tsdb.Point, error) {
+	for i := 1; i < len(keyval); i += 2 {
+		keyval[i] = tsdbutil.TagValue(keyval[i])
+	}
+	return tsdb.NewPoint(t, value, metric, keyval...)
+}
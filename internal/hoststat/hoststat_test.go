@@ -0,0 +1,255 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package hoststat
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"opentsp.org/internal/tsdb"
+)
+
+// testProc is a fixture procfs tree.
+var testProc = map[string]string{
+	"stat": `cpu  100 2 30 4000 5 0 6 0 0 0
+cpu0 50 1 15 2000 3 0 3 0 0 0
+intr 12345 1 2 3
+ctxt 999
+btime 1420070400
+processes 77
+procs_running 2
+procs_blocked 0
+`,
+	"loadavg": "0.20 0.18 0.12 1/80 11206\n",
+	"meminfo": `MemTotal:        2048000 kB
+MemFree:          512000 kB
+Active(anon):      10000 kB
+HugePages_Total:       0
+`,
+	"net/dev": `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  eth0: 1000 10 0 0 0 0 0 1 2000 20 0 0 0 0 0 0
+`,
+	"diskstats": `   1       0 ram0 0 0 0 0 0 0 0 0 0 0 0
+   8       0 sda 100 1 800 50 200 2 1600 70 0 120 120
+   8       1 sda1 90 1 720 45 190 2 1520 65 0 110 110
+`,
+	"mounts": `rootfs / rootfs rw 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/sda1 / ext4 rw,relatime 0 0
+/dev/sda2 /mnt/my\040disk xfs rw,relatime 0 0
+`,
+}
+
+func writeTestProc(t *testing.T) string {
+	root, err := ioutil.TempDir("", "hoststattest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range testProc {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func testStatfs(path string) (*fsUsage, error) {
+	switch path {
+	case "/":
+		return &fsUsage{Bytes: 1000, BytesFree: 400, Inodes: 100, InodesFree: 90}, nil
+	case "/mnt/my disk":
+		return &fsUsage{Bytes: 10, BytesFree: 10, Inodes: 1, InodesFree: 1}, nil
+	}
+	return nil, fmt.Errorf("unexpected statfs: %s", path)
+}
+
+var testCollect = []struct {
+	fn   collectFunc
+	want []string
+}{
+	{
+		fn: collectCPU,
+		want: []string{
+			`proc.loadavg.15min 0 0.12`,
+			`proc.loadavg.1min 0 0.2`,
+			`proc.loadavg.5min 0 0.18`,
+			`proc.loadavg.runnable 0 1`,
+			`proc.loadavg.total_threads 0 80`,
+			`proc.stat.cpu 0 0 type=guest`,
+			`proc.stat.cpu 0 0 type=guest_nice`,
+			`proc.stat.cpu 0 0 type=irq`,
+			`proc.stat.cpu 0 0 type=steal`,
+			`proc.stat.cpu 0 100 type=user`,
+			`proc.stat.cpu 0 2 type=nice`,
+			`proc.stat.cpu 0 30 type=system`,
+			`proc.stat.cpu 0 4000 type=idle`,
+			`proc.stat.cpu 0 5 type=iowait`,
+			`proc.stat.cpu 0 6 type=softirq`,
+			`proc.stat.cpu.percpu 0 0 type=guest cpu=0`,
+			`proc.stat.cpu.percpu 0 0 type=guest_nice cpu=0`,
+			`proc.stat.cpu.percpu 0 0 type=irq cpu=0`,
+			`proc.stat.cpu.percpu 0 0 type=steal cpu=0`,
+			`proc.stat.cpu.percpu 0 1 type=nice cpu=0`,
+			`proc.stat.cpu.percpu 0 15 type=system cpu=0`,
+			`proc.stat.cpu.percpu 0 2000 type=idle cpu=0`,
+			`proc.stat.cpu.percpu 0 3 type=iowait cpu=0`,
+			`proc.stat.cpu.percpu 0 3 type=softirq cpu=0`,
+			`proc.stat.cpu.percpu 0 50 type=user cpu=0`,
+			`proc.stat.ctxt 0 999`,
+			`proc.stat.intr 0 12345`,
+			`proc.stat.processes 0 77`,
+			`proc.stat.procs_blocked 0 0`,
+			`proc.stat.procs_running 0 2`,
+		},
+	},
+	{
+		fn: collectMemory,
+		want: []string{
+			`proc.meminfo.active_anon 0 10000`,
+			`proc.meminfo.hugepages_total 0 0`,
+			`proc.meminfo.memfree 0 512000`,
+			`proc.meminfo.memtotal 0 2048000`,
+		},
+	},
+	{
+		fn: collectNetwork,
+		want: []string{
+			`proc.net.bytes 0 1000 iface=eth0 direction=in`,
+			`proc.net.bytes 0 2000 iface=eth0 direction=out`,
+			`proc.net.carrier.errs 0 0 iface=eth0 direction=out`,
+			`proc.net.collisions 0 0 iface=eth0 direction=out`,
+			`proc.net.compressed 0 0 iface=eth0 direction=in`,
+			`proc.net.compressed 0 0 iface=eth0 direction=out`,
+			`proc.net.dropped 0 0 iface=eth0 direction=in`,
+			`proc.net.dropped 0 0 iface=eth0 direction=out`,
+			`proc.net.errs 0 0 iface=eth0 direction=in`,
+			`proc.net.errs 0 0 iface=eth0 direction=out`,
+			`proc.net.fifo.errs 0 0 iface=eth0 direction=in`,
+			`proc.net.fifo.errs 0 0 iface=eth0 direction=out`,
+			`proc.net.frame.errs 0 0 iface=eth0 direction=in`,
+			`proc.net.multicast 0 1 iface=eth0 direction=in`,
+			`proc.net.packets 0 10 iface=eth0 direction=in`,
+			`proc.net.packets 0 20 iface=eth0 direction=out`,
+		},
+	},
+	{
+		fn: collectDisk,
+		want: []string{
+			`iostat.disk.ios_in_progress 0 0 dev=sda`,
+			`iostat.disk.ios_in_progress 0 0 dev=sda1`,
+			`iostat.disk.msec_read 0 45 dev=sda1`,
+			`iostat.disk.msec_read 0 50 dev=sda`,
+			`iostat.disk.msec_total 0 110 dev=sda1`,
+			`iostat.disk.msec_total 0 120 dev=sda`,
+			`iostat.disk.msec_weighted_total 0 110 dev=sda1`,
+			`iostat.disk.msec_weighted_total 0 120 dev=sda`,
+			`iostat.disk.msec_write 0 65 dev=sda1`,
+			`iostat.disk.msec_write 0 70 dev=sda`,
+			`iostat.disk.read_merged 0 1 dev=sda`,
+			`iostat.disk.read_merged 0 1 dev=sda1`,
+			`iostat.disk.read_requests 0 100 dev=sda`,
+			`iostat.disk.read_requests 0 90 dev=sda1`,
+			`iostat.disk.read_sectors 0 720 dev=sda1`,
+			`iostat.disk.read_sectors 0 800 dev=sda`,
+			`iostat.disk.write_merged 0 2 dev=sda`,
+			`iostat.disk.write_merged 0 2 dev=sda1`,
+			`iostat.disk.write_requests 0 190 dev=sda1`,
+			`iostat.disk.write_requests 0 200 dev=sda`,
+			`iostat.disk.write_sectors 0 1520 dev=sda1`,
+			`iostat.disk.write_sectors 0 1600 dev=sda`,
+		},
+	},
+	{
+		fn: collectFilesystem,
+		want: []string{
+			`df.bytes.free 0 10 mount=/mnt/my_disk fstype=xfs`,
+			`df.bytes.free 0 400 mount=/ fstype=ext4`,
+			`df.bytes.total 0 10 mount=/mnt/my_disk fstype=xfs`,
+			`df.bytes.total 0 1000 mount=/ fstype=ext4`,
+			`df.bytes.used 0 0 mount=/mnt/my_disk fstype=xfs`,
+			`df.bytes.used 0 600 mount=/ fstype=ext4`,
+			`df.inodes.free 0 1 mount=/mnt/my_disk fstype=xfs`,
+			`df.inodes.free 0 90 mount=/ fstype=ext4`,
+			`df.inodes.total 0 1 mount=/mnt/my_disk fstype=xfs`,
+			`df.inodes.total 0 100 mount=/ fstype=ext4`,
+			`df.inodes.used 0 0 mount=/mnt/my_disk fstype=xfs`,
+			`df.inodes.used 0 10 mount=/ fstype=ext4`,
+		},
+	},
+}
+
+func TestCollect(t *testing.T) {
+	root := writeTestProc(t)
+	defer os.RemoveAll(root)
+	statfs = testStatfs
+	defer func() { statfs = sysStatfs }()
+	for i, tt := range testCollect {
+		var got []string
+		err := tt.fn(root, time.Unix(0, 0), func(p *tsdb.Point) {
+			got = append(got, encode(t, p))
+		})
+		if err != nil {
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d. invalid output\ngot:  %q\nwant: %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestCollectMissing(t *testing.T) {
+	root, err := ioutil.TempDir("", "hoststattest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	var config Config
+	for _, s := range config.subsystems() {
+		err := s.collect(root, time.Unix(0, 0), func(*tsdb.Point) {})
+		if err == nil {
+			t.Errorf("%s: unexpected success", s.name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	config := &Config{CPU: Subsystem{Enabled: true, Interval: "1m"}}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := config.CPU.interval; got != 1*time.Minute {
+		t.Errorf("got interval %v, want 1m", got)
+	}
+	if got := config.Disk.interval; got != DefaultInterval {
+		t.Errorf("got interval %v, want %v", got, DefaultInterval)
+	}
+	config = &Config{Memory: Subsystem{Interval: "10ms"}}
+	if err := config.Validate(); err == nil {
+		t.Errorf("unexpected success")
+	}
+}
+
+func encode(t *testing.T, p *tsdb.Point) string {
+	var buf bytes.Buffer
+	if err := tsdb.NewEncoder(&buf).Encode(p); err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,59 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package hoststat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"opentsp.org/internal/tsdb"
+	"opentsp.org/internal/tsdb/tsdbutil"
+)
+
+func collectMemory(root string, t time.Time, emit func(*tsdb.Point)) error {
+	f, err := openFile(root, "meminfo")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := parseMeminfo(f, t, emit); err != nil {
+		return fmt.Errorf("meminfo: %v", err)
+	}
+	return nil
+}
+
+// parseMeminfo parses /proc/meminfo. The values are reported as found, i.e.
+// mostly in kilobytes.
+func parseMeminfo(r io.Reader, t time.Time, emit func(*tsdb.Point)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.IndexByte(line, ':')
+		if i == -1 {
+			continue
+		}
+		field := strings.Fields(line[i+1:])
+		if len(field) == 0 {
+			continue
+		}
+		p, err := newPoint(t, field[0], "proc.meminfo."+meminfoKey(line[:i]))
+		if err != nil {
+			return err
+		}
+		emit(p)
+	}
+	return scanner.Err()
+}
+
+// meminfoKey converts a key such as "Active(anon)" to "active_anon".
+func meminfoKey(s string) string {
+	s = strings.ToLower(s)
+	s = strings.Replace(s, "(", "_", -1)
+	s = strings.Replace(s, ")", "", -1)
+	return tsdbutil.TagValue(s)
+}
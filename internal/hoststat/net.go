@@ -0,0 +1,66 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package hoststat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"opentsp.org/internal/tsdb"
+)
+
+// netdevColumns names the columns of /proc/net/dev. The first 8 columns
+// describe received traffic, the remaining 8 describe sent traffic.
+var netdevColumns = []string{
+	"proc.net.bytes", "proc.net.packets", "proc.net.errs", "proc.net.dropped",
+	"proc.net.fifo.errs", "proc.net.frame.errs", "proc.net.compressed", "proc.net.multicast",
+	"proc.net.bytes", "proc.net.packets", "proc.net.errs", "proc.net.dropped",
+	"proc.net.fifo.errs", "proc.net.collisions", "proc.net.carrier.errs", "proc.net.compressed",
+}
+
+func collectNetwork(root string, t time.Time, emit func(*tsdb.Point)) error {
+	f, err := openFile(root, "net/dev")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := parseNetdev(f, t, emit); err != nil {
+		return fmt.Errorf("net/dev: %v", err)
+	}
+	return nil
+}
+
+// parseNetdev parses /proc/net/dev.
+func parseNetdev(r io.Reader, t time.Time, emit func(*tsdb.Point)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.IndexByte(line, ':')
+		if i == -1 {
+			// header
+			continue
+		}
+		iface := strings.TrimSpace(line[:i])
+		field := strings.Fields(line[i+1:])
+		if len(field) != len(netdevColumns) {
+			return fmt.Errorf("iface %s: got %d columns, want %d", iface, len(field), len(netdevColumns))
+		}
+		for j, value := range field {
+			direction := "in"
+			if j >= len(netdevColumns)/2 {
+				direction = "out"
+			}
+			p, err := newPoint(t, value, netdevColumns[j], "iface", iface, "direction", direction)
+			if err != nil {
+				return err
+			}
+			emit(p)
+		}
+	}
+	return scanner.Err()
+}
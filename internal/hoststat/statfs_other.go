@@ -0,0 +1,14 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+// +build !linux
+
+package hoststat
+
+import "fmt"
+
+// sysStatfs is unsupported: filesystem usage is reported only on Linux.
+func sysStatfs(path string) (*fsUsage, error) {
+	return nil, fmt.Errorf("statfs: not supported")
+}
@@ -0,0 +1,413 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+// Package httpstat implements built-in collection of JSON and Prometheus
+// documents served over HTTP.
+//
+// By default, a JSON document is expected to be the output of an expvar
+// handler, and is exported following the rules of tsdbutil.ExportJSON. Other
+// JSON documents are flattened, and their numbers mapped to series using path
+// patterns. Prometheus documents use the text exposition format.
+package httpstat
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"opentsp.org/internal/tsdb"
+	"opentsp.org/internal/tsdb/tsdbutil"
+)
+
+var Debug *log.Logger
+
+// Default settings.
+const (
+	DefaultInterval = 10 * time.Second
+	DefaultTimeout  = 5 * time.Second
+)
+
+const (
+	maxInterval = 1 * time.Hour
+	maxTargets  = 1024
+	maxRules    = 64
+	maxBodySize = 16 << 20
+)
+
+// maxTags is the number of tags a series may carry besides the host tag.
+const maxTags = 7
+
+var statErrors = expvar.NewMap("httpstat.Errors")
+
+// Config represents the collector settings. The collector is disabled if no
+// targets are given.
+type Config struct {
+	Interval string    `json:",omitempty"`
+	Timeout  string    `json:",omitempty"`
+	Target   []*Target `json:",omitempty"`
+
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func (c *Config) Validate() error {
+	var err error
+	if c.interval, err = parseDuration("Interval", c.Interval, DefaultInterval); err != nil {
+		return err
+	}
+	if c.timeout, err = parseDuration("Timeout", c.Timeout, DefaultTimeout); err != nil {
+		return err
+	}
+	if n := len(c.Target); n > maxTargets {
+		return fmt.Errorf("too many targets defined: %d > %d", n, maxTargets)
+	}
+	seen := make(map[string]bool)
+	for _, t := range c.Target {
+		if t == nil {
+			return fmt.Errorf("invalid target: null")
+		}
+		if err := t.Validate(); err != nil {
+			return err
+		}
+		if seen[t.URL] {
+			return fmt.Errorf("target redeclared: %s", t.URL)
+		}
+		seen[t.URL] = true
+	}
+	return nil
+}
+
+// Merge adds the targets not yet present in c, returning those skipped. Null
+// targets are added, and left for Validate to reject.
+func (c *Config) Merge(targets []*Target) (skipped []*Target) {
+	seen := make(map[string]bool)
+	for _, t := range c.Target {
+		if t != nil {
+			seen[t.URL] = true
+		}
+	}
+	for _, t := range targets {
+		if t != nil && seen[t.URL] {
+			skipped = append(skipped, t)
+			continue
+		}
+		if t != nil {
+			seen[t.URL] = true
+		}
+		c.Target = append(c.Target, t)
+	}
+	return skipped
+}
+
+func parseDuration(name, s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	if d < 1*time.Second || d > maxInterval {
+		return 0, fmt.Errorf("%s out of range: %v", name, d)
+	}
+	return d, nil
+}
+
+// Document formats.
+const (
+	FormatJSON       = "json"
+	FormatPrometheus = "prometheus"
+)
+
+// Target represents a document. Format is FormatJSON (the default) or
+// FormatPrometheus. Prefix is prepended to the names of the exported series.
+//
+// If Map is empty, a JSON document is exported as expvar output; otherwise
+// only the numbers matched by Map are exported. DropLabel lists the labels
+// of a Prometheus document that are not exported as tags. The host tag is
+// always set to the target host, so a host label is reported as an error
+// unless it is dropped.
+type Target struct {
+	URL       string
+	Format    string   `json:",omitempty"`
+	Prefix    string   `json:",omitempty"`
+	Map       []*Rule  `json:",omitempty"`
+	DropLabel []string `json:",omitempty"`
+
+	host      string
+	dropLabel map[string]bool
+}
+
+func (t *Target) Validate() error {
+	if t.URL == "" {
+		return fmt.Errorf("target: missing URL")
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return fmt.Errorf("target %s: invalid URL: %v", t.URL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("target %s: invalid URL: want http or https", t.URL)
+	}
+	t.host = u.Host
+	if host, _, err := net.SplitHostPort(u.Host); err == nil {
+		t.host = host
+	}
+	t.host = tsdb.Clean(t.host)
+	if tsdb.Clean(t.Prefix) != t.Prefix {
+		return fmt.Errorf("target %s: invalid Prefix: %q", t.URL, t.Prefix)
+	}
+	switch t.Format {
+	default:
+		return fmt.Errorf("target %s: unsupported Format: %s", t.URL, t.Format)
+	case "", FormatJSON:
+		if len(t.DropLabel) > 0 {
+			return fmt.Errorf("target %s: DropLabel requires Format %s", t.URL, FormatPrometheus)
+		}
+	case FormatPrometheus:
+		if len(t.Map) > 0 {
+			return fmt.Errorf("target %s: Map requires Format %s", t.URL, FormatJSON)
+		}
+	}
+	t.dropLabel = make(map[string]bool)
+	for _, label := range t.DropLabel {
+		t.dropLabel[label] = true
+	}
+	if n := len(t.Map); n > maxRules {
+		return fmt.Errorf("target %s: too many rules: %d > %d", t.URL, n, maxRules)
+	}
+	for _, r := range t.Map {
+		if r == nil {
+			return fmt.Errorf("target %s: invalid rule: null", t.URL)
+		}
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("target %s: %v", t.URL, err)
+		}
+	}
+	return nil
+}
+
+// Rule maps the numbers found at the paths matching a pattern to a metric.
+// A path lists the object keys and array indices leading to a number,
+// separated by dots. In the pattern, the element "*" matches any key or
+// index, and the element "{name}" does the same and sets the tag name to the
+// matched value.
+type Rule struct {
+	Path   string
+	Metric string
+
+	pattern []string
+}
+
+func (r *Rule) Validate() error {
+	if r.Path == "" {
+		return fmt.Errorf("rule: missing Path")
+	}
+	if r.Metric == "" || tsdb.Clean(r.Metric) != r.Metric {
+		return fmt.Errorf("rule %s: invalid Metric: %q", r.Path, r.Metric)
+	}
+	r.pattern = strings.Split(r.Path, ".")
+	seen := map[string]bool{"host": true}
+	for _, elem := range r.pattern {
+		if elem == "" {
+			return fmt.Errorf("rule %s: empty path element", r.Path)
+		}
+		name, ok := tagName(elem)
+		if !ok {
+			continue
+		}
+		if name == "" || tsdb.Clean(name) != name {
+			return fmt.Errorf("rule %s: invalid tag name: %q", r.Path, name)
+		}
+		if seen[name] {
+			return fmt.Errorf("rule %s: tag redeclared: %s", r.Path, name)
+		}
+		seen[name] = true
+	}
+	if n := len(seen) - 1; n > maxTags {
+		return fmt.Errorf("rule %s: too many tags: %d > %d", r.Path, n, maxTags)
+	}
+	return nil
+}
+
+// tagName returns the tag name of a "{name}" pattern element.
+func tagName(elem string) (string, bool) {
+	if !strings.HasPrefix(elem, "{") || !strings.HasSuffix(elem, "}") {
+		return "", false
+	}
+	return elem[1 : len(elem)-1], true
+}
+
+// match reports whether the path matches the rule, and returns the tags
+// captured.
+func (r *Rule) match(path []string) (keyval []string, ok bool) {
+	if len(path) != len(r.pattern) {
+		return nil, false
+	}
+	for i, elem := range r.pattern {
+		if name, ok := tagName(elem); ok {
+			keyval = append(keyval, name, tsdb.Clean(path[i]))
+			continue
+		}
+		if elem != "*" && elem != path[i] {
+			return nil, false
+		}
+	}
+	return keyval, true
+}
+
+// Collect returns a tsdb.Chan that carries the series of all targets. If no
+// targets are given, the channel is silent.
+func Collect(config *Config) tsdb.Chan {
+	if err := config.Validate(); err != nil {
+		log.Panicf("internal error: %v", err)
+	}
+	ch := make(chan *tsdb.Point)
+	client := &http.Client{Timeout: config.timeout}
+	for _, t := range config.Target {
+		go loop(client, config.interval, t, ch)
+	}
+	return ch
+}
+
+func loop(client *http.Client, interval time.Duration, target *Target, w chan<- *tsdb.Point) {
+	tick := tsdb.Tick(interval)
+	for {
+		now := <-tick
+		err := collect(client, now, target, func(p *tsdb.Point) {
+			w <- p
+		})
+		if err != nil {
+			log.Printf("httpstat: %s: %v", target.URL, err)
+		}
+	}
+}
+
+// collect scrapes the target and emits its series.
+func collect(client *http.Client, t time.Time, target *Target, emit func(*tsdb.Point)) error {
+	buf, err := scrape(client, target.URL)
+	if err != nil {
+		return err
+	}
+	metric := make([]byte, 0, 1024)
+	export := func(p *tsdb.Point) {
+		metric = append(metric[:0], target.Prefix...)
+		metric = append(metric, p.Metric()...)
+		if err := p.SetMetric(metric); err != nil {
+			log.Panic(err)
+		}
+		if err := p.SetTags([]byte("host"), []byte(target.host)); err != nil {
+			// Too many tags.
+			statErrors.Add("type=Export", 1)
+			if Debug != nil {
+				Debug.Printf("%s: %s: %v", target.URL, p.Metric(), err)
+			}
+			return
+		}
+		emit(p)
+	}
+	if target.Format == FormatPrometheus {
+		return exportPrometheus(buf, t, target, export)
+	}
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		statErrors.Add("type=Decode", 1)
+		return err
+	}
+	if len(target.Map) == 0 {
+		vars, ok := doc.(map[string]interface{})
+		if !ok {
+			statErrors.Add("type=Decode", 1)
+			return fmt.Errorf("document not a JSON object")
+		}
+		if err := tsdbutil.ExportJSON(t, vars, export); err != nil {
+			statErrors.Add("type=Export", 1)
+			return err
+		}
+		return nil
+	}
+	var errs int
+	walk(doc, nil, func(path []string, n json.Number) {
+		for _, r := range target.Map {
+			keyval, ok := r.match(path)
+			if !ok {
+				continue
+			}
+			p, err := tsdb.NewPoint(t, 0, r.Metric, keyval...)
+			if err == nil {
+				err = p.SetValue(n.String())
+			}
+			if err != nil {
+				errs++
+				if Debug != nil {
+					Debug.Printf("%s: %s: %v", target.URL, strings.Join(path, "."), err)
+				}
+				return
+			}
+			export(p)
+			return
+		}
+	})
+	if errs > 0 {
+		statErrors.Add("type=Export", int64(errs))
+		return fmt.Errorf("%d numbers not exported", errs)
+	}
+	return nil
+}
+
+// scrape fetches the document at the given URL.
+func scrape(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			statErrors.Add("type=Timeout", 1)
+		} else {
+			statErrors.Add("type=Scrape", 1)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		statErrors.Add("type=Scrape", 1)
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			statErrors.Add("type=Timeout", 1)
+		} else {
+			statErrors.Add("type=Scrape", 1)
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// walk calls fn for each number in the document, passing its path.
+func walk(doc interface{}, path []string, fn func([]string, json.Number)) {
+	switch v := doc.(type) {
+	case json.Number:
+		fn(path, v)
+	case map[string]interface{}:
+		for key, elem := range v {
+			walk(elem, append(path[:len(path):len(path)], key), fn)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			walk(elem, append(path[:len(path):len(path)], strconv.Itoa(i)), fn)
+		}
+	}
+}
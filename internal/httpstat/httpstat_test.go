@@ -0,0 +1,333 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package httpstat
+
+import (
+	"bytes"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"opentsp.org/internal/tsdb"
+)
+
+// testDocs are served by the test server.
+var testDocs = map[string]string{
+	"/debug/vars": `{
+		"cmdline": ["/usr/bin/app", "-v"],
+		"requests": 42,
+		"latency": 0.5,
+		"app.Errors": {"type=Timeout": 3, "type=Refused code=7": 1, "other": "text"},
+		"memstats": {"Alloc": 1024, "PauseNs": [1, 2], "EnableGC": true}
+	}`,
+	"/stats": `{
+		"pools": {
+			"db": {"active": 2, "idle": 8},
+			"cache": {"active": 1, "idle": 0}
+		},
+		"queues": [{"len": 5}, {"len": 7}],
+		"uptime": 3600,
+		"version": "1.2"
+	}`,
+	"/metrics.host": `up{host="db1",job="x"} 1
+`,
+	"/array":   `[1, 2]`,
+	"/invalid": `{"requests": `,
+	"/metrics": `# HELP http_requests_total The total number of requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+http_requests_total{method="get",code="",path="C:\\DIR\\"} 5
+
+# Escaping in label values:
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9
+
+# Minimalistic line:
+metric_without_timestamp_and_labels 12.47
+nan_value NaN
+
+# A histogram, which has a pretty complex representation in the text format:
+# HELP http_request_duration_seconds A histogram of the request duration.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+
+# Finally a summary, which has a complex representation, too:
+# HELP rpc_duration_seconds A summary of the RPC duration in seconds.
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+
+too_many_labels{a="1",b="2",c="3",d="4",e="5",f="6",g="7",h="8"} 1
+`,
+	"/metrics.invalid": `http_requests_total{method="post" 1027`,
+}
+
+var testCollect = []struct {
+	target *Target
+	want   []string
+	err    string
+}{
+	{
+		target: &Target{URL: "/debug/vars"},
+		want: []string{
+			`app.Errors 0 1 host=127.0.0.1 type=Refused code=7`,
+			`app.Errors 0 3 host=127.0.0.1 type=Timeout`,
+			`latency 0 0.5 host=127.0.0.1`,
+			`mem.Alloc 0 1024 host=127.0.0.1`,
+			`requests 0 42 host=127.0.0.1`,
+		},
+	},
+	{
+		target: &Target{URL: "/debug/vars", Prefix: "app."},
+		want: []string{
+			`app.app.Errors 0 1 host=127.0.0.1 type=Refused code=7`,
+			`app.app.Errors 0 3 host=127.0.0.1 type=Timeout`,
+			`app.latency 0 0.5 host=127.0.0.1`,
+			`app.mem.Alloc 0 1024 host=127.0.0.1`,
+			`app.requests 0 42 host=127.0.0.1`,
+		},
+	},
+	{
+		target: &Target{
+			URL:    "/stats",
+			Prefix: "svc.",
+			Map: []*Rule{
+				{Path: "pools.{pool}.{state}", Metric: "pool.conns"},
+				{Path: "queues.{queue}.len", Metric: "queue.len"},
+				{Path: "uptime", Metric: "uptime"},
+			},
+		},
+		want: []string{
+			`svc.pool.conns 0 0 host=127.0.0.1 pool=cache state=idle`,
+			`svc.pool.conns 0 1 host=127.0.0.1 pool=cache state=active`,
+			`svc.pool.conns 0 2 host=127.0.0.1 pool=db state=active`,
+			`svc.pool.conns 0 8 host=127.0.0.1 pool=db state=idle`,
+			`svc.queue.len 0 5 host=127.0.0.1 queue=0`,
+			`svc.queue.len 0 7 host=127.0.0.1 queue=1`,
+			`svc.uptime 0 3600 host=127.0.0.1`,
+		},
+	},
+	{
+		target: &Target{
+			URL: "/stats",
+			Map: []*Rule{
+				{Path: "pools.*.active", Metric: "active"},
+			},
+		},
+		want: []string{
+			`active 0 1 host=127.0.0.1`,
+			`active 0 2 host=127.0.0.1`,
+		},
+	},
+	{
+		target: &Target{URL: "/metrics", Format: "prometheus", Prefix: "app.", DropLabel: []string{"h"}},
+		want: []string{
+			`app.http_request_duration_seconds.bucket 0 144320 host=127.0.0.1 le=Inf`,
+			`app.http_request_duration_seconds.bucket 0 24054 host=127.0.0.1 le=0.05`,
+			`app.http_request_duration_seconds.count 0 144320 host=127.0.0.1`,
+			`app.http_request_duration_seconds.sum 0 53423 host=127.0.0.1`,
+			`app.http_requests_total 0 1027 host=127.0.0.1 method=post code=200`,
+			`app.http_requests_total 0 3 host=127.0.0.1 method=post code=400`,
+			`app.http_requests_total 0 5 host=127.0.0.1 method=get path=C__DIR_`,
+			`app.metric_without_timestamp_and_labels 0 12.47 host=127.0.0.1`,
+			`app.msdos_file_access_time_seconds 0 1458255915 host=127.0.0.1 path=C__DIR_FILE.TXT error=Cannot_find_file___FILE.TXT_`,
+			`app.rpc_duration_seconds.count 0 2693 host=127.0.0.1`,
+			`app.rpc_duration_seconds.quantile 0 4773 host=127.0.0.1 quantile=0.5`,
+			`app.rpc_duration_seconds.sum 0 17560473 host=127.0.0.1`,
+			`app.too_many_labels 0 1 host=127.0.0.1 a=1 b=2 c=3 d=4 e=5 f=6 g=7`,
+		},
+	},
+	{
+		target: &Target{URL: "/metrics", Format: "prometheus"},
+		want: []string{
+			`http_request_duration_seconds.bucket 0 144320 host=127.0.0.1 le=Inf`,
+			`http_request_duration_seconds.bucket 0 24054 host=127.0.0.1 le=0.05`,
+			`http_request_duration_seconds.count 0 144320 host=127.0.0.1`,
+			`http_request_duration_seconds.sum 0 53423 host=127.0.0.1`,
+			`http_requests_total 0 1027 host=127.0.0.1 method=post code=200`,
+			`http_requests_total 0 3 host=127.0.0.1 method=post code=400`,
+			`http_requests_total 0 5 host=127.0.0.1 method=get path=C__DIR_`,
+			`metric_without_timestamp_and_labels 0 12.47 host=127.0.0.1`,
+			`msdos_file_access_time_seconds 0 1458255915 host=127.0.0.1 path=C__DIR_FILE.TXT error=Cannot_find_file___FILE.TXT_`,
+			`rpc_duration_seconds.count 0 2693 host=127.0.0.1`,
+			`rpc_duration_seconds.quantile 0 4773 host=127.0.0.1 quantile=0.5`,
+			`rpc_duration_seconds.sum 0 17560473 host=127.0.0.1`,
+		},
+	},
+	{
+		target: &Target{URL: "/metrics.host", Format: "prometheus"},
+		want:   []string{`up 0 1 host=127.0.0.1 job=x`},
+		err:    "1 samples have a host label, replaced by the target host; add host to DropLabel",
+	},
+	{
+		target: &Target{URL: "/metrics.host", Format: "prometheus", DropLabel: []string{"host"}},
+		want:   []string{`up 0 1 host=127.0.0.1 job=x`},
+	},
+	{
+		target: &Target{URL: "/metrics.invalid", Format: "prometheus"},
+		err:    "line 1: invalid label",
+	},
+	{
+		target: &Target{URL: "/array"},
+		err:    "document not a JSON object",
+	},
+	{
+		target: &Target{URL: "/invalid"},
+		err:    "unexpected EOF",
+	},
+	{
+		target: &Target{URL: "/missing"},
+		err:    "unexpected status: 404 Not Found",
+	},
+}
+
+func TestCollect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, ok := testDocs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(doc))
+	}))
+	defer server.Close()
+	client := &http.Client{Timeout: time.Second}
+	for i, tt := range testCollect {
+		tt.target.URL = server.URL + tt.target.URL
+		if err := tt.target.Validate(); err != nil {
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		}
+		var got []string
+		err := collect(client, time.Unix(0, 0), tt.target, func(p *tsdb.Point) {
+			got = append(got, encode(t, p))
+		})
+		switch {
+		case err != nil && tt.err == "":
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		case err == nil && tt.err != "":
+			t.Errorf("#%d. unexpected success, want error: %v", i, tt.err)
+			continue
+		case err != nil && err.Error() != tt.err:
+			t.Errorf("#%d. invalid error, got: %s, want: %s", i, err, tt.err)
+			continue
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d. invalid output\ngot:  %q\nwant: %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestCollectTimeout(t *testing.T) {
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+	target := &Target{URL: server.URL}
+	if err := target.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	before := counter("type=Timeout")
+	client := &http.Client{Timeout: 10 * time.Millisecond}
+	err := collect(client, time.Unix(0, 0), target, func(*tsdb.Point) {})
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+	if got := counter("type=Timeout") - before; got != 1 {
+		t.Errorf("got %d timeouts, want 1", got)
+	}
+}
+
+func counter(key string) int64 {
+	v, ok := statErrors.Get(key).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
+
+var testValidate = []struct {
+	in  *Config
+	err string
+}{
+	{&Config{}, ""},
+	{&Config{Target: []*Target{{URL: "http://localhost:8080/debug/vars"}}}, ""},
+	{&Config{Target: []*Target{{URL: "https://app/stats", Map: []*Rule{{Path: "a.{b}.*", Metric: "m"}}}}}, ""},
+	{&Config{Interval: "100ms"}, "Interval out of range"},
+	{&Config{Timeout: "x"}, "invalid Timeout"},
+	{&Config{Target: []*Target{{}}}, "target: missing URL"},
+	{&Config{Target: []*Target{{URL: "ftp://app/"}}}, "target ftp://app/: invalid URL"},
+	{&Config{Target: []*Target{{URL: "/debug/vars"}}}, "target /debug/vars: invalid URL"},
+	{&Config{Target: []*Target{{URL: "http://app/", Prefix: "a b"}}}, "target http://app/: invalid Prefix"},
+	{
+		&Config{Target: []*Target{{URL: "http://app/"}, {URL: "http://app/"}}},
+		"target redeclared: http://app/",
+	},
+	{&Config{Target: []*Target{{URL: "http://app/", Format: "prometheus", DropLabel: []string{"job"}}}}, ""},
+	{&Config{Target: []*Target{{URL: "http://app/", Format: "xml"}}}, "target http://app/: unsupported Format: xml"},
+	{&Config{Target: []*Target{{URL: "http://app/", DropLabel: []string{"job"}}}}, "target http://app/: DropLabel requires Format prometheus"},
+	{&Config{Target: []*Target{{URL: "http://app/", Format: "prometheus", Map: []*Rule{{Path: "a", Metric: "m"}}}}}, "target http://app/: Map requires Format json"},
+	{&Config{Target: []*Target{{URL: "http://app/", Map: []*Rule{{Metric: "m"}}}}}, "target http://app/: rule: missing Path"},
+	{&Config{Target: []*Target{{URL: "http://app/", Map: []*Rule{{Path: "a"}}}}}, "target http://app/: rule a: invalid Metric"},
+	{&Config{Target: []*Target{{URL: "http://app/", Map: []*Rule{{Path: "a..b", Metric: "m"}}}}}, "target http://app/: rule a..b: empty path element"},
+	{&Config{Target: []*Target{{URL: "http://app/", Map: []*Rule{{Path: "{}", Metric: "m"}}}}}, "target http://app/: rule {}: invalid tag name"},
+	{&Config{Target: []*Target{{URL: "http://app/", Map: []*Rule{{Path: "{host}", Metric: "m"}}}}}, "target http://app/: rule {host}: tag redeclared: host"},
+	{&Config{Target: []*Target{{URL: "http://app/", Map: []*Rule{{Path: "{a}.{a}", Metric: "m"}}}}}, "target http://app/: rule {a}.{a}: tag redeclared: a"},
+}
+
+func TestValidate(t *testing.T) {
+	for i, tt := range testValidate {
+		err := tt.in.Validate()
+		switch {
+		case err == nil && tt.err != "":
+			t.Errorf("#%d. unexpected success, want error: %v", i, tt.err)
+		case err != nil && tt.err == "":
+			t.Errorf("#%d. unexpected error: %v", i, err)
+		case err != nil && !strings.HasPrefix(err.Error(), tt.err):
+			t.Errorf("#%d. invalid error, got: %s, want: %s", i, err, tt.err)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	config := &Config{Target: []*Target{{URL: "http://app1/"}}}
+	skipped := config.Merge([]*Target{
+		{URL: "http://app1/", Format: "prometheus"},
+		{URL: "http://app2/", Format: "prometheus"},
+		{URL: "http://app2/"},
+	})
+	var got, want []string
+	for _, target := range config.Target {
+		got = append(got, target.URL+" "+target.Format)
+	}
+	want = []string{"http://app1/ ", "http://app2/ prometheus"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid targets\ngot:  %q\nwant: %q", got, want)
+	}
+	if len(skipped) != 2 {
+		t.Errorf("got %d targets skipped, want 2", len(skipped))
+	}
+}
+
+func encode(t *testing.T, p *tsdb.Point) string {
+	var buf bytes.Buffer
+	if err := tsdb.NewEncoder(&buf).Encode(p); err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
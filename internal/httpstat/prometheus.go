@@ -0,0 +1,239 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package httpstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"opentsp.org/internal/tsdb"
+)
+
+// sample is a sample of the Prometheus text format.
+type sample struct {
+	name   string
+	labels []string // key, value, key, value, ...
+	value  float64
+}
+
+// parsePrometheus parses the Prometheus text exposition format, calling fn
+// for each sample with the name and type of its metric family. Timestamps
+// are ignored.
+func parsePrometheus(buf []byte, fn func(s *sample, family, typ string)) error {
+	types := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	scanner.Buffer(nil, maxBodySize)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			field := strings.Fields(line)
+			if len(field) >= 4 && field[1] == "TYPE" {
+				types[field[2]] = field[3]
+			}
+			continue
+		}
+		s, err := parseSample(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		family, typ := familyOf(types, s.name)
+		fn(s, family, typ)
+	}
+	return scanner.Err()
+}
+
+// familyOf returns the name and type of the family the named sample belongs
+// to.
+func familyOf(types map[string]string, name string) (family, typ string) {
+	if typ, ok := types[name]; ok {
+		return name, typ
+	}
+	for suffix := range promSuffix {
+		family := strings.TrimSuffix(name, suffix)
+		if family == name {
+			continue
+		}
+		switch typ := types[family]; typ {
+		case "histogram":
+			return family, typ
+		case "summary":
+			if suffix != "_bucket" {
+				return family, typ
+			}
+		}
+	}
+	return name, "untyped"
+}
+
+func parseSample(line string) (*sample, error) {
+	s := new(sample)
+	i := strings.IndexAny(line, "{ \t")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid sample")
+	}
+	s.name, line = line[:i], line[i:]
+	if line[0] == '{' {
+		var err error
+		if s.labels, line, err = parseLabels(line[1:]); err != nil {
+			return nil, err
+		}
+	}
+	field := strings.Fields(line)
+	if len(field) == 0 || len(field) > 2 {
+		return nil, fmt.Errorf("invalid sample")
+	}
+	v, err := strconv.ParseFloat(field[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value: %s", field[0])
+	}
+	s.value = v
+	return s, nil
+}
+
+// parseLabels parses the labels following the opening brace, and returns
+// the rest of the line.
+func parseLabels(line string) (labels []string, rest string, err error) {
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return nil, "", fmt.Errorf("unterminated labels")
+		}
+		if line[0] == '}' {
+			return labels, line[1:], nil
+		}
+		i := strings.IndexByte(line, '=')
+		if i <= 0 || len(line) < i+2 || line[i+1] != '"' {
+			return nil, "", fmt.Errorf("invalid label")
+		}
+		key := strings.TrimSpace(line[:i])
+		line = line[i+2:]
+		var value []byte
+		for {
+			if line == "" {
+				return nil, "", fmt.Errorf("unterminated label value")
+			}
+			c := line[0]
+			line = line[1:]
+			if c == '"' {
+				break
+			}
+			if c == '\\' && line != "" {
+				c, line = line[0], line[1:]
+				if c == 'n' {
+					c = '\n'
+				}
+			}
+			value = append(value, c)
+		}
+		labels = append(labels, key, string(value))
+		line = strings.TrimLeft(line, " \t")
+		if line != "" && line[0] == ',' {
+			line = line[1:]
+		}
+	}
+}
+
+// promSuffix maps the sample name suffixes of histograms and summaries to
+// the suffixes of the exported metric names.
+var promSuffix = map[string]string{
+	"_bucket": ".bucket",
+	"_sum":    ".sum",
+	"_count":  ".count",
+}
+
+// exportPrometheus exports the samples of a Prometheus document. The
+// samples of histograms and summaries are exported under suffixed metric
+// names: name.bucket (tagged le), name.sum, name.count, and name.quantile
+// (tagged quantile). Labels become tags; samples with more labels than a
+// point may carry are skipped, and so are samples whose value is not a
+// number or infinite.
+func exportPrometheus(buf []byte, t time.Time, target *Target, export func(*tsdb.Point)) error {
+	var errs, dropped, hostLabels int
+	err := parsePrometheus(buf, func(s *sample, family, typ string) {
+		metric := s.name
+		switch typ {
+		case "histogram", "summary":
+			metric = family + ".quantile"
+			if suffix := s.name[len(family):]; suffix != "" {
+				metric = family + promSuffix[suffix]
+			}
+		}
+		if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			return
+		}
+		var keyval []string
+		for i := 0; i < len(s.labels); i += 2 {
+			key, value := s.labels[i], s.labels[i+1]
+			if value == "" || target.dropLabel[key] {
+				continue
+			}
+			if key == "host" {
+				// Replaced by the target host, see collect.
+				hostLabels++
+				continue
+			}
+			keyval = append(keyval, cleanName(key), cleanName(value))
+		}
+		if len(keyval)/2 > maxTags {
+			dropped++
+			if Debug != nil {
+				Debug.Printf("%s: %s: too many labels", target.URL, s.name)
+			}
+			return
+		}
+		var value interface{} = s.value
+		if s.value == math.Trunc(s.value) && math.Abs(s.value) < 1<<53 {
+			value = int64(s.value)
+		}
+		p, err := tsdb.NewPoint(t, value, cleanName(metric), keyval...)
+		if err != nil {
+			errs++
+			if Debug != nil {
+				Debug.Printf("%s: %s: %v", target.URL, s.name, err)
+			}
+			return
+		}
+		export(p)
+	})
+	if err != nil {
+		statErrors.Add("type=Decode", 1)
+		return err
+	}
+	if dropped > 0 {
+		statErrors.Add("type=TooManyTags", int64(dropped))
+	}
+	if errs > 0 {
+		statErrors.Add("type=Export", int64(errs))
+		return fmt.Errorf("%d samples not exported", errs)
+	}
+	if hostLabels > 0 {
+		statErrors.Add("type=HostLabel", int64(hostLabels))
+		return fmt.Errorf("%d samples have a host label, replaced by the target host; add host to DropLabel", hostLabels)
+	}
+	return nil
+}
+
+// cleanName replaces the characters of a Prometheus name or label value that
+// are not storable in the database by underscore. The sign of +Inf, the upper
+// bound of the last histogram bucket, is dropped.
+func cleanName(s string) string {
+	s = strings.TrimPrefix(s, "+")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_', r == '.', r == '/':
+			return r
+		}
+		return '_'
+	}, s)
+}
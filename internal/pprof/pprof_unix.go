@@ -29,6 +29,13 @@ import (
 	"path/filepath"
 )
 
+var mux = http.NewServeMux()
+
+// Handle registers an additional handler for the given pattern.
+func Handle(pattern string, handler http.Handler) {
+	mux.Handle(pattern, handler)
+}
+
 func init() {
 	s := &server{
 		path: listenPath(),
@@ -59,7 +66,6 @@ func (s *server) serve() {
 	}
 
 	// Export pprof.
-	mux := http.NewServeMux()
 	mux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
 	mux.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
 	mux.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
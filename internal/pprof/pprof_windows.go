@@ -4,4 +4,9 @@
 
 package pprof
 
+import "net/http"
+
 // XXX: implement pprof handler
+
+// Handle registers an additional handler for the given pattern.
+func Handle(pattern string, handler http.Handler) {}
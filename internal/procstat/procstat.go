@@ -0,0 +1,398 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+// Package procstat implements built-in collection of process-level metrics.
+//
+// Processes are selected by name and command line, and are reported in
+// groups: the series of all processes matching a group are summed, and
+// tagged with the group id. The cumulative counters, CPU time and I/O, also
+// include the processes that exited, so that they never decrease.
+package procstat
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"opentsp.org/internal/tsdb"
+)
+
+var Debug *log.Logger
+
+// DefaultInterval is the default interval between collections.
+const DefaultInterval = 10 * time.Second
+
+const maxInterval = 1 * time.Hour
+
+var statErrors = expvar.NewMap("procstat.Errors")
+
+// Config represents the collector settings.
+type Config struct {
+	Interval string `json:",omitempty"`
+
+	interval time.Duration
+
+	// root is the mount point of procfs.
+	root string
+}
+
+func (c *Config) Validate() error {
+	c.interval = DefaultInterval
+	if c.Interval == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(c.Interval)
+	if err != nil {
+		return fmt.Errorf("invalid Interval: %v", err)
+	}
+	if d < 1*time.Second || d > maxInterval {
+		return fmt.Errorf("Interval out of range: %v", d)
+	}
+	c.interval = d
+	return nil
+}
+
+// Group selects processes using regular expressions matched against process
+// name and command line. If both are set, both must match. The command line
+// arguments are joined using space.
+type Group struct {
+	ID      string
+	Name    string `json:",omitempty"`
+	Cmdline string `json:",omitempty"`
+
+	name, cmdline *regexp.Regexp
+}
+
+func (g *Group) Validate() error {
+	if g.ID == "" {
+		return fmt.Errorf("process group: missing ID")
+	}
+	if tsdb.Clean(g.ID) != g.ID {
+		return fmt.Errorf("process group %s: invalid ID", g.ID)
+	}
+	if g.Name == "" && g.Cmdline == "" {
+		return fmt.Errorf("process group %s: missing Name or Cmdline", g.ID)
+	}
+	var err error
+	if g.Name != "" {
+		if g.name, err = regexp.Compile(g.Name); err != nil {
+			return fmt.Errorf("process group %s: invalid Name: %v", g.ID, err)
+		}
+	}
+	if g.Cmdline != "" {
+		if g.cmdline, err = regexp.Compile(g.Cmdline); err != nil {
+			return fmt.Errorf("process group %s: invalid Cmdline: %v", g.ID, err)
+		}
+	}
+	return nil
+}
+
+func (g *Group) match(p *process) bool {
+	if g.name != nil && !g.name.MatchString(p.Name) {
+		return false
+	}
+	if g.cmdline != nil && !g.cmdline.MatchString(p.Cmdline) {
+		return false
+	}
+	return true
+}
+
+// Collect returns a tsdb.Chan that carries metrics of the given process
+// groups. If no groups are given, the channel is silent.
+func Collect(config *Config, groups []*Group) tsdb.Chan {
+	if err := config.Validate(); err != nil {
+		log.Panicf("internal error: %v", err)
+	}
+	for _, g := range groups {
+		if err := g.Validate(); err != nil {
+			log.Panicf("internal error: %v", err)
+		}
+	}
+	root := config.root
+	if root == "" {
+		root = "/proc"
+	}
+	ch := make(chan *tsdb.Point)
+	if len(groups) > 0 {
+		go loop(root, config.interval, groups, ch)
+	}
+	return ch
+}
+
+func loop(root string, interval time.Duration, groups []*Group, w chan<- *tsdb.Point) {
+	tick := tsdb.Tick(interval)
+	hist := make([]history, len(groups))
+	for {
+		now := <-tick
+		err := collect(root, now, groups, hist, func(p *tsdb.Point) {
+			w <- p
+		})
+		if err != nil {
+			statErrors.Add("type=Collect", 1)
+			log.Printf("procstat: %v", err)
+		}
+	}
+}
+
+// usage holds resource usage summed over a group of processes.
+type usage struct {
+	Count   int64
+	Threads int64
+	RSS     int64
+	VSize   int64
+	FDs     int64
+	counters
+}
+
+// counters holds the cumulative resource usage of a process.
+type counters struct {
+	UserTicks  int64
+	SysTicks   int64
+	ReadBytes  int64
+	WriteBytes int64
+}
+
+func (c *counters) add(x counters) {
+	c.UserTicks += x.UserTicks
+	c.SysTicks += x.SysTicks
+	c.ReadBytes += x.ReadBytes
+	c.WriteBytes += x.WriteBytes
+}
+
+// history keeps the counters of a group from decreasing when its processes
+// exit: the last counters seen of each process that is gone are carried over
+// to the following collections.
+type history struct {
+	live   map[procID]counters
+	exited counters
+}
+
+// procID identifies a process. The start time tells apart the processes
+// that reuse a pid.
+type procID struct {
+	pid   string
+	start int64
+}
+
+// update records the counters of the processes currently in the group, and
+// returns the counters of the processes that exited.
+func (h *history) update(live map[procID]counters) counters {
+	for id, c := range h.live {
+		if _, ok := live[id]; !ok {
+			h.exited.add(c)
+		}
+	}
+	h.live = live
+	return h.exited
+}
+
+// collect emits the series of the given groups. The history of each group
+// is updated.
+func collect(root string, t time.Time, groups []*Group, hist []history, emit func(*tsdb.Point)) error {
+	dir, err := os.Open(root)
+	if err != nil {
+		return err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return err
+	}
+	sum := make([]usage, len(groups))
+	live := make([]map[procID]counters, len(groups))
+	for i := range live {
+		live[i] = make(map[procID]counters)
+	}
+	for _, name := range names {
+		if _, err := strconv.Atoi(name); err != nil {
+			continue
+		}
+		p, err := readProcess(filepath.Join(root, name))
+		if err != nil {
+			// The process has likely exited.
+			if Debug != nil {
+				Debug.Printf("pid %s: %v", name, err)
+			}
+			continue
+		}
+		for i, g := range groups {
+			if g.match(p) {
+				sum[i].add(p)
+				live[i][procID{name, p.Start}] = p.counters
+			}
+		}
+	}
+	for i, g := range groups {
+		sum[i].counters.add(hist[i].update(live[i]))
+		if err := sum[i].emit(t, g.ID, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *usage) add(p *process) {
+	u.Count++
+	u.Threads += p.Threads
+	u.RSS += p.RSS
+	u.VSize += p.VSize
+	u.FDs += p.FDs
+	u.counters.add(p.counters)
+}
+
+func (u *usage) emit(t time.Time, id string, emit func(*tsdb.Point)) error {
+	for _, x := range []struct {
+		metric string
+		value  int64
+		tags   []string
+	}{
+		{"proc.process.count", u.Count, nil},
+		{"proc.process.threads", u.Threads, nil},
+		{"proc.process.cpu", u.UserTicks, []string{"type", "user"}},
+		{"proc.process.cpu", u.SysTicks, []string{"type", "system"}},
+		{"proc.process.mem.rss", u.RSS, nil},
+		{"proc.process.mem.vsize", u.VSize, nil},
+		{"proc.process.fd", u.FDs, nil},
+		{"proc.process.io.bytes", u.ReadBytes, []string{"direction", "read"}},
+		{"proc.process.io.bytes", u.WriteBytes, []string{"direction", "write"}},
+	} {
+		keyval := append([]string{"process", id}, x.tags...)
+		p, err := tsdb.NewPoint(t, x.value, x.metric, keyval...)
+		if err != nil {
+			return err
+		}
+		emit(p)
+	}
+	return nil
+}
+
+// process holds details of a single process.
+type process struct {
+	Name    string
+	Cmdline string
+	Start   int64 // clock ticks after boot
+	Threads int64
+	RSS     int64 // bytes
+	VSize   int64 // bytes
+	FDs     int64
+	counters
+}
+
+// readProcess reads details of the process, given its /proc/<pid> path.
+// The io and fd details require privileges, and are left zero if
+// unavailable.
+func readProcess(dir string) (*process, error) {
+	p := new(process)
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	if err := p.parseStat(string(buf)); err != nil {
+		return nil, err
+	}
+	buf, err = ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	p.Cmdline = string(bytes.TrimRight(bytes.Replace(buf, []byte{0}, []byte{' '}, -1), " "))
+	buf, err = ioutil.ReadFile(filepath.Join(dir, "status"))
+	if err != nil {
+		return nil, err
+	}
+	p.parseStatus(string(buf))
+	if buf, err := ioutil.ReadFile(filepath.Join(dir, "io")); err == nil {
+		p.parseIO(string(buf))
+	}
+	if fd, err := os.Open(filepath.Join(dir, "fd")); err == nil {
+		names, _ := fd.Readdirnames(-1)
+		fd.Close()
+		p.FDs = int64(len(names))
+	}
+	return p, nil
+}
+
+// parseStat parses /proc/<pid>/stat. The process name is enclosed in
+// parentheses, and may itself contain spaces and parentheses.
+func (p *process) parseStat(s string) error {
+	i := strings.IndexByte(s, '(')
+	j := strings.LastIndexByte(s, ')')
+	if i == -1 || j < i {
+		return fmt.Errorf("stat: invalid format")
+	}
+	p.Name = s[i+1 : j]
+	// Fields following the name, starting with field 3 (state).
+	field := strings.Fields(s[j+1:])
+	const (
+		utime      = 14 - 3
+		stime      = 15 - 3
+		numThreads = 20 - 3
+		startTime  = 22 - 3
+	)
+	if len(field) <= startTime {
+		return fmt.Errorf("stat: too few fields")
+	}
+	var err error
+	if p.UserTicks, err = strconv.ParseInt(field[utime], 10, 64); err != nil {
+		return fmt.Errorf("stat: %v", err)
+	}
+	if p.SysTicks, err = strconv.ParseInt(field[stime], 10, 64); err != nil {
+		return fmt.Errorf("stat: %v", err)
+	}
+	if p.Threads, err = strconv.ParseInt(field[numThreads], 10, 64); err != nil {
+		return fmt.Errorf("stat: %v", err)
+	}
+	if p.Start, err = strconv.ParseInt(field[startTime], 10, 64); err != nil {
+		return fmt.Errorf("stat: %v", err)
+	}
+	return nil
+}
+
+// parseStatus parses /proc/<pid>/status. Kernel threads lack the memory
+// details.
+func (p *process) parseStatus(s string) {
+	for _, line := range strings.Split(s, "\n") {
+		field := strings.Fields(line)
+		if len(field) < 2 {
+			continue
+		}
+		n, err := strconv.ParseInt(field[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch field[0] {
+		case "VmRSS:":
+			p.RSS = n * 1024
+		case "VmSize:":
+			p.VSize = n * 1024
+		}
+	}
+}
+
+// parseIO parses /proc/<pid>/io.
+func (p *process) parseIO(s string) {
+	for _, line := range strings.Split(s, "\n") {
+		field := strings.Fields(line)
+		if len(field) != 2 {
+			continue
+		}
+		n, err := strconv.ParseInt(field[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch field[0] {
+		case "read_bytes:":
+			p.ReadBytes = n
+		case "write_bytes:":
+			p.WriteBytes = n
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package procstat
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"opentsp.org/internal/tsdb"
+)
+
+// testProc is a fixture procfs tree.
+var testProc = map[string]string{
+	"100/stat":    "100 (java) S 1 100 100 0 -1 4202752 0 0 0 0 250 50 0 0 20 0 42 0 100 4096000 1000 18446744073709551615\n",
+	"100/cmdline": "/usr/bin/java\x00-Dapp=foo\x00-jar\x00foo.jar\x00",
+	"100/status":  "Name:\tjava\nVmSize:\t    4000 kB\nVmRSS:\t    1000 kB\nThreads:\t42\n",
+	"100/io":      "rchar: 500\nwchar: 600\nread_bytes: 4096\nwrite_bytes: 8192\n",
+	"100/fd/0":    "",
+	"100/fd/1":    "",
+	"200/stat":    "200 (java) S 1 200 200 0 -1 4202752 0 0 0 0 10 5 0 0 20 0 8 0 100 2048000 500 18446744073709551615\n",
+	"200/cmdline": "/usr/bin/java\x00-Dapp=bar\x00",
+	"200/status":  "Name:\tjava\nVmSize:\t    2000 kB\nVmRSS:\t     500 kB\nThreads:\t8\n",
+	"300/stat":    "300 (my (odd) proc) R 1 300 300 0 -1 4202752 0 0 0 0 1 2 0 0 20 0 1 0 100 0 0 18446744073709551615\n",
+	"300/cmdline": "",
+	"300/status":  "Name:\tmy (odd) proc\nThreads:\t1\n",
+	"self/stat":   "",
+}
+
+func writeTestProc(t *testing.T) string {
+	root, err := ioutil.TempDir("", "procstattest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range testProc {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+var testCollect = []struct {
+	group *Group
+	want  []string
+}{
+	{
+		group: &Group{ID: "java", Name: "^java$"},
+		want: []string{
+			`proc.process.count 0 2 process=java`,
+			`proc.process.cpu 0 260 process=java type=user`,
+			`proc.process.cpu 0 55 process=java type=system`,
+			`proc.process.fd 0 2 process=java`,
+			`proc.process.io.bytes 0 4096 process=java direction=read`,
+			`proc.process.io.bytes 0 8192 process=java direction=write`,
+			`proc.process.mem.rss 0 1536000 process=java`,
+			`proc.process.mem.vsize 0 6144000 process=java`,
+			`proc.process.threads 0 50 process=java`,
+		},
+	},
+	{
+		group: &Group{ID: "foo.app", Name: "^java$", Cmdline: "-Dapp=foo "},
+		want: []string{
+			`proc.process.count 0 1 process=foo.app`,
+			`proc.process.cpu 0 250 process=foo.app type=user`,
+			`proc.process.cpu 0 50 process=foo.app type=system`,
+			`proc.process.fd 0 2 process=foo.app`,
+			`proc.process.io.bytes 0 4096 process=foo.app direction=read`,
+			`proc.process.io.bytes 0 8192 process=foo.app direction=write`,
+			`proc.process.mem.rss 0 1024000 process=foo.app`,
+			`proc.process.mem.vsize 0 4096000 process=foo.app`,
+			`proc.process.threads 0 42 process=foo.app`,
+		},
+	},
+	{
+		group: &Group{ID: "odd", Name: `^my \(odd\) proc$`},
+		want: []string{
+			`proc.process.count 0 1 process=odd`,
+			`proc.process.cpu 0 1 process=odd type=user`,
+			`proc.process.cpu 0 2 process=odd type=system`,
+			`proc.process.fd 0 0 process=odd`,
+			`proc.process.io.bytes 0 0 process=odd direction=read`,
+			`proc.process.io.bytes 0 0 process=odd direction=write`,
+			`proc.process.mem.rss 0 0 process=odd`,
+			`proc.process.mem.vsize 0 0 process=odd`,
+			`proc.process.threads 0 1 process=odd`,
+		},
+	},
+	{
+		group: &Group{ID: "none", Cmdline: "nosuchprocess"},
+		want: []string{
+			`proc.process.count 0 0 process=none`,
+			`proc.process.cpu 0 0 process=none type=system`,
+			`proc.process.cpu 0 0 process=none type=user`,
+			`proc.process.fd 0 0 process=none`,
+			`proc.process.io.bytes 0 0 process=none direction=read`,
+			`proc.process.io.bytes 0 0 process=none direction=write`,
+			`proc.process.mem.rss 0 0 process=none`,
+			`proc.process.mem.vsize 0 0 process=none`,
+			`proc.process.threads 0 0 process=none`,
+		},
+	},
+}
+
+func TestCollect(t *testing.T) {
+	root := writeTestProc(t)
+	defer os.RemoveAll(root)
+	for i, tt := range testCollect {
+		if err := tt.group.Validate(); err != nil {
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		}
+		var got []string
+		err := collect(root, time.Unix(0, 0), []*Group{tt.group}, make([]history, 1), func(p *tsdb.Point) {
+			got = append(got, encode(t, p))
+		})
+		if err != nil {
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d. invalid output\ngot:  %q\nwant: %q", i, got, tt.want)
+		}
+	}
+}
+
+// TestCollectExited checks that the counters of a group do not decrease when
+// its processes exit, or when a pid is reused.
+func TestCollectExited(t *testing.T) {
+	root := writeTestProc(t)
+	defer os.RemoveAll(root)
+	groups := []*Group{{ID: "java", Name: "^java$"}}
+	if err := groups[0].Validate(); err != nil {
+		t.Fatal(err)
+	}
+	hist := make([]history, len(groups))
+	cpu := func() []string {
+		var got []string
+		err := collect(root, time.Unix(0, 0), groups, hist, func(p *tsdb.Point) {
+			if line := encode(t, p); strings.HasPrefix(line, "proc.process.cpu ") {
+				got = append(got, line)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		return got
+	}
+	var tests = []struct {
+		stat string // of pid 200, or empty if exited
+		want []string
+	}{
+		{
+			stat: testProc["200/stat"],
+			want: []string{
+				`proc.process.cpu 0 260 process=java type=user`,
+				`proc.process.cpu 0 55 process=java type=system`,
+			},
+		},
+		{
+			stat: "",
+			want: []string{
+				`proc.process.cpu 0 260 process=java type=user`,
+				`proc.process.cpu 0 55 process=java type=system`,
+			},
+		},
+		{
+			stat: "200 (java) S 1 200 200 0 -1 4202752 0 0 0 0 1 1 0 0 20 0 8 0 900 2048000 500 18446744073709551615\n",
+			want: []string{
+				`proc.process.cpu 0 261 process=java type=user`,
+				`proc.process.cpu 0 56 process=java type=system`,
+			},
+		},
+	}
+	for i, tt := range tests {
+		dir := filepath.Join(root, "200")
+		if tt.stat == "" {
+			if err := os.RemoveAll(dir); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			for name, content := range map[string]string{
+				"stat":    tt.stat,
+				"cmdline": testProc["200/cmdline"],
+				"status":  testProc["200/status"],
+			} {
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if got := cpu(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d. invalid output\ngot:  %q\nwant: %q", i, got, tt.want)
+		}
+	}
+}
+
+var testValidate = []struct {
+	in  *Group
+	err string
+}{
+	{&Group{ID: "foo", Name: "foo"}, ""},
+	{&Group{ID: "foo", Cmdline: "foo"}, ""},
+	{&Group{Name: "foo"}, "process group: missing ID"},
+	{&Group{ID: "foo bar", Name: "foo"}, "process group foo bar: invalid ID"},
+	{&Group{ID: "foo"}, "process group foo: missing Name or Cmdline"},
+	{&Group{ID: "foo", Name: "("}, "process group foo: invalid Name"},
+	{&Group{ID: "foo", Cmdline: "("}, "process group foo: invalid Cmdline"},
+}
+
+func TestValidate(t *testing.T) {
+	for i, tt := range testValidate {
+		err := tt.in.Validate()
+		switch {
+		case err == nil && tt.err != "":
+			t.Errorf("#%d. unexpected success, want error: %v", i, tt.err)
+		case err != nil && tt.err == "":
+			t.Errorf("#%d. unexpected error: %v", i, err)
+		case err != nil && !strings.HasPrefix(err.Error(), tt.err):
+			t.Errorf("#%d. invalid error, got: %s, want: %s", i, err, tt.err)
+		}
+	}
+}
+
+func encode(t *testing.T, p *tsdb.Point) string {
+	var buf bytes.Buffer
+	if err := tsdb.NewEncoder(&buf).Encode(p); err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
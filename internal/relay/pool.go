@@ -1,38 +1,75 @@
 package relay
 
 import (
+	"fmt"
 	"log"
+	"sync"
 
 	"opentsp.org/internal/tsdb"
 )
 
 // Pool represents a pool of relay connections.
 type Pool struct {
-	relays []*Relay
+	mu     sync.Mutex
+	relays map[string]*Relay
 	series tsdb.Series
 }
 
 // NewPool creates a pool of relays connections.
 func NewPool(configs map[string]*Config, series tsdb.Series) *Pool {
-	var relays []*Relay
+	relays := make(map[string]*Relay)
 	for name, cfg := range configs {
 		relay, err := NewRelay(name, cfg)
 		if err != nil {
 			log.Panicf("internal error: %v", err)
 		}
-		relays = append(relays, relay)
+		relays[name] = relay
 	}
-	pool := &Pool{relays, series}
+	pool := &Pool{relays: relays, series: series}
 	return pool
 }
 
+// Update replaces the relay definitions. Relays whose config is unchanged
+// continue unaffected, retaining their queues and connections. Relays that
+// are removed or redefined are closed.
+func (p *Pool) Update(configs map[string]*Config) error {
+	for name, cfg := range configs {
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("relay %s: %v", name, err)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, old := range p.relays {
+		if cfg := configs[name]; cfg == nil || !old.equal(cfg) {
+			old.Close()
+			delete(p.relays, name)
+			log.Printf("relay %s: stopped", name)
+		}
+	}
+	for name, cfg := range configs {
+		if p.relays[name] != nil {
+			continue
+		}
+		relay, err := NewRelay(name, cfg)
+		if err != nil {
+			return err
+		}
+		p.relays[name] = relay
+		log.Printf("relay %s: started", name)
+	}
+	return nil
+}
+
 // Broadcast broadcasts received data points to all relays.
 func (p *Pool) Broadcast() {
 	for {
 		point := p.series.Next()
+		p.mu.Lock()
 		for _, relay := range p.relays {
 			relay.Submit(point)
 		}
+		p.mu.Unlock()
 		point.Free()
 	}
 }
@@ -12,9 +12,11 @@ import (
 	"log"
 	"net"
 	"os"
+	"reflect"
 	"sync"
 
 	"opentsp.org/internal/tsdb"
+	"opentsp.org/internal/tsdb/filter"
 )
 
 const (
@@ -25,11 +27,13 @@ const (
 var (
 	statRelayCurrEstab = expvar.NewMap("relay.CurrEstab")
 	statRelayErrors    = expvar.NewMap("relay.Errors")
+	statRelayFiltered  = expvar.NewMap("relay.Filtered")
 	statRelayQueue     = expvar.NewMap("relay.Queue")
 )
 
 type Config struct {
 	DropRepeats     bool
+	Filter          []filter.Rule
 	Host            string
 	MaxConnsPerHost *int
 	OnQueueFull     string
@@ -47,6 +51,17 @@ func (c *Config) Validate() error {
 	case oqfDrop, oqfDropAndLog:
 		// ok
 	}
+	if len(c.Filter) > 0 {
+		// The point is shared by all relays, so it may not be rewritten.
+		for _, r := range c.Filter {
+			if len(r.Set) > 0 {
+				return fmt.Errorf("invalid Filter: Set not supported, rule=%v", r)
+			}
+		}
+		if _, err := filter.New(c.Filter...); err != nil {
+			return fmt.Errorf("invalid Filter: %v", err)
+		}
+	}
 	switch max, defaultMax := c.MaxConnsPerHost, 1; {
 	default:
 		return fmt.Errorf("MaxConnsPerHost out of range: %d", *max)
@@ -61,6 +76,8 @@ func (c *Config) Validate() error {
 type Relay struct {
 	name   string
 	host   string
+	config Config
+	filter *filter.Filter // nil if all points are sent
 	drop   func([]byte)
 	client *tsdb.Client
 }
@@ -71,8 +88,12 @@ func NewRelay(name string, config *Config) (*Relay, error) {
 		return nil, fmt.Errorf("relay %s: %v", name, err)
 	}
 	r := &Relay{
-		name: name,
-		host: config.Host,
+		name:   name,
+		host:   config.Host,
+		config: *config,
+	}
+	if len(config.Filter) > 0 {
+		r.filter, _ = filter.New(config.Filter...)
 	}
 	r.drop = drop(name)
 	if config.OnQueueFull == oqfDropAndLog {
@@ -91,12 +112,35 @@ func NewRelay(name string, config *Config) (*Relay, error) {
 	return r, nil
 }
 
-// Submit submits the given point to the relay. It does not block in network calls
-// to the relay host. Not safe for concurrent use.
+// Submit submits the given point to the relay, unless it is rejected by the
+// relay filter. It does not block in network calls to the relay host. Not
+// safe for concurrent use.
 func (r *Relay) Submit(point *tsdb.Point) {
+	if r.filter != nil {
+		if pass, err := r.filter.Eval(point); !pass || err != nil {
+			statRelayFiltered.Add("relay="+r.name, 1)
+			return
+		}
+	}
 	r.client.Put(point)
 }
 
+// Close shuts down the relay. Queued points are flushed on a best-effort
+// basis. Submit must not be called after Close.
+func (r *Relay) Close() {
+	r.client.Close()
+	statRelayQueue.Delete("relay=" + r.name)
+}
+
+// equal reports whether the relay was created using the given config.
+func (r *Relay) equal(config *Config) bool {
+	return r.config.DropRepeats == config.DropRepeats &&
+		reflect.DeepEqual(r.config.Filter, config.Filter) &&
+		r.config.Host == config.Host &&
+		*r.config.MaxConnsPerHost == *config.MaxConnsPerHost &&
+		r.config.OnQueueFull == config.OnQueueFull
+}
+
 type dialFunc func(string) (net.Conn, error)
 
 func dial(name string, fn dialFunc) dialFunc {
@@ -0,0 +1,265 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package snmp
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"time"
+)
+
+// timeWindow is the accepted difference between the engine time of a
+// message and the agent's clock, in seconds.
+const timeWindow = 150
+
+// Agent is an agent serving a fixed set of variables. It implements the
+// read-only subset of the protocol, and is meant for simulating devices in
+// tests.
+type Agent struct {
+	// Community enables v2c requests using the given community.
+	Community string
+
+	// User enables v3 requests by the given user.
+	User *User
+
+	// EngineID is the v3 engine id. If nil, a default id is used.
+	EngineID []byte
+
+	// Vars are the served variables.
+	Vars []*Variable
+
+	conn  net.PacketConn
+	keys  *usmKeys
+	start time.Time
+}
+
+// Listen starts serving requests received on the given UDP address.
+func (a *Agent) Listen(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	a.conn = conn
+	a.start = time.Now()
+	sort.Slice(a.Vars, func(i, j int) bool {
+		return a.Vars[i].OID.Compare(a.Vars[j].OID) < 0
+	})
+	if a.User != nil {
+		if a.EngineID == nil {
+			a.EngineID = []byte{0x80, 0, 0x1f, 0x88, 0x04, 't', 's', 'p'}
+		}
+		a.keys = a.User.localize(a.EngineID)
+	}
+	go a.serve()
+	return nil
+}
+
+// Addr returns the address the agent is listening on.
+func (a *Agent) Addr() string {
+	return a.conn.LocalAddr().String()
+}
+
+// Close stops the agent.
+func (a *Agent) Close() error {
+	return a.conn.Close()
+}
+
+func (a *Agent) serve() {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := a.handle(buf[:n]); resp != nil {
+			a.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// handle returns the response to the given message, or nil if the message
+// is dropped.
+func (a *Agent) handle(msg []byte) []byte {
+	version, err := messageVersion(msg)
+	if err != nil {
+		return nil
+	}
+	switch version {
+	case version2c:
+		if a.Community == "" {
+			return nil
+		}
+		community, p, err := parseCommunityMessage(msg)
+		if err != nil || community != a.Community {
+			return nil
+		}
+		resp, err := appendCommunityMessage(nil, community, a.respond(p))
+		if err != nil {
+			return nil
+		}
+		return resp
+	case version3:
+		if a.User == nil {
+			return nil
+		}
+		return a.handleV3(msg)
+	}
+	return nil
+}
+
+func (a *Agent) handleV3(msg []byte) []byte {
+	m, err := parseV3Message(msg, a.lookup)
+	if m == nil {
+		return nil
+	}
+	var stat OID
+	switch {
+	case err == errUnknownEngine:
+		stat = usmStatsUnknownEngineIDs
+	case err == errUnknownUser:
+		stat = usmStatsUnknownUserNames
+	case err == errWrongDigest:
+		stat = usmStatsWrongDigests
+	case err == errDecryption:
+		stat = usmStatsDecryptionErrors
+	case err != nil:
+		return nil
+	case !bytes.Equal(m.engineID, a.EngineID):
+		stat = usmStatsUnknownEngineIDs
+	case m.user != a.User.Name:
+		stat = usmStatsUnknownUserNames
+	case m.flags&(flagAuth|flagPriv) != a.User.flags():
+		stat = usmStatsUnsupportedSecLevels
+	case m.flags&flagAuth != 0 && !a.inTimeWindow(m.boots, m.time):
+		stat = usmStatsNotInTimeWindows
+	}
+	resp := &v3Message{
+		msgID:           m.msgID,
+		maxSize:         maxMessageSize,
+		engineID:        a.EngineID,
+		boots:           a.boots(),
+		time:            a.time(),
+		user:            m.user,
+		contextEngineID: a.EngineID,
+	}
+	if stat == nil {
+		resp.flags = m.flags &^ flagReportable
+		resp.pdu = a.respond(m.pdu)
+	} else {
+		if m.flags&flagReportable == 0 {
+			return nil
+		}
+		// Only the time window report is authenticated, allowing the
+		// manager to trust the engine time it carries.
+		if stat.Compare(usmStatsNotInTimeWindows) == 0 {
+			resp.flags = flagAuth
+		}
+		var requestID int32
+		if m.pdu != nil {
+			requestID = m.pdu.requestID
+		}
+		resp.pdu = &pdu{
+			typ:       report,
+			requestID: requestID,
+			vars:      []*Variable{{OID: stat, Type: Counter32, Value: uint64(1)}},
+		}
+	}
+	b, err := resp.append(nil, a.keys)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (a *Agent) lookup(engineID []byte, user string) (*usmKeys, error) {
+	if !bytes.Equal(engineID, a.EngineID) {
+		return nil, errUnknownEngine
+	}
+	if user != a.User.Name {
+		return nil, errUnknownUser
+	}
+	return a.keys, nil
+}
+
+func (a *Agent) boots() int32 {
+	return 1
+}
+
+func (a *Agent) time() int32 {
+	return int32(time.Since(a.start) / time.Second)
+}
+
+func (a *Agent) inTimeWindow(boots, t int32) bool {
+	d := t - a.time()
+	return boots == a.boots() && -timeWindow <= d && d <= timeWindow
+}
+
+// respond returns the response to the given request.
+func (a *Agent) respond(p *pdu) *pdu {
+	resp := &pdu{typ: getResponse, requestID: p.requestID}
+	switch p.typ {
+	case getRequest:
+		for _, v := range p.vars {
+			resp.vars = append(resp.vars, a.get(v.OID))
+		}
+	case getNextRequest:
+		for _, v := range p.vars {
+			resp.vars = append(resp.vars, a.next(v.OID))
+		}
+	case getBulkRequest:
+		nonRepeaters, maxRepetitions := p.errorStatus, p.errorIndex
+		if nonRepeaters < 0 {
+			nonRepeaters = 0
+		}
+		if nonRepeaters > len(p.vars) {
+			nonRepeaters = len(p.vars)
+		}
+		for _, v := range p.vars[:nonRepeaters] {
+			resp.vars = append(resp.vars, a.next(v.OID))
+		}
+		last := p.vars[nonRepeaters:]
+		for i := 0; i < maxRepetitions && len(last) > 0; i++ {
+			row := make([]*Variable, len(last))
+			done := true
+			for j, v := range last {
+				row[j] = a.next(v.OID)
+				if row[j].Type != EndOfMibView {
+					done = false
+				}
+			}
+			resp.vars = append(resp.vars, row...)
+			if done {
+				break
+			}
+			last = row
+		}
+	default:
+		resp.errorStatus = 5 // genErr
+	}
+	return resp
+}
+
+// get returns the variable of the given OID.
+func (a *Agent) get(oid OID) *Variable {
+	i := sort.Search(len(a.Vars), func(i int) bool {
+		return a.Vars[i].OID.Compare(oid) >= 0
+	})
+	if i < len(a.Vars) && a.Vars[i].OID.Compare(oid) == 0 {
+		return a.Vars[i]
+	}
+	return &Variable{OID: oid, Type: NoSuchObject}
+}
+
+// next returns the variable following the given OID.
+func (a *Agent) next(oid OID) *Variable {
+	i := sort.Search(len(a.Vars), func(i int) bool {
+		return a.Vars[i].OID.Compare(oid) > 0
+	})
+	if i < len(a.Vars) {
+		return a.Vars[i]
+	}
+	return &Variable{OID: oid, Type: EndOfMibView}
+}
@@ -0,0 +1,301 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER type tags used by SNMP.
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+
+	tagIPAddress = 0x40
+	tagCounter32 = 0x41
+	tagGauge32   = 0x42
+	tagTimeTicks = 0x43
+	tagOpaque    = 0x44
+	tagCounter64 = 0x46
+
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+)
+
+var errTruncated = errors.New("snmp: truncated message")
+
+// appendLength appends the BER encoding of a length.
+func appendLength(b []byte, n int) []byte {
+	if n < 0x80 {
+		return append(b, byte(n))
+	}
+	var tmp [8]byte
+	i := len(tmp)
+	for ; n > 0; n >>= 8 {
+		i--
+		tmp[i] = byte(n)
+	}
+	b = append(b, 0x80|byte(len(tmp)-i))
+	return append(b, tmp[i:]...)
+}
+
+// appendTLV appends a type-length-value triple.
+func appendTLV(b []byte, tag byte, value []byte) []byte {
+	b = append(b, tag)
+	b = appendLength(b, len(value))
+	return append(b, value...)
+}
+
+// appendInt appends a signed integer using the given tag.
+func appendInt(b []byte, tag byte, n int64) []byte {
+	var tmp [9]byte
+	i := len(tmp)
+	for {
+		i--
+		tmp[i] = byte(n)
+		n >>= 8
+		if (n == 0 && tmp[i]&0x80 == 0) || (n == -1 && tmp[i]&0x80 != 0) {
+			break
+		}
+	}
+	return appendTLV(b, tag, tmp[i:])
+}
+
+// appendUint appends an unsigned integer using the given tag.
+func appendUint(b []byte, tag byte, n uint64) []byte {
+	var tmp [9]byte
+	i := len(tmp)
+	for {
+		i--
+		tmp[i] = byte(n)
+		n >>= 8
+		if n == 0 {
+			break
+		}
+	}
+	if tmp[i]&0x80 != 0 {
+		i--
+		tmp[i] = 0
+	}
+	return appendTLV(b, tag, tmp[i:])
+}
+
+// appendOID appends an object identifier.
+func appendOID(b []byte, oid OID) []byte {
+	var value []byte
+	switch len(oid) {
+	case 0:
+		value = []byte{0}
+	case 1:
+		value = appendBase128(nil, oid[0]*40)
+	default:
+		value = appendBase128(nil, oid[0]*40+oid[1])
+		for _, n := range oid[2:] {
+			value = appendBase128(value, n)
+		}
+	}
+	return appendTLV(b, tagOID, value)
+}
+
+func appendBase128(b []byte, n uint32) []byte {
+	var tmp [5]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(n & 0x7f)
+	for n >>= 7; n > 0; n >>= 7 {
+		i--
+		tmp[i] = byte(n&0x7f) | 0x80
+	}
+	return append(b, tmp[i:]...)
+}
+
+// decoder reads BER-encoded values from a buffer.
+type decoder struct {
+	buf []byte
+}
+
+// next reads a type-length-value triple.
+func (d *decoder) next() (tag byte, value []byte, err error) {
+	b := d.buf
+	if len(b) < 2 {
+		return 0, nil, errTruncated
+	}
+	tag = b[0]
+	n := int(b[1])
+	b = b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < size {
+			return 0, nil, fmt.Errorf("snmp: invalid length")
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if n < 0 || len(b) < n {
+		return 0, nil, errTruncated
+	}
+	d.buf = b[n:]
+	return tag, b[:n], nil
+}
+
+// expect reads a value of the given type.
+func (d *decoder) expect(want byte) ([]byte, error) {
+	tag, value, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	if tag != want {
+		return nil, fmt.Errorf("snmp: unexpected type 0x%02x, want 0x%02x", tag, want)
+	}
+	return value, nil
+}
+
+// sequence reads a sequence, returning a decoder of its elements.
+func (d *decoder) sequence() (*decoder, error) {
+	value, err := d.expect(tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	return &decoder{value}, nil
+}
+
+func (d *decoder) int() (int64, error) {
+	value, err := d.expect(tagInteger)
+	if err != nil {
+		return 0, err
+	}
+	return parseInt(value)
+}
+
+func (d *decoder) octetString() ([]byte, error) {
+	return d.expect(tagOctetString)
+}
+
+func parseInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, fmt.Errorf("snmp: invalid integer")
+	}
+	n := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+func parseUint(b []byte) (uint64, error) {
+	if len(b) == 0 || len(b) > 9 || (len(b) == 9 && b[0] != 0) {
+		return 0, fmt.Errorf("snmp: invalid unsigned integer")
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func parseOID(b []byte) (OID, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("snmp: invalid object identifier")
+	}
+	var oid OID
+	var n uint32
+	for i, c := range b {
+		if n > 1<<25 {
+			return nil, fmt.Errorf("snmp: invalid object identifier")
+		}
+		n = n<<7 | uint32(c&0x7f)
+		if c&0x80 != 0 {
+			if i == len(b)-1 {
+				return nil, fmt.Errorf("snmp: invalid object identifier")
+			}
+			continue
+		}
+		if oid == nil {
+			if n < 80 {
+				oid = OID{n / 40, n % 40}
+			} else {
+				oid = OID{2, n - 80}
+			}
+		} else {
+			oid = append(oid, n)
+		}
+		n = 0
+	}
+	return oid, nil
+}
+
+// OID is an object identifier.
+type OID []uint32
+
+// ParseOID parses the dotted form of an object identifier, for example
+// "1.3.6.1.2.1.1.3.0". A leading dot is allowed.
+func ParseOID(s string) (OID, error) {
+	s = strings.TrimPrefix(s, ".")
+	if s == "" {
+		return nil, fmt.Errorf("snmp: invalid object identifier: empty")
+	}
+	var oid OID
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("snmp: invalid object identifier: %s", s)
+		}
+		oid = append(oid, uint32(n))
+	}
+	if len(oid) < 2 || oid[0] > 2 || (oid[0] < 2 && oid[1] >= 40) {
+		return nil, fmt.Errorf("snmp: invalid object identifier: %s", s)
+	}
+	return oid, nil
+}
+
+func (oid OID) String() string {
+	parts := make([]string, len(oid))
+	for i, n := range oid {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// HasPrefix reports whether oid lies within the subtree rooted at prefix.
+func (oid OID) HasPrefix(prefix OID) bool {
+	if len(oid) < len(prefix) {
+		return false
+	}
+	for i, n := range prefix {
+		if oid[i] != n {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare returns -1, 0, or 1 if oid sorts before, equal to, or after other
+// in lexicographical order.
+func (oid OID) Compare(other OID) int {
+	for i := 0; i < len(oid) && i < len(other); i++ {
+		switch {
+		case oid[i] < other[i]:
+			return -1
+		case oid[i] > other[i]:
+			return 1
+		}
+	}
+	switch {
+	case len(oid) < len(other):
+		return -1
+	case len(oid) > len(other):
+		return 1
+	}
+	return 0
+}
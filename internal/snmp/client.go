@@ -0,0 +1,321 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+// Package snmp implements the manager side of SNMP v2c and v3, limited to
+// the retrieval of variables. The user-based security model supports MD5
+// and SHA authentication, and DES and AES privacy.
+package snmp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Default client settings.
+const (
+	DefaultPort    = "161"
+	DefaultTimeout = 5 * time.Second
+)
+
+// maxRepetitions is the number of rows requested by each GetBulk request.
+const maxRepetitions = 20
+
+// ErrTimeout is returned if the agent fails to respond.
+var ErrTimeout = errors.New("snmp: request timed out")
+
+// ClientConfig represents the settings of a Client.
+type ClientConfig struct {
+	// Community is the v2c community. It is ignored if User is set.
+	Community string
+
+	// User selects v3 and holds the credentials.
+	User *User
+
+	// Timeout is the time to wait for a response. Zero selects
+	// DefaultTimeout.
+	Timeout time.Duration
+
+	// Retries is the number of times a request is resent on timeout.
+	Retries int
+}
+
+// Client is an SNMP manager connected to a single agent. It is not safe for
+// concurrent use.
+type Client struct {
+	conn      net.Conn
+	config    ClientConfig
+	buf       []byte
+	requestID int32
+
+	// v3 state, set by discovery.
+	engineID []byte
+	keys     *usmKeys
+	boots    int32
+	time     int32
+	synced   time.Time
+}
+
+// Dial returns a client of the agent at the given address. The port defaults
+// to DefaultPort.
+func Dial(addr string, config *ClientConfig) (*Client, error) {
+	if config.User != nil {
+		if err := config.User.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultPort)
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:   conn,
+		config: *config,
+		buf:    make([]byte, maxMessageSize),
+	}
+	if c.config.Timeout == 0 {
+		c.config.Timeout = DefaultTimeout
+	}
+	var b [4]byte
+	rand.Read(b[:])
+	c.requestID = int32(binary.BigEndian.Uint32(b[:]) >> 1)
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Get returns the variables of the given OIDs. Missing variables are
+// returned with an exception type.
+func (c *Client) Get(oids ...OID) ([]*Variable, error) {
+	p := &pdu{typ: getRequest}
+	for _, oid := range oids {
+		p.vars = append(p.vars, &Variable{OID: oid, Type: Null})
+	}
+	resp, err := c.roundTrip(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.vars) != len(oids) {
+		return nil, fmt.Errorf("snmp: got %d variables, want %d", len(resp.vars), len(oids))
+	}
+	return resp.vars, nil
+}
+
+// Walk calls fn for each variable in the subtree rooted at root, in order.
+// It stops at the first error returned by fn.
+func (c *Client) Walk(root OID, fn func(*Variable) error) error {
+	last := root
+	for {
+		resp, err := c.roundTrip(&pdu{
+			typ:        getBulkRequest,
+			errorIndex: maxRepetitions,
+			vars:       []*Variable{{OID: last, Type: Null}},
+		})
+		if err != nil {
+			return err
+		}
+		if len(resp.vars) == 0 {
+			return nil
+		}
+		for _, v := range resp.vars {
+			if v.Type == EndOfMibView || !v.OID.HasPrefix(root) {
+				return nil
+			}
+			if v.OID.Compare(last) <= 0 {
+				return fmt.Errorf("snmp: walk %s: OID not increasing: %s", root, v.OID)
+			}
+			if err := fn(v); err != nil {
+				return err
+			}
+			last = v.OID
+		}
+	}
+}
+
+// roundTrip sends the request and returns the response.
+func (c *Client) roundTrip(p *pdu) (*pdu, error) {
+	var resp *pdu
+	var err error
+	if c.config.User == nil {
+		resp, err = c.roundTripV2c(p)
+	} else {
+		resp, err = c.roundTripV3(p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.errorStatus != 0 {
+		return nil, &Error{Status: resp.errorStatus, Index: resp.errorIndex}
+	}
+	return resp, nil
+}
+
+func (c *Client) roundTripV2c(p *pdu) (*pdu, error) {
+	c.requestID++
+	p.requestID = c.requestID
+	req, err := appendCommunityMessage(nil, c.config.Community, p)
+	if err != nil {
+		return nil, err
+	}
+	var resp *pdu
+	err = c.exchange(req, func(b []byte) bool {
+		_, r, err := parseCommunityMessage(b)
+		if err != nil || r.typ != getResponse || r.requestID != p.requestID {
+			return false
+		}
+		resp = r
+		return true
+	})
+	return resp, err
+}
+
+func (c *Client) roundTripV3(p *pdu) (*pdu, error) {
+	if c.engineID == nil {
+		if err := c.discover(); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.sendV3(p, c.config.User.flags()|flagReportable)
+	switch err {
+	case errTimeWindow:
+		// The report has updated the engine time; retry once.
+		resp, err = c.sendV3(p, c.config.User.flags()|flagReportable)
+	case errUnknownEngine:
+		// The agent has changed its engine id; rediscover and retry once.
+		if err = c.discover(); err == nil {
+			resp, err = c.sendV3(p, c.config.User.flags()|flagReportable)
+		}
+	}
+	return resp, err
+}
+
+// discover learns the engine id, boots, and time of the agent, and
+// localizes the user's keys.
+func (c *Client) discover() error {
+	c.engineID, c.keys = nil, nil
+	c.boots, c.time = 0, 0
+	_, err := c.sendV3(&pdu{typ: getRequest}, flagReportable)
+	if err != nil && err != errUnknownEngine {
+		return err
+	}
+	if len(c.engineID) == 0 {
+		return fmt.Errorf("snmp: engine discovery failed")
+	}
+	c.keys = c.config.User.localize(c.engineID)
+	return nil
+}
+
+func (c *Client) sendV3(p *pdu, flags byte) (*pdu, error) {
+	c.requestID++
+	p.requestID = c.requestID
+	m := &v3Message{
+		msgID:           p.requestID,
+		maxSize:         maxMessageSize,
+		flags:           flags,
+		engineID:        c.engineID,
+		boots:           c.boots,
+		time:            c.engineTime(),
+		contextEngineID: c.engineID,
+		pdu:             p,
+	}
+	discovery := c.keys == nil
+	if !discovery {
+		m.user = c.config.User.Name
+	}
+	req, err := m.append(nil, c.keys)
+	if err != nil {
+		return nil, err
+	}
+	var resp *v3Message
+	err = c.exchange(req, func(b []byte) bool {
+		r, err := parseV3Message(b, c.lookup)
+		if err != nil || r.msgID != m.msgID {
+			return false
+		}
+		resp = r
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if discovery {
+		// The message refers to the read buffer.
+		c.engineID = append([]byte(nil), resp.engineID...)
+	}
+	if discovery || resp.flags&flagAuth != 0 {
+		c.boots, c.time, c.synced = resp.boots, resp.time, time.Now()
+	}
+	if resp.pdu.typ == report {
+		err := reportError(resp.pdu)
+		// Only discovery and unknown engine reports are unauthenticated by
+		// design; others must not be acted upon unless authenticated.
+		if !discovery && err != errUnknownEngine && flags&flagAuth != 0 && resp.flags&flagAuth == 0 {
+			return nil, fmt.Errorf("%v (unauthenticated report)", err)
+		}
+		return nil, err
+	}
+	if resp.pdu.typ != getResponse || resp.pdu.requestID != p.requestID {
+		return nil, fmt.Errorf("snmp: unexpected response")
+	}
+	if flags&flagAuth != 0 && resp.flags&flagAuth == 0 {
+		return nil, fmt.Errorf("snmp: unauthenticated response")
+	}
+	return resp.pdu, nil
+}
+
+// lookup returns the keys used to authenticate responses.
+func (c *Client) lookup(engineID []byte, user string) (*usmKeys, error) {
+	if c.keys == nil || !bytes.Equal(engineID, c.engineID) {
+		return nil, errUnknownEngine
+	}
+	if user != c.config.User.Name {
+		return nil, errUnknownUser
+	}
+	return c.keys, nil
+}
+
+// engineTime returns the current estimate of the agent's engine time.
+func (c *Client) engineTime() int32 {
+	if c.synced.IsZero() {
+		return c.time
+	}
+	return c.time + int32(time.Since(c.synced)/time.Second)
+}
+
+// exchange sends the request until accept returns true for a received
+// message, or the retries are exhausted.
+func (c *Client) exchange(req []byte, accept func([]byte) bool) error {
+	for attempt := 0; attempt <= c.config.Retries; attempt++ {
+		if _, err := c.conn.Write(req); err != nil {
+			return err
+		}
+		deadline := time.Now().Add(c.config.Timeout)
+		if err := c.conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+		for {
+			n, err := c.conn.Read(c.buf)
+			if err != nil {
+				if err, ok := err.(net.Error); ok && err.Timeout() {
+					break
+				}
+				return err
+			}
+			if accept(c.buf[:n]) {
+				return nil
+			}
+		}
+	}
+	return ErrTimeout
+}
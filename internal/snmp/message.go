@@ -0,0 +1,264 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package snmp
+
+import (
+	"crypto/hmac"
+	"fmt"
+)
+
+// Message versions.
+const (
+	version2c = 1
+	version3  = 3
+)
+
+// maxMessageSize is the largest message accepted.
+const maxMessageSize = 65507
+
+// appendCommunityMessage appends a v2c message.
+func appendCommunityMessage(b []byte, community string, p *pdu) ([]byte, error) {
+	value := appendInt(nil, tagInteger, version2c)
+	value = appendTLV(value, tagOctetString, []byte(community))
+	value, err := p.append(value)
+	if err != nil {
+		return nil, err
+	}
+	return appendTLV(b, tagSequence, value), nil
+}
+
+// parseCommunityMessage parses a v2c message.
+func parseCommunityMessage(buf []byte) (community string, p *pdu, err error) {
+	d, err := (&decoder{buf}).sequence()
+	if err != nil {
+		return "", nil, err
+	}
+	version, err := d.int()
+	if err != nil {
+		return "", nil, err
+	}
+	if version != version2c {
+		return "", nil, fmt.Errorf("snmp: unsupported version: %d", version)
+	}
+	b, err := d.octetString()
+	if err != nil {
+		return "", nil, err
+	}
+	p, err = parsePDU(d)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(b), p, nil
+}
+
+// messageVersion returns the version of the given message.
+func messageVersion(buf []byte) (int64, error) {
+	d, err := (&decoder{buf}).sequence()
+	if err != nil {
+		return 0, err
+	}
+	return d.int()
+}
+
+// Message flags.
+const (
+	flagAuth       = 0x01
+	flagPriv       = 0x02
+	flagReportable = 0x04
+)
+
+// securityModelUSM identifies the user-based security model.
+const securityModelUSM = 3
+
+// v3Message represents a v3 message.
+type v3Message struct {
+	msgID   int32
+	maxSize int32
+	flags   byte
+
+	// USM security parameters.
+	engineID   []byte
+	boots      int32
+	time       int32
+	user       string
+	authParams []byte
+	privParams []byte
+
+	// Scoped PDU.
+	contextEngineID []byte
+	contextName     []byte
+	pdu             *pdu
+}
+
+// append appends the message, encrypted and authenticated according to its
+// flags using the given keys, which may be nil if the flags request neither.
+func (m *v3Message) append(b []byte, keys *usmKeys) ([]byte, error) {
+	scoped := appendTLV(nil, tagOctetString, m.contextEngineID)
+	scoped = appendTLV(scoped, tagOctetString, m.contextName)
+	scoped, err := m.pdu.append(scoped)
+	if err != nil {
+		return nil, err
+	}
+	body := appendTLV(nil, tagSequence, scoped)
+	m.authParams, m.privParams = nil, nil
+	if m.flags&flagPriv != 0 {
+		var ciphertext []byte
+		ciphertext, m.privParams, err = keys.encrypt(body, m.boots, m.time)
+		if err != nil {
+			return nil, err
+		}
+		body = appendTLV(nil, tagOctetString, ciphertext)
+	}
+	if m.flags&flagAuth != 0 {
+		m.authParams = make([]byte, authParamsLen)
+	}
+	header := appendInt(nil, tagInteger, int64(m.msgID))
+	header = appendInt(header, tagInteger, int64(m.maxSize))
+	header = appendTLV(header, tagOctetString, []byte{m.flags})
+	header = appendInt(header, tagInteger, securityModelUSM)
+	privTLV := appendTLV(nil, tagOctetString, m.privParams)
+	usm := appendTLV(nil, tagOctetString, m.engineID)
+	usm = appendInt(usm, tagInteger, int64(m.boots))
+	usm = appendInt(usm, tagInteger, int64(m.time))
+	usm = appendTLV(usm, tagOctetString, []byte(m.user))
+	usm = appendTLV(usm, tagOctetString, m.authParams)
+	usm = append(usm, privTLV...)
+	value := appendInt(nil, tagInteger, version3)
+	value = appendTLV(value, tagSequence, header)
+	value = appendTLV(value, tagOctetString, appendTLV(nil, tagSequence, usm))
+	value = append(value, body...)
+	start := len(b)
+	b = appendTLV(b, tagSequence, value)
+	if m.flags&flagAuth != 0 {
+		// The authentication parameters immediately precede the privacy
+		// parameters, which are followed by the message body.
+		offset := len(b) - len(body) - len(privTLV) - authParamsLen
+		copy(b[offset:], keys.sign(b[start:]))
+	}
+	return b, nil
+}
+
+// parseV3Message parses a v3 message. The function lookup returns the keys
+// of the given user; it is called only if the message is authenticated. If
+// the message fails authentication or decryption, the error is returned
+// along with the message header and security parameters.
+func parseV3Message(buf []byte, lookup func(engineID []byte, user string) (*usmKeys, error)) (*v3Message, error) {
+	buf = buf[:len(buf):len(buf)]
+	d, err := (&decoder{buf}).sequence()
+	if err != nil {
+		return nil, err
+	}
+	version, err := d.int()
+	if err != nil {
+		return nil, err
+	}
+	if version != version3 {
+		return nil, fmt.Errorf("snmp: unsupported version: %d", version)
+	}
+	m := new(v3Message)
+	header, err := d.sequence()
+	if err != nil {
+		return nil, err
+	}
+	id, err := header.int()
+	if err != nil {
+		return nil, err
+	}
+	maxSize, err := header.int()
+	if err != nil {
+		return nil, err
+	}
+	flags, err := header.octetString()
+	if err != nil {
+		return nil, err
+	}
+	if len(flags) != 1 {
+		return nil, fmt.Errorf("snmp: invalid message flags")
+	}
+	model, err := header.int()
+	if err != nil {
+		return nil, err
+	}
+	if model != securityModelUSM {
+		return nil, fmt.Errorf("snmp: unsupported security model: %d", model)
+	}
+	m.msgID, m.maxSize, m.flags = int32(id), int32(maxSize), flags[0]
+	if m.flags&flagPriv != 0 && m.flags&flagAuth == 0 {
+		return nil, fmt.Errorf("snmp: invalid message flags: privacy without authentication")
+	}
+	b, err := d.octetString()
+	if err != nil {
+		return nil, err
+	}
+	usm, err := (&decoder{b}).sequence()
+	if err != nil {
+		return nil, err
+	}
+	if m.engineID, err = usm.octetString(); err != nil {
+		return nil, err
+	}
+	boots, err := usm.int()
+	if err != nil {
+		return nil, err
+	}
+	t, err := usm.int()
+	if err != nil {
+		return nil, err
+	}
+	m.boots, m.time = int32(boots), int32(t)
+	user, err := usm.octetString()
+	if err != nil {
+		return nil, err
+	}
+	m.user = string(user)
+	if m.authParams, err = usm.octetString(); err != nil {
+		return nil, err
+	}
+	if m.privParams, err = usm.octetString(); err != nil {
+		return nil, err
+	}
+	var keys *usmKeys
+	if m.flags&flagAuth != 0 {
+		keys, err = lookup(m.engineID, m.user)
+		if err != nil {
+			return m, err
+		}
+		if len(m.authParams) != authParamsLen {
+			return m, errWrongDigest
+		}
+		offset := len(buf) - cap(m.authParams)
+		unsigned := append([]byte(nil), buf...)
+		copy(unsigned[offset:offset+authParamsLen], make([]byte, authParamsLen))
+		if !hmac.Equal(keys.sign(unsigned), m.authParams) {
+			return m, errWrongDigest
+		}
+	}
+	body := d
+	if m.flags&flagPriv != 0 {
+		ciphertext, err := d.octetString()
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := keys.decrypt(ciphertext, m.privParams, m.boots, m.time)
+		if err != nil {
+			return m, err
+		}
+		body = &decoder{plaintext}
+	}
+	scoped, err := body.sequence()
+	if err != nil {
+		return m, errDecryption
+	}
+	if m.contextEngineID, err = scoped.octetString(); err != nil {
+		return m, errDecryption
+	}
+	if m.contextName, err = scoped.octetString(); err != nil {
+		return m, errDecryption
+	}
+	if m.pdu, err = parsePDU(scoped); err != nil {
+		return m, errDecryption
+	}
+	return m, nil
+}
@@ -0,0 +1,271 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package snmp
+
+import (
+	"fmt"
+	"net"
+)
+
+// PDU types.
+const (
+	getRequest     = 0xa0
+	getNextRequest = 0xa1
+	getResponse    = 0xa2
+	getBulkRequest = 0xa5
+	report         = 0xa8
+)
+
+// Type is the type of a variable value.
+type Type byte
+
+const (
+	Integer          Type = tagInteger
+	OctetString      Type = tagOctetString
+	Null             Type = tagNull
+	ObjectIdentifier Type = tagOID
+	IPAddress        Type = tagIPAddress
+	Counter32        Type = tagCounter32
+	Gauge32          Type = tagGauge32
+	TimeTicks        Type = tagTimeTicks
+	Opaque           Type = tagOpaque
+	Counter64        Type = tagCounter64
+	NoSuchObject     Type = tagNoSuchObject
+	NoSuchInstance   Type = tagNoSuchInstance
+	EndOfMibView     Type = tagEndOfMibView
+)
+
+// Variable is a variable binding. The Go type of Value depends on Type:
+//
+//	Integer                                   int64
+//	Counter32, Gauge32, TimeTicks, Counter64  uint64
+//	OctetString, Opaque                       []byte
+//	ObjectIdentifier                          OID
+//	IPAddress                                 net.IP
+//
+// The Value of the remaining types is nil.
+type Variable struct {
+	OID   OID
+	Type  Type
+	Value interface{}
+}
+
+// IsNumeric reports whether the variable holds a number.
+func (v *Variable) IsNumeric() bool {
+	switch v.Type {
+	case Integer, Counter32, Gauge32, TimeTicks, Counter64:
+		return true
+	}
+	return false
+}
+
+// IsException reports whether the variable signals a missing value.
+func (v *Variable) IsException() bool {
+	switch v.Type {
+	case NoSuchObject, NoSuchInstance, EndOfMibView:
+		return true
+	}
+	return false
+}
+
+// String returns the value formatted as text.
+func (v *Variable) String() string {
+	switch value := v.Value.(type) {
+	case []byte:
+		return string(value)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func appendVariable(b []byte, v *Variable) ([]byte, error) {
+	value := appendOID(nil, v.OID)
+	switch v.Type {
+	default:
+		return nil, fmt.Errorf("snmp: unsupported type: 0x%02x", byte(v.Type))
+	case Integer:
+		n, ok := v.Value.(int64)
+		if !ok {
+			return nil, fmt.Errorf("snmp: %s: invalid value: %v", v.OID, v.Value)
+		}
+		value = appendInt(value, byte(v.Type), n)
+	case Counter32, Gauge32, TimeTicks, Counter64:
+		n, ok := v.Value.(uint64)
+		if !ok {
+			return nil, fmt.Errorf("snmp: %s: invalid value: %v", v.OID, v.Value)
+		}
+		value = appendUint(value, byte(v.Type), n)
+	case OctetString, Opaque:
+		s, ok := v.Value.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("snmp: %s: invalid value: %v", v.OID, v.Value)
+		}
+		value = appendTLV(value, byte(v.Type), s)
+	case ObjectIdentifier:
+		oid, ok := v.Value.(OID)
+		if !ok {
+			return nil, fmt.Errorf("snmp: %s: invalid value: %v", v.OID, v.Value)
+		}
+		value = appendOID(value, oid)
+	case IPAddress:
+		ip, ok := v.Value.(net.IP)
+		if !ok || ip.To4() == nil {
+			return nil, fmt.Errorf("snmp: %s: invalid value: %v", v.OID, v.Value)
+		}
+		value = appendTLV(value, byte(v.Type), ip.To4())
+	case Null, NoSuchObject, NoSuchInstance, EndOfMibView:
+		value = appendTLV(value, byte(v.Type), nil)
+	}
+	return appendTLV(b, tagSequence, value), nil
+}
+
+func parseVariable(d *decoder) (*Variable, error) {
+	seq, err := d.sequence()
+	if err != nil {
+		return nil, err
+	}
+	b, err := seq.expect(tagOID)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := parseOID(b)
+	if err != nil {
+		return nil, err
+	}
+	tag, b, err := seq.next()
+	if err != nil {
+		return nil, err
+	}
+	v := &Variable{OID: oid, Type: Type(tag)}
+	switch v.Type {
+	default:
+		return nil, fmt.Errorf("snmp: %s: unsupported type: 0x%02x", oid, tag)
+	case Integer:
+		v.Value, err = parseInt(b)
+	case Counter32, Gauge32, TimeTicks, Counter64:
+		v.Value, err = parseUint(b)
+	case OctetString, Opaque:
+		v.Value = append([]byte(nil), b...)
+	case ObjectIdentifier:
+		v.Value, err = parseOID(b)
+	case IPAddress:
+		if len(b) != 4 {
+			return nil, fmt.Errorf("snmp: %s: invalid IP address", oid)
+		}
+		v.Value = net.IP(append([]byte(nil), b...))
+	case Null, NoSuchObject, NoSuchInstance, EndOfMibView:
+		// no value
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// pdu represents a protocol data unit. In GetBulk requests, errorStatus and
+// errorIndex hold non-repeaters and max-repetitions, respectively.
+type pdu struct {
+	typ         byte
+	requestID   int32
+	errorStatus int
+	errorIndex  int
+	vars        []*Variable
+}
+
+func (p *pdu) append(b []byte) ([]byte, error) {
+	value := appendInt(nil, tagInteger, int64(p.requestID))
+	value = appendInt(value, tagInteger, int64(p.errorStatus))
+	value = appendInt(value, tagInteger, int64(p.errorIndex))
+	var vars []byte
+	for _, v := range p.vars {
+		var err error
+		vars, err = appendVariable(vars, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	value = appendTLV(value, tagSequence, vars)
+	return appendTLV(b, p.typ, value), nil
+}
+
+func parsePDU(d *decoder) (*pdu, error) {
+	typ, b, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	default:
+		return nil, fmt.Errorf("snmp: unsupported PDU type: 0x%02x", typ)
+	case getRequest, getNextRequest, getResponse, getBulkRequest, report:
+		// ok
+	}
+	p := &pdu{typ: typ}
+	d = &decoder{b}
+	id, err := d.int()
+	if err != nil {
+		return nil, err
+	}
+	p.requestID = int32(id)
+	status, err := d.int()
+	if err != nil {
+		return nil, err
+	}
+	p.errorStatus = int(status)
+	index, err := d.int()
+	if err != nil {
+		return nil, err
+	}
+	p.errorIndex = int(index)
+	seq, err := d.sequence()
+	if err != nil {
+		return nil, err
+	}
+	for len(seq.buf) > 0 {
+		v, err := parseVariable(seq)
+		if err != nil {
+			return nil, err
+		}
+		p.vars = append(p.vars, v)
+	}
+	return p, nil
+}
+
+var errorStatusText = []string{
+	"noError",
+	"tooBig",
+	"noSuchName",
+	"badValue",
+	"readOnly",
+	"genErr",
+	"noAccess",
+	"wrongType",
+	"wrongLength",
+	"wrongEncoding",
+	"wrongValue",
+	"noCreation",
+	"inconsistentValue",
+	"resourceUnavailable",
+	"commitFailed",
+	"undoFailed",
+	"authorizationError",
+	"notWritable",
+	"inconsistentName",
+}
+
+// Error is an error status reported by an agent.
+type Error struct {
+	Status int
+	Index  int
+}
+
+func (e *Error) Error() string {
+	text := fmt.Sprintf("status %d", e.Status)
+	if 0 <= e.Status && e.Status < len(errorStatusText) {
+		text = errorStatusText[e.Status]
+	}
+	return fmt.Sprintf("snmp: agent error: %s (index %d)", text, e.Index)
+}
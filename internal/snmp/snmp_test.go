@@ -0,0 +1,308 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package snmp
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testPasswordToKey = []struct {
+	protocol string
+	want     string
+}{
+	{"MD5", "526f5eed9fcce26f8964c2930787d82b"},
+	{"SHA", "6695febc9288e36282235fc7151f128497b38f3f"},
+}
+
+// TestPasswordToKey checks the key localization example of RFC 3414, A.3.
+func TestPasswordToKey(t *testing.T) {
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	for i, tt := range testPasswordToKey {
+		newHash := md5.New
+		if tt.protocol == "SHA" {
+			newHash = sha1.New
+		}
+		got := hex.EncodeToString(passwordToKey(newHash, "maplesyrup", engineID))
+		if got != tt.want {
+			t.Errorf("#%d. got %s, want %s", i, got, tt.want)
+		}
+	}
+}
+
+var testVariable = []*Variable{
+	{OID{1, 3, 6, 1, 2, 1, 1, 3, 0}, TimeTicks, uint64(12345)},
+	{OID{1, 3, 6, 1, 2, 1, 1, 5, 0}, OctetString, []byte("router")},
+	{OID{1, 3, 6, 1, 2, 1, 2, 2, 1, 5, 1}, Gauge32, uint64(1<<32 - 1)},
+	{OID{1, 3, 6, 1, 4, 1, 1, 1}, Integer, int64(-129)},
+	{OID{1, 3, 6, 1, 4, 1, 1, 2}, Integer, int64(0)},
+	{OID{1, 3, 6, 1, 4, 1, 1, 3}, Counter64, uint64(1<<64 - 1)},
+	{OID{1, 3, 6, 1, 4, 1, 1, 4}, ObjectIdentifier, OID{1, 3, 6, 1, 4, 1, 200000}},
+	{OID{1, 3, 6, 1, 4, 1, 1, 5}, IPAddress, net.IP{10, 0, 0, 1}},
+	{OID{2, 999, 1}, NoSuchInstance, nil},
+}
+
+func TestVariableRoundTrip(t *testing.T) {
+	for i, v := range testVariable {
+		b, err := appendVariable(nil, v)
+		if err != nil {
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		}
+		got, err := parseVariable(&decoder{b})
+		if err != nil {
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("#%d. got %v, want %v", i, got, v)
+		}
+	}
+}
+
+var testParseOID = []struct {
+	in   string
+	want OID
+	err  bool
+}{
+	{in: "1.3.6.1.2.1.1.3.0", want: OID{1, 3, 6, 1, 2, 1, 1, 3, 0}},
+	{in: ".1.3.6.1", want: OID{1, 3, 6, 1}},
+	{in: "", err: true},
+	{in: "1", err: true},
+	{in: "1.40", err: true},
+	{in: "3.1", err: true},
+	{in: "1.3.x", err: true},
+	{in: "1.3..6", err: true},
+}
+
+func TestParseOID(t *testing.T) {
+	for i, tt := range testParseOID {
+		got, err := ParseOID(tt.in)
+		if err != nil {
+			if !tt.err {
+				t.Errorf("#%d. unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if tt.err {
+			t.Errorf("#%d. unexpected success", i)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d. got %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+var testClient = []struct {
+	agent  *Agent
+	config *ClientConfig
+	err    bool
+}{
+	{
+		agent:  &Agent{Community: "public"},
+		config: &ClientConfig{Community: "public"},
+	},
+	{
+		agent: &Agent{
+			User: &User{Name: "noauth"},
+		},
+		config: &ClientConfig{
+			User: &User{Name: "noauth"},
+		},
+	},
+	{
+		agent: &Agent{
+			User: &User{Name: "auth", AuthProtocol: "MD5", AuthPassword: "maplesyrup"},
+		},
+		config: &ClientConfig{
+			User: &User{Name: "auth", AuthProtocol: "MD5", AuthPassword: "maplesyrup"},
+		},
+	},
+	{
+		agent: &Agent{
+			User: &User{Name: "priv", AuthProtocol: "SHA", AuthPassword: "maplesyrup", PrivProtocol: "DES", PrivPassword: "pancakes"},
+		},
+		config: &ClientConfig{
+			User: &User{Name: "priv", AuthProtocol: "SHA", AuthPassword: "maplesyrup", PrivProtocol: "DES", PrivPassword: "pancakes"},
+		},
+	},
+	{
+		agent: &Agent{
+			User: &User{Name: "priv", AuthProtocol: "SHA", AuthPassword: "maplesyrup", PrivProtocol: "AES", PrivPassword: "pancakes"},
+		},
+		config: &ClientConfig{
+			User: &User{Name: "priv", AuthProtocol: "SHA", AuthPassword: "maplesyrup", PrivProtocol: "AES", PrivPassword: "pancakes"},
+		},
+	},
+	{
+		agent: &Agent{
+			User: &User{Name: "auth", AuthProtocol: "MD5", AuthPassword: "maplesyrup"},
+		},
+		config: &ClientConfig{
+			User: &User{Name: "auth", AuthProtocol: "MD5", AuthPassword: "wrongpassword"},
+		},
+		err: true,
+	},
+	{
+		agent: &Agent{
+			User: &User{Name: "auth", AuthProtocol: "MD5", AuthPassword: "maplesyrup"},
+		},
+		config: &ClientConfig{
+			User: &User{Name: "other", AuthProtocol: "MD5", AuthPassword: "maplesyrup"},
+		},
+		err: true,
+	},
+	{
+		agent: &Agent{
+			User: &User{Name: "auth", AuthProtocol: "MD5", AuthPassword: "maplesyrup"},
+		},
+		config: &ClientConfig{
+			User: &User{Name: "auth"},
+		},
+		err: true,
+	},
+}
+
+func TestClient(t *testing.T) {
+	for i, tt := range testClient {
+		tt.agent.Vars = testVariable
+		if err := tt.agent.Listen("127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		tt.config.Timeout = time.Second
+		c, err := Dial(tt.agent.Addr(), tt.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vars, err := c.Get(OID{1, 3, 6, 1, 2, 1, 1, 5, 0}, OID{1, 3, 6, 1, 2, 1, 1, 6, 0})
+		if err != nil {
+			if !tt.err {
+				t.Errorf("#%d. Get: unexpected error: %v", i, err)
+			}
+			c.Close()
+			tt.agent.Close()
+			continue
+		}
+		if tt.err {
+			t.Errorf("#%d. unexpected success", i)
+		}
+		if got := vars[0].String(); got != "router" {
+			t.Errorf("#%d. Get: got %q, want %q", i, got, "router")
+		}
+		if got := vars[1].Type; got != NoSuchObject {
+			t.Errorf("#%d. Get: got type 0x%02x, want NoSuchObject", i, byte(got))
+		}
+		var walked []OID
+		err = c.Walk(OID{1, 3, 6, 1, 4, 1}, func(v *Variable) error {
+			walked = append(walked, v.OID)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("#%d. Walk: unexpected error: %v", i, err)
+		}
+		if len(walked) != 5 {
+			t.Errorf("#%d. Walk: got %v, want 5 variables", i, walked)
+		}
+		c.Close()
+		tt.agent.Close()
+	}
+}
+
+// TestClientUnauthenticatedReport checks that the client does not retry on
+// an unauthenticated time window report, which may have been spoofed.
+func TestClientUnauthenticatedReport(t *testing.T) {
+	user := &User{Name: "auth", AuthProtocol: "MD5", AuthPassword: "maplesyrup"}
+	engineID := []byte{0x80, 0, 0x1f, 0x88, 0x04, 't', 'e', 's', 't'}
+	keys := user.localize(engineID)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var requests int32
+	go func() {
+		buf := make([]byte, maxMessageSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			m, _ := parseV3Message(buf[:n], func([]byte, string) (*usmKeys, error) {
+				return keys, nil
+			})
+			if m == nil {
+				continue
+			}
+			resp := &v3Message{
+				msgID:           m.msgID,
+				maxSize:         maxMessageSize,
+				engineID:        engineID,
+				boots:           1,
+				time:            100,
+				contextEngineID: engineID,
+				pdu: &pdu{
+					typ:  report,
+					vars: []*Variable{{OID: usmStatsUnknownEngineIDs, Type: Counter32, Value: uint64(1)}},
+				},
+			}
+			if m.user != "" {
+				atomic.AddInt32(&requests, 1)
+				resp.time = 5000
+				resp.pdu.vars[0].OID = usmStatsNotInTimeWindows
+			}
+			b, err := resp.append(nil, nil)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(b, addr)
+		}
+	}()
+	c, err := Dial(conn.LocalAddr().String(), &ClientConfig{
+		User:    user,
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, err = c.Get(OID{1, 3, 6, 1, 2, 1, 1, 3, 0})
+	if err == nil || !strings.Contains(err.Error(), "unauthenticated report") {
+		t.Errorf("got %v, want unauthenticated report error", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+	if c.time != 100 {
+		t.Errorf("engine time updated by unauthenticated report: %d", c.time)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c, err := Dial(conn.LocalAddr().String(), &ClientConfig{
+		Community: "public",
+		Timeout:   10 * time.Millisecond,
+		Retries:   2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Get(OID{1, 3, 6, 1, 2, 1, 1, 3, 0}); err != ErrTimeout {
+		t.Errorf("got %v, want %v", err, ErrTimeout)
+	}
+}
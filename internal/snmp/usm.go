@@ -0,0 +1,253 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package snmp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"sync/atomic"
+)
+
+// authParamsLen is the length of the HMAC-96 authentication parameters.
+const authParamsLen = 12
+
+// minPasswordLen is the minimum length of USM passwords, see RFC 3414.
+const minPasswordLen = 8
+
+var (
+	errWrongDigest   = errors.New("snmp: authentication failure")
+	errDecryption    = errors.New("snmp: decryption failure")
+	errUnknownUser   = errors.New("snmp: unknown user")
+	errUnknownEngine = errors.New("snmp: unknown engine id")
+	errTimeWindow    = errors.New("snmp: not in time window")
+)
+
+// User represents the credentials of a v3 user of the user-based security
+// model. AuthProtocol is "MD5", "SHA", or empty if authentication is not
+// used. PrivProtocol is "DES", "AES", or empty if privacy is not used.
+type User struct {
+	Name         string
+	AuthProtocol string
+	AuthPassword string `config:"secret"`
+	PrivProtocol string
+	PrivPassword string `config:"secret"`
+}
+
+func (u *User) Validate() error {
+	if u.Name == "" {
+		return fmt.Errorf("missing user name")
+	}
+	switch u.AuthProtocol {
+	default:
+		return fmt.Errorf("user %s: unsupported AuthProtocol: %s", u.Name, u.AuthProtocol)
+	case "":
+		if u.PrivProtocol != "" {
+			return fmt.Errorf("user %s: PrivProtocol requires AuthProtocol", u.Name)
+		}
+	case "MD5", "SHA":
+		if len(u.AuthPassword) < minPasswordLen {
+			return fmt.Errorf("user %s: AuthPassword too short", u.Name)
+		}
+	}
+	switch u.PrivProtocol {
+	default:
+		return fmt.Errorf("user %s: unsupported PrivProtocol: %s", u.Name, u.PrivProtocol)
+	case "":
+		// ok
+	case "DES", "AES":
+		if len(u.PrivPassword) < minPasswordLen {
+			return fmt.Errorf("user %s: PrivPassword too short", u.Name)
+		}
+	}
+	return nil
+}
+
+// flags returns the message flags selecting the user's security level.
+func (u *User) flags() byte {
+	var flags byte
+	if u.AuthProtocol != "" {
+		flags |= flagAuth
+	}
+	if u.PrivProtocol != "" {
+		flags |= flagPriv
+	}
+	return flags
+}
+
+// usmKeys holds the keys of a user localized to an engine.
+type usmKeys struct {
+	hash     func() hash.Hash
+	auth     []byte
+	privProt string
+	priv     []byte
+	salt     uint64 // incremented for every encrypted message
+}
+
+// localize returns the keys of the user localized to the given engine.
+func (u *User) localize(engineID []byte) *usmKeys {
+	keys := new(usmKeys)
+	switch u.AuthProtocol {
+	case "":
+		return keys
+	case "MD5":
+		keys.hash = md5.New
+	case "SHA":
+		keys.hash = sha1.New
+	}
+	keys.auth = passwordToKey(keys.hash, u.AuthPassword, engineID)
+	if u.PrivProtocol != "" {
+		keys.privProt = u.PrivProtocol
+		keys.priv = passwordToKey(keys.hash, u.PrivPassword, engineID)
+		var b [8]byte
+		rand.Read(b[:])
+		keys.salt = binary.BigEndian.Uint64(b[:])
+	}
+	return keys
+}
+
+// passwordToKey implements the password to key algorithm of RFC 3414,
+// returning the key localized to the given engine.
+func passwordToKey(newHash func() hash.Hash, password string, engineID []byte) []byte {
+	h := newHash()
+	buf := make([]byte, 64)
+	for i, j := 0, 0; i < 1<<20; i += len(buf) {
+		for k := range buf {
+			buf[k] = password[j%len(password)]
+			j++
+		}
+		h.Write(buf)
+	}
+	key := h.Sum(nil)
+	h.Reset()
+	h.Write(key)
+	h.Write(engineID)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// sign returns the authentication parameters of the given message.
+func (keys *usmKeys) sign(msg []byte) []byte {
+	mac := hmac.New(keys.hash, keys.auth)
+	mac.Write(msg)
+	return mac.Sum(nil)[:authParamsLen]
+}
+
+// encrypt encrypts the scoped PDU, returning the ciphertext and the privacy
+// parameters.
+func (keys *usmKeys) encrypt(plaintext []byte, boots, time int32) (ciphertext, privParams []byte, err error) {
+	salt := atomic.AddUint64(&keys.salt, 1)
+	privParams = make([]byte, 8)
+	switch keys.privProt {
+	default:
+		return nil, nil, fmt.Errorf("snmp: unsupported privacy protocol: %s", keys.privProt)
+	case "DES":
+		binary.BigEndian.PutUint32(privParams, uint32(boots))
+		binary.BigEndian.PutUint32(privParams[4:], uint32(salt))
+		block, err := des.NewCipher(keys.priv[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = keys.priv[8+i] ^ privParams[i]
+		}
+		n := (len(plaintext) + 7) / 8 * 8
+		ciphertext = make([]byte, n)
+		copy(ciphertext, plaintext)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+	case "AES":
+		binary.BigEndian.PutUint64(privParams, salt)
+		block, err := aes.NewCipher(keys.priv[:16])
+		if err != nil {
+			return nil, nil, err
+		}
+		ciphertext = make([]byte, len(plaintext))
+		cipher.NewCFBEncrypter(block, aesIV(boots, time, privParams)).XORKeyStream(ciphertext, plaintext)
+	}
+	return ciphertext, privParams, nil
+}
+
+// decrypt decrypts the scoped PDU.
+func (keys *usmKeys) decrypt(ciphertext, privParams []byte, boots, time int32) ([]byte, error) {
+	if len(privParams) != 8 {
+		return nil, errDecryption
+	}
+	plaintext := make([]byte, len(ciphertext))
+	switch keys.privProt {
+	default:
+		return nil, errDecryption
+	case "DES":
+		if len(ciphertext)%8 != 0 {
+			return nil, errDecryption
+		}
+		block, err := des.NewCipher(keys.priv[:8])
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = keys.priv[8+i] ^ privParams[i]
+		}
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	case "AES":
+		block, err := aes.NewCipher(keys.priv[:16])
+		if err != nil {
+			return nil, err
+		}
+		cipher.NewCFBDecrypter(block, aesIV(boots, time, privParams)).XORKeyStream(plaintext, ciphertext)
+	}
+	return plaintext, nil
+}
+
+// aesIV returns the initialization vector of RFC 3826.
+func aesIV(boots, time int32, privParams []byte) []byte {
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint32(iv, uint32(boots))
+	binary.BigEndian.PutUint32(iv[4:], uint32(time))
+	copy(iv[8:], privParams)
+	return iv
+}
+
+// USM statistics reported by agents in Report PDUs, see RFC 3414.
+var (
+	usmStatsUnsupportedSecLevels = OID{1, 3, 6, 1, 6, 3, 15, 1, 1, 1, 0}
+	usmStatsNotInTimeWindows     = OID{1, 3, 6, 1, 6, 3, 15, 1, 1, 2, 0}
+	usmStatsUnknownUserNames     = OID{1, 3, 6, 1, 6, 3, 15, 1, 1, 3, 0}
+	usmStatsUnknownEngineIDs     = OID{1, 3, 6, 1, 6, 3, 15, 1, 1, 4, 0}
+	usmStatsWrongDigests         = OID{1, 3, 6, 1, 6, 3, 15, 1, 1, 5, 0}
+	usmStatsDecryptionErrors     = OID{1, 3, 6, 1, 6, 3, 15, 1, 1, 6, 0}
+)
+
+// reportError returns the error signalled by a Report PDU.
+func reportError(p *pdu) error {
+	if len(p.vars) == 0 {
+		return fmt.Errorf("snmp: empty report")
+	}
+	oid := p.vars[0].OID
+	switch {
+	case oid.Compare(usmStatsUnsupportedSecLevels) == 0:
+		return fmt.Errorf("snmp: unsupported security level")
+	case oid.Compare(usmStatsNotInTimeWindows) == 0:
+		return errTimeWindow
+	case oid.Compare(usmStatsUnknownUserNames) == 0:
+		return errUnknownUser
+	case oid.Compare(usmStatsUnknownEngineIDs) == 0:
+		return errUnknownEngine
+	case oid.Compare(usmStatsWrongDigests) == 0:
+		return errWrongDigest
+	case oid.Compare(usmStatsDecryptionErrors) == 0:
+		return errDecryption
+	}
+	return fmt.Errorf("snmp: report: %s", oid)
+}
@@ -0,0 +1,382 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+// Package snmpstat implements built-in collection of SNMP tables.
+//
+// Each table is defined by a set of columns, which hold the values, and a
+// set of tag columns, which identify the rows. The tables are walked on
+// every target, and each numeric cell is reported as a data point tagged
+// with the target host and the tag values of its row.
+package snmpstat
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"opentsp.org/internal/snmp"
+	"opentsp.org/internal/tsdb"
+)
+
+var Debug *log.Logger
+
+// Default settings.
+const (
+	DefaultInterval = 60 * time.Second
+	DefaultTimeout  = 5 * time.Second
+)
+
+const (
+	maxInterval = 1 * time.Hour
+	maxRetries  = 10
+	maxTargets  = 1024
+	maxTables   = 64
+	maxColumns  = 64
+)
+
+// maxTags is the number of tag columns allowed, leaving room for the host
+// tag.
+const maxTags = 7
+
+var statErrors = expvar.NewMap("snmpstat.Errors")
+
+// Config represents the collector settings. The collector is disabled if no
+// targets are given.
+type Config struct {
+	Interval string    `json:",omitempty"`
+	Timeout  string    `json:",omitempty"`
+	Retries  int       `json:",omitempty"`
+	Table    []*Table  `json:",omitempty"`
+	Target   []*Target `json:",omitempty"`
+
+	interval time.Duration
+	timeout  time.Duration
+	tables   map[string]*Table
+}
+
+func (c *Config) Validate() error {
+	var err error
+	if c.interval, err = parseDuration("Interval", c.Interval, DefaultInterval); err != nil {
+		return err
+	}
+	if c.timeout, err = parseDuration("Timeout", c.Timeout, DefaultTimeout); err != nil {
+		return err
+	}
+	if c.Retries < 0 || c.Retries > maxRetries {
+		return fmt.Errorf("Retries out of range: %d", c.Retries)
+	}
+	if n := len(c.Table); n > maxTables {
+		return fmt.Errorf("too many tables defined: %d > %d", n, maxTables)
+	}
+	c.tables = make(map[string]*Table)
+	for _, t := range c.Table {
+		if t == nil {
+			return fmt.Errorf("invalid table: null")
+		}
+		if err := t.Validate(); err != nil {
+			return err
+		}
+		if c.tables[t.ID] != nil {
+			return fmt.Errorf("table redeclared: %s", t.ID)
+		}
+		c.tables[t.ID] = t
+	}
+	if n := len(c.Target); n > maxTargets {
+		return fmt.Errorf("too many targets defined: %d > %d", n, maxTargets)
+	}
+	seen := make(map[string]bool)
+	for _, t := range c.Target {
+		if t == nil {
+			return fmt.Errorf("invalid target: null")
+		}
+		if err := t.Validate(); err != nil {
+			return err
+		}
+		if seen[t.Host] {
+			return fmt.Errorf("target redeclared: %s", t.Host)
+		}
+		seen[t.Host] = true
+		for _, id := range t.Table {
+			if c.tables[id] == nil {
+				return fmt.Errorf("target %s: undefined table: %s", t.Host, id)
+			}
+		}
+	}
+	return nil
+}
+
+func parseDuration(name, s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	if d < 1*time.Second || d > maxInterval {
+		return 0, fmt.Errorf("%s out of range: %v", name, d)
+	}
+	return d, nil
+}
+
+// Table represents a conceptual table of the MIB. The cells of each Column
+// are reported under the column's metric. The rows are tagged using the
+// Tag columns; a tag with no OID takes the row index as its value. If no
+// tags are given, the row index is reported under the tag "index".
+type Table struct {
+	ID     string
+	Column []*Column
+	Tag    []*Tag `json:",omitempty"`
+
+	tags []*Tag
+}
+
+// Column maps a table column to a metric.
+type Column struct {
+	OID    string
+	Metric string
+
+	oid snmp.OID
+}
+
+// Tag maps a table column to a tag.
+type Tag struct {
+	Name string
+	OID  string `json:",omitempty"`
+
+	oid snmp.OID
+}
+
+func (t *Table) Validate() error {
+	if t.ID == "" {
+		return fmt.Errorf("table: missing ID")
+	}
+	if len(t.Column) == 0 {
+		return fmt.Errorf("table %s: missing Column", t.ID)
+	}
+	if n := len(t.Column); n > maxColumns {
+		return fmt.Errorf("table %s: too many columns: %d > %d", t.ID, n, maxColumns)
+	}
+	for _, c := range t.Column {
+		if c == nil {
+			return fmt.Errorf("table %s: invalid column: null", t.ID)
+		}
+		var err error
+		if c.oid, err = snmp.ParseOID(c.OID); err != nil {
+			return fmt.Errorf("table %s: %v", t.ID, err)
+		}
+		if c.Metric == "" || tsdb.Clean(c.Metric) != c.Metric {
+			return fmt.Errorf("table %s: column %s: invalid Metric: %q", t.ID, c.OID, c.Metric)
+		}
+	}
+	t.tags = t.Tag
+	if len(t.tags) == 0 {
+		t.tags = []*Tag{{Name: "index"}}
+	}
+	if n := len(t.tags); n > maxTags {
+		return fmt.Errorf("table %s: too many tags: %d > %d", t.ID, n, maxTags)
+	}
+	seen := map[string]bool{"host": true}
+	for _, tag := range t.tags {
+		if tag == nil {
+			return fmt.Errorf("table %s: invalid tag: null", t.ID)
+		}
+		if tag.Name == "" || tsdb.Clean(tag.Name) != tag.Name {
+			return fmt.Errorf("table %s: invalid tag name: %q", t.ID, tag.Name)
+		}
+		if seen[tag.Name] {
+			return fmt.Errorf("table %s: tag redeclared: %s", t.ID, tag.Name)
+		}
+		seen[tag.Name] = true
+		if tag.OID == "" {
+			continue
+		}
+		var err error
+		if tag.oid, err = snmp.ParseOID(tag.OID); err != nil {
+			return fmt.Errorf("table %s: tag %s: %v", t.ID, tag.Name, err)
+		}
+	}
+	return nil
+}
+
+// Target represents an agent. Host is the agent address, with the port
+// defaulting to 161. Version is "2c" (the default) or "3". The v3 security
+// settings are given in User. Table lists the tables polled; if empty, all
+// tables are polled.
+type Target struct {
+	Host      string
+	Version   string     `json:",omitempty"`
+	Community string     `json:",omitempty" config:"secret"`
+	User      *snmp.User `json:",omitempty"`
+	Table     []string   `json:",omitempty"`
+}
+
+func (t *Target) Validate() error {
+	if t.Host == "" {
+		return fmt.Errorf("target: missing Host")
+	}
+	switch t.Version {
+	default:
+		return fmt.Errorf("target %s: unsupported Version: %s", t.Host, t.Version)
+	case "", "2c":
+		if t.Community == "" {
+			return fmt.Errorf("target %s: missing Community", t.Host)
+		}
+		if t.User != nil {
+			return fmt.Errorf("target %s: User requires Version 3", t.Host)
+		}
+	case "3":
+		if t.User == nil {
+			return fmt.Errorf("target %s: missing User", t.Host)
+		}
+		if err := t.User.Validate(); err != nil {
+			return fmt.Errorf("target %s: %v", t.Host, err)
+		}
+	}
+	return nil
+}
+
+// hostTag returns the value of the host tag.
+func (t *Target) hostTag() string {
+	host, _, err := net.SplitHostPort(t.Host)
+	if err != nil {
+		host = t.Host
+	}
+	return tsdb.Clean(host)
+}
+
+// Collect returns a tsdb.Chan that carries the table data of all targets.
+// If no targets are given, the channel is silent.
+func Collect(config *Config) tsdb.Chan {
+	if err := config.Validate(); err != nil {
+		log.Panicf("internal error: %v", err)
+	}
+	ch := make(chan *tsdb.Point)
+	for _, t := range config.Target {
+		go loop(config, t, ch)
+	}
+	return ch
+}
+
+func loop(config *Config, target *Target, w chan<- *tsdb.Point) {
+	tables := config.Table
+	if len(target.Table) > 0 {
+		tables = nil
+		for _, id := range target.Table {
+			tables = append(tables, config.tables[id])
+		}
+	}
+	clientConfig := &snmp.ClientConfig{
+		Community: target.Community,
+		User:      target.User,
+		Timeout:   config.timeout,
+		Retries:   config.Retries,
+	}
+	var client *snmp.Client
+	tick := tsdb.Tick(config.interval)
+	for {
+		now := <-tick
+		if client == nil {
+			var err error
+			client, err = snmp.Dial(target.Host, clientConfig)
+			if err != nil {
+				statErrors.Add("type=Dial", 1)
+				log.Printf("snmpstat: %s: %v", target.Host, err)
+				continue
+			}
+		}
+		for _, table := range tables {
+			err := collect(client, now, target, table, func(p *tsdb.Point) {
+				w <- p
+			})
+			if err == nil {
+				continue
+			}
+			log.Printf("snmpstat: %s: table %s: %v", target.Host, table.ID, err)
+			if err == snmp.ErrTimeout {
+				// Skip the remaining tables, which are likely to time
+				// out as well.
+				statErrors.Add("type=Timeout", 1)
+				break
+			}
+			statErrors.Add("type=Collect", 1)
+		}
+	}
+}
+
+// walker walks a subtree of the variables of an agent. It is implemented by
+// *snmp.Client.
+type walker interface {
+	Walk(root snmp.OID, fn func(*snmp.Variable) error) error
+}
+
+// collect walks the table and emits the numeric cells.
+func collect(client walker, t time.Time, target *Target, table *Table, emit func(*tsdb.Point)) error {
+	tags := make([]map[string]string, len(table.tags))
+	for i, tag := range table.tags {
+		if tag.oid == nil {
+			continue
+		}
+		tags[i] = make(map[string]string)
+		err := client.Walk(tag.oid, func(v *snmp.Variable) error {
+			tags[i][index(v.OID, tag.oid)] = v.String()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	host := target.hostTag()
+	for _, column := range table.Column {
+		err := client.Walk(column.oid, func(v *snmp.Variable) error {
+			if !v.IsNumeric() {
+				if Debug != nil {
+					Debug.Printf("%s: %s: not a number", target.Host, v.OID)
+				}
+				return nil
+			}
+			row := index(v.OID, column.oid)
+			keyval := []string{"host", host}
+			for i, tag := range table.tags {
+				value := row
+				if tag.oid != nil {
+					var ok bool
+					value, ok = tags[i][row]
+					if !ok {
+						if Debug != nil {
+							Debug.Printf("%s: %s: missing tag %s", target.Host, v.OID, tag.Name)
+						}
+						return nil
+					}
+				}
+				value = tsdb.Clean(value)
+				if value == "" {
+					if Debug != nil {
+						Debug.Printf("%s: %s: empty tag %s", target.Host, v.OID, tag.Name)
+					}
+					return nil
+				}
+				keyval = append(keyval, tag.Name, value)
+			}
+			p, err := tsdb.NewPoint(t, v.Value, column.Metric, keyval...)
+			if err != nil {
+				return err
+			}
+			emit(p)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index returns the row index of a cell, i.e. its OID suffix following the
+// column OID.
+func index(oid, column snmp.OID) string {
+	return oid[len(column):].String()
+}
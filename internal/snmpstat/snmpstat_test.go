@@ -0,0 +1,196 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package snmpstat
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"opentsp.org/internal/snmp"
+	"opentsp.org/internal/tsdb"
+)
+
+// testVars simulate a device with two interfaces.
+var testVars = []*snmp.Variable{
+	{OID: snmp.OID{1, 3, 6, 1, 2, 1, 1, 3, 0}, Type: snmp.TimeTicks, Value: uint64(100)},
+	{OID: snmp.OID{1, 3, 6, 1, 2, 1, 2, 2, 1, 2, 1}, Type: snmp.OctetString, Value: []byte("eth0")},
+	{OID: snmp.OID{1, 3, 6, 1, 2, 1, 2, 2, 1, 2, 2}, Type: snmp.OctetString, Value: []byte("Port 1/2")},
+	{OID: snmp.OID{1, 3, 6, 1, 2, 1, 2, 2, 1, 3, 1}, Type: snmp.Integer, Value: int64(6)},
+	{OID: snmp.OID{1, 3, 6, 1, 2, 1, 2, 2, 1, 3, 2}, Type: snmp.Integer, Value: int64(6)},
+	{OID: snmp.OID{1, 3, 6, 1, 2, 1, 2, 2, 1, 10, 1}, Type: snmp.Counter32, Value: uint64(1000)},
+	{OID: snmp.OID{1, 3, 6, 1, 2, 1, 2, 2, 1, 10, 2}, Type: snmp.Counter32, Value: uint64(2000)},
+	{OID: snmp.OID{1, 3, 6, 1, 2, 1, 2, 2, 1, 16, 1}, Type: snmp.Counter32, Value: uint64(3000)},
+	{OID: snmp.OID{1, 3, 6, 1, 2, 1, 2, 2, 1, 16, 2}, Type: snmp.Counter32, Value: uint64(4000)},
+	{OID: snmp.OID{1, 3, 6, 1, 2, 1, 31, 1, 1, 1, 6, 1}, Type: snmp.Counter64, Value: uint64(1 << 40)},
+}
+
+var testCollect = []struct {
+	table *Table
+	want  []string
+}{
+	{
+		table: &Table{
+			ID: "if",
+			Column: []*Column{
+				{OID: "1.3.6.1.2.1.2.2.1.10", Metric: "snmp.if.bytes.in"},
+				{OID: "1.3.6.1.2.1.2.2.1.16", Metric: "snmp.if.bytes.out"},
+			},
+			Tag: []*Tag{
+				{Name: "iface", OID: "1.3.6.1.2.1.2.2.1.2"},
+				{Name: "index"},
+			},
+		},
+		want: []string{
+			`snmp.if.bytes.in 0 1000 host=127.0.0.1 iface=eth0 index=1`,
+			`snmp.if.bytes.in 0 2000 host=127.0.0.1 iface=Port_1/2 index=2`,
+			`snmp.if.bytes.out 0 3000 host=127.0.0.1 iface=eth0 index=1`,
+			`snmp.if.bytes.out 0 4000 host=127.0.0.1 iface=Port_1/2 index=2`,
+		},
+	},
+	{
+		table: &Table{
+			ID: "ifx",
+			Column: []*Column{
+				{OID: ".1.3.6.1.2.1.31.1.1.1.6", Metric: "snmp.if.hc.bytes.in"},
+			},
+		},
+		want: []string{
+			`snmp.if.hc.bytes.in 0 1099511627776 host=127.0.0.1 index=1`,
+		},
+	},
+	{
+		table: &Table{
+			ID: "system",
+			Column: []*Column{
+				{OID: "1.3.6.1.2.1.1", Metric: "snmp.system"},
+			},
+		},
+		want: []string{
+			`snmp.system 0 100 host=127.0.0.1 index=3.0`,
+		},
+	},
+	{
+		table: &Table{
+			ID: "missing",
+			Column: []*Column{
+				{OID: "1.3.6.1.2.1.4", Metric: "snmp.ip"},
+			},
+		},
+		want: nil,
+	},
+}
+
+// testWalker walks the variables of a simulated device, which are sorted by
+// OID.
+type testWalker []*snmp.Variable
+
+func (w testWalker) Walk(root snmp.OID, fn func(*snmp.Variable) error) error {
+	for _, v := range w {
+		if !v.OID.HasPrefix(root) || v.OID.Compare(root) == 0 {
+			continue
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestCollect(t *testing.T) {
+	client := testWalker(testVars)
+	target := &Target{Host: "127.0.0.1:161", Community: "public"}
+	for i, tt := range testCollect {
+		if err := tt.table.Validate(); err != nil {
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		}
+		var got []string
+		err := collect(client, time.Unix(0, 0), target, tt.table, func(p *tsdb.Point) {
+			got = append(got, encode(t, p))
+		})
+		if err != nil {
+			t.Errorf("#%d. unexpected error: %v", i, err)
+			continue
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d. invalid output\ngot:  %q\nwant: %q", i, got, tt.want)
+		}
+	}
+}
+
+var testValidate = []struct {
+	in  *Config
+	err string
+}{
+	{&Config{}, ""},
+	{
+		&Config{
+			Table:  []*Table{{ID: "if", Column: []*Column{{OID: "1.3.6.1.2.1.2.2.1.10", Metric: "if.in"}}}},
+			Target: []*Target{{Host: "sw1", Community: "public", Table: []string{"if"}}},
+		},
+		"",
+	},
+	{
+		&Config{
+			Target: []*Target{{Host: "sw1", Version: "3", User: &snmp.User{Name: "u", AuthProtocol: "SHA", AuthPassword: "12345678"}}},
+		},
+		"",
+	},
+	{&Config{Interval: "1ms"}, "Interval out of range"},
+	{&Config{Timeout: "x"}, "invalid Timeout"},
+	{&Config{Retries: -1}, "Retries out of range"},
+	{&Config{Table: []*Table{{Column: []*Column{{OID: "1.3", Metric: "m"}}}}}, "table: missing ID"},
+	{&Config{Table: []*Table{{ID: "t"}}}, "table t: missing Column"},
+	{&Config{Table: []*Table{{ID: "t", Column: []*Column{{OID: "x", Metric: "m"}}}}}, "table t: snmp: invalid object identifier"},
+	{&Config{Table: []*Table{{ID: "t", Column: []*Column{{OID: "1.3", Metric: "a b"}}}}}, "table t: column 1.3: invalid Metric"},
+	{&Config{Table: []*Table{{ID: "t", Column: []*Column{{OID: "1.3", Metric: "m"}}, Tag: []*Tag{{Name: "host"}}}}}, "table t: tag redeclared: host"},
+	{
+		&Config{Table: []*Table{
+			{ID: "t", Column: []*Column{{OID: "1.3", Metric: "m"}}},
+			{ID: "t", Column: []*Column{{OID: "1.3", Metric: "m"}}},
+		}},
+		"table redeclared: t",
+	},
+	{&Config{Target: []*Target{{Community: "public"}}}, "target: missing Host"},
+	{&Config{Target: []*Target{{Host: "sw1"}}}, "target sw1: missing Community"},
+	{&Config{Target: []*Target{{Host: "sw1", Version: "1"}}}, "target sw1: unsupported Version"},
+	{&Config{Target: []*Target{{Host: "sw1", Version: "3"}}}, "target sw1: missing User"},
+	{&Config{Target: []*Target{{Host: "sw1", Version: "3", User: &snmp.User{Name: "u", AuthProtocol: "SHA"}}}}, "target sw1: user u: AuthPassword too short"},
+	{&Config{Target: []*Target{{Host: "sw1", Community: "public", Table: []string{"if"}}}}, "target sw1: undefined table: if"},
+	{
+		&Config{Target: []*Target{
+			{Host: "sw1", Community: "public"},
+			{Host: "sw1", Community: "private"},
+		}},
+		"target redeclared: sw1",
+	},
+}
+
+func TestValidate(t *testing.T) {
+	for i, tt := range testValidate {
+		err := tt.in.Validate()
+		switch {
+		case err == nil && tt.err != "":
+			t.Errorf("#%d. unexpected success, want error: %v", i, tt.err)
+		case err != nil && tt.err == "":
+			t.Errorf("#%d. unexpected error: %v", i, err)
+		case err != nil && !strings.HasPrefix(err.Error(), tt.err):
+			t.Errorf("#%d. invalid error, got: %s, want: %s", i, err, tt.err)
+		}
+	}
+}
+
+func encode(t *testing.T, p *tsdb.Point) string {
+	var buf bytes.Buffer
+	if err := tsdb.NewEncoder(&buf).Encode(p); err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -39,6 +39,9 @@ type Client struct {
 	up       []*clientConn
 	upMu     sync.RWMutex
 	upHash   hash.Hash32
+	down     bool // guarded by upMu
+	quit     chan struct{}
+	quitOnce sync.Once
 }
 
 // NewClient returns a TSDB client. The client supervises internal connection pool.
@@ -52,11 +55,22 @@ func NewClient(hosts string, config *ClientConfig) *Client {
 		repeat:   newRepeatTester(),
 		config:   config,
 		upHash:   fnv.New32(),
+		quit:     make(chan struct{}),
 	}
 	go c.mainloop()
 	return c
 }
 
+// Close shuts down the client. The queued points are flushed to the
+// connections that are up, or dropped if none are. Put must not be called
+// after Close.
+func (c *Client) Close() {
+	c.quitOnce.Do(func() {
+		close(c.quit)
+		close(c.cmd)
+	})
+}
+
 // Put writes a data point to the server. It never blocks on I/O.
 func (c *Client) Put(point *Point) {
 	c.once.Do(func() {
@@ -112,12 +126,21 @@ func (c *Client) mainloop() {
 	var err error
 	for cmd = range c.cmd {
 		conn = c.lookupConn(cmd)
+		if conn == nil {
+			c.drop(cmd.Point())
+			cmd.Free()
+			continue
+		}
 		if err = conn.Put(cmd); err != nil {
 			c.error(conn, err)
 		}
 	}
+	c.shutdown()
 }
 
+// lookupConn returns the connection assigned to the given command. It waits
+// for a connection to come up, unless the client is closed, in which case
+// nil is returned.
 func (c *Client) lookupConn(cmd cmd) *clientConn {
 	var up *clientConn
 	for {
@@ -129,18 +152,49 @@ func (c *Client) lookupConn(cmd cmd) *clientConn {
 		if up != nil {
 			return up
 		}
-		time.Sleep(1 * time.Second)
+		select {
+		case <-c.quit:
+			return nil
+		case <-time.After(1 * time.Second):
+			// retry
+		}
+	}
+}
+
+// shutdown closes the connections once the queue is flushed.
+func (c *Client) shutdown() {
+	c.upMu.Lock()
+	up := c.up
+	c.up = nil
+	c.down = true
+	c.upMu.Unlock()
+	for _, conn := range up {
+		if err := conn.version(); err != nil {
+			c.drop(conn.Pending.Bytes())
+		}
+		conn.Close()
 	}
+	c.dialRate.Stop()
 }
 
 func (c *Client) dial(addr string) {
 	for {
-		<-c.dialRate.C
+		select {
+		case <-c.quit:
+			return
+		case <-c.dialRate.C:
+			// ok
+		}
 		conn, err := c.Dial(addr)
 		if err != nil {
 			continue
 		}
 		c.upMu.Lock()
+		if c.down {
+			c.upMu.Unlock()
+			conn.Close()
+			return
+		}
 		c.up = append(c.up, newClientConn(conn, addr))
 		c.upMu.Unlock()
 		break
@@ -71,6 +71,12 @@ func (f *Filter) Eval(point Point) (bool, error) {
 			}
 			return false, nil
 		}
+		if rule.Pass {
+			if Debug != nil {
+				Debug.Printf("    pass %v, rule=%v", point, rule)
+			}
+			return true, nil
+		}
 		if err := rule.Rewrite(point, submatch); err != nil {
 			if Debug != nil {
 				Debug.Printf("   rewriteError %v, rule=%v", point, rule)
@@ -246,17 +252,21 @@ func validateRules(rules ...Rule) error {
 	return nil
 }
 
-// Rule represents configuration of a single rule.
+// Rule represents configuration of a single rule. A matching point is
+// rewritten according to Set. If Block is true, the point is instead
+// rejected; if Pass is true, it is accepted without evaluating the remaining
+// rules.
 type Rule struct {
 	Match []string `json:",omitempty"`
 	Set   []string `json:",omitempty"`
 	Block bool     `json:",omitempty"`
+	Pass  bool     `json:",omitempty"`
 }
 
 var submatchRE = regexp.MustCompile(`\${[0-9]+}`)
 
 func (r Rule) validate() error {
-	if !r.Block {
+	if !r.Block && !r.Pass {
 		noop := false
 		switch {
 		case len(r.Set) == 3 && r.Set[0] == "" && r.Set[2] == "":
@@ -277,6 +287,10 @@ func (r Rule) validate() error {
 		return fmt.Errorf("Set and Block used together")
 	}
 
+	if r.Pass && (r.Block || len(r.Set) > 0) {
+		return fmt.Errorf("Pass used together with Set or Block")
+	}
+
 	if len(r.Match) > 1 && (len(r.Match)-1)%2 != 0 {
 		return fmt.Errorf("Match array has %d fields, expect %d or %d", len(r.Match),
 			len(r.Match)-1, len(r.Match)+1)
@@ -393,6 +407,10 @@ func (r Rule) String() string {
 		fmt.Fprintf(buf, "%sBlock:%v", sep, r.Block)
 		sep = " "
 	}
+	if r.Pass {
+		fmt.Fprintf(buf, "%sPass:%v", sep, r.Pass)
+		sep = " "
+	}
 	fmt.Fprintf(buf, "}")
 	return buf.String()
 }
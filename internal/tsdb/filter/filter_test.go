@@ -156,6 +156,32 @@ var testNew = []struct {
 			},
 		},
 	},
+	{ // pass rule
+		rules: []Rule{
+			{
+				Match: []string{"^proc\\."},
+				Pass:  true,
+			},
+		},
+	},
+	{ // pass and block used together
+		rules: []Rule{
+			{
+				Pass:  true,
+				Block: true,
+			},
+		},
+		err: true,
+	},
+	{ // pass and set used together
+		rules: []Rule{
+			{
+				Set:  []string{"foo"},
+				Pass: true,
+			},
+		},
+		err: true,
+	},
 }
 
 func TestNew(t *testing.T) {
@@ -288,6 +314,23 @@ var testEval = []struct {
 		out:  point("foo", "c", "c", "b", "B", "a", "a"),
 		pass: true,
 	},
+	10: { // pass rule skips the remaining rules
+		in: point("proc.loadavg"),
+		rules: []Rule{
+			{Match: []string{`^proc\.`}, Pass: true},
+			{Block: true},
+		},
+		out:  point("proc.loadavg"),
+		pass: true,
+	},
+	11: { // pass rule does not match
+		in: point("jvm.gc"),
+		rules: []Rule{
+			{Match: []string{`^proc\.`}, Pass: true},
+			{Block: true},
+		},
+		pass: false,
+	},
 }
 
 func TestEval(t *testing.T) {
@@ -321,6 +364,29 @@ func TestEval(t *testing.T) {
 	}
 }
 
+func TestSwitch(t *testing.T) {
+	ch := make(chan *tsdb.Point, 3)
+	ch <- point("foo")
+	ch <- point("bar")
+	ch <- point("baz")
+	s, err := NewSwitch([]Rule{{Match: []string{"foo"}, Block: true}}, tsdb.Chan(ch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.Next(), point("bar"); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := s.Set([]Rule{{}}); err == nil {
+		t.Errorf("unexpected success setting invalid rules")
+	}
+	if err := s.Set([]Rule{{Set: []string{"qux"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.Next(), point("qux"); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
 func BenchmarkEval(b *testing.B) {
 	b.ReportAllocs()
 	b.SetBytes(123) // roughly
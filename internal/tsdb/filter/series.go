@@ -2,6 +2,7 @@ package filter
 
 import (
 	"log"
+	"sync/atomic"
 
 	"opentsp.org/internal/tsdb"
 )
@@ -14,20 +15,28 @@ type series struct {
 func (s series) Next() *tsdb.Point {
 	for {
 		point := s.series.Next()
-		pass, err := s.filter.Eval(point)
-		if err != nil {
-			log.Printf("tsdb: filter error: %v", err)
-			point.Free()
-			continue
-		}
-		if !pass {
-			point.Free()
+		if pass := eval(s.filter, point); !pass {
 			continue
 		}
 		return point
 	}
 }
 
+// eval evaluates the given point, freeing it if it is not accepted.
+func eval(filter *Filter, point *tsdb.Point) bool {
+	pass, err := filter.Eval(point)
+	if err != nil {
+		log.Printf("tsdb: filter error: %v", err)
+		point.Free()
+		return false
+	}
+	if !pass {
+		point.Free()
+		return false
+	}
+	return true
+}
+
 // Series returns a filtered version of the given time series.
 func Series(rules []Rule, in tsdb.Series) tsdb.Series {
 	filter, err := New(rules...)
@@ -41,3 +50,42 @@ func Series(rules []Rule, in tsdb.Series) tsdb.Series {
 type emptySeries struct{}
 
 func (_ emptySeries) Next() *tsdb.Point { select {} }
+
+// Switch is a filtered time series whose rules may be replaced while it is
+// being read.
+type Switch struct {
+	series tsdb.Series
+	filter atomic.Value // *Filter
+}
+
+// NewSwitch returns a filtered version of the given time series.
+func NewSwitch(rules []Rule, in tsdb.Series) (*Switch, error) {
+	s := &Switch{series: in}
+	if err := s.Set(rules); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set replaces the filter rules. The new rules apply starting with the next
+// point read. An error is returned if the provided ruleset is invalid, in
+// which case the old rules remain in effect.
+func (s *Switch) Set(rules []Rule) error {
+	filter, err := New(rules...)
+	if err != nil {
+		return err
+	}
+	s.filter.Store(filter)
+	return nil
+}
+
+// Next implements the Next method of the tsdb.Series interface.
+func (s *Switch) Next() *tsdb.Point {
+	for {
+		point := s.series.Next()
+		if pass := eval(s.filter.Load().(*Filter), point); !pass {
+			continue
+		}
+		return point
+	}
+}
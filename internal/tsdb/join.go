@@ -1,18 +1,25 @@
 package tsdb
 
-type joined []Chan
+import "sync"
 
 // Join returns a time series that combines data points from the
-// given channels.
-func Join(a, b Chan) Series {
-	return joined{a, b}
-}
-
-func (ch joined) Next() *Point {
-	select {
-	case p := <-ch[0]:
-		return p
-	case p := <-ch[1]:
-		return p
+// given channels. A closed channel stops contributing points; once all
+// the channels are closed, so is the returned series.
+func Join(chans ...Chan) Series {
+	out := make(chan *Point)
+	var wg sync.WaitGroup
+	wg.Add(len(chans))
+	for _, ch := range chans {
+		go func(ch Chan) {
+			defer wg.Done()
+			for p := range ch {
+				out <- p
+			}
+		}(ch)
 	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return Chan(out)
 }
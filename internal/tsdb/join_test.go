@@ -0,0 +1,55 @@
+// Copyright 2014 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package tsdb
+
+import (
+	"testing"
+	"time"
+)
+
+var joinTests = []int{1, 2, 3, 4}
+
+func TestJoin(t *testing.T) {
+	for _, n := range joinTests {
+		var chans []Chan
+		for i := 0; i < n; i++ {
+			ch := make(chan *Point, 2)
+			ch <- xpoint(int64(1000000000+i), i)
+			ch <- xpoint(int64(1000000000+i), i)
+			close(ch)
+			chans = append(chans, ch)
+		}
+		series := Join(chans...)
+		seen := make(map[int64]int)
+		for i := 0; i < 2*n; i++ {
+			p := next(t, series)
+			if p == nil {
+				t.Fatalf("%d chans: unexpected end of series after %d points", n, i)
+			}
+			seen[p.Time().Unix()]++
+		}
+		for i := 0; i < n; i++ {
+			if got := seen[int64(1000000000+i)]; got != 2 {
+				t.Errorf("%d chans: got %d points of chan #%d, want 2", n, got, i)
+			}
+		}
+		// All sources closed, so the series must end rather than spin.
+		if p := next(t, series); p != nil {
+			t.Errorf("%d chans: got point after all chans closed: %v", n, p)
+		}
+	}
+}
+
+func next(t *testing.T, series Series) *Point {
+	done := make(chan *Point, 1)
+	go func() { done <- series.Next() }()
+	select {
+	case p := <-done:
+		return p
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout")
+		return nil
+	}
+}
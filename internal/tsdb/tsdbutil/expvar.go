@@ -7,7 +7,9 @@ package tsdbutil
 import (
 	"encoding/json"
 	"expvar"
+	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"time"
 
@@ -32,31 +34,19 @@ func ExportVars(t time.Time, fn func(*tsdb.Point)) {
 				log.Print(err)
 				return
 			}
-			for key, value := range data {
-				num, ok := value.(json.Number)
-				if !ok {
-					continue
-				}
-				n := num.String()
-				point, err := tsdb.NewPoint(t, n, "mem."+key)
-				if err != nil {
-					log.Printf("tsdbutil: ExportVars: cannot export memstats.%s: %v", key, err)
-					continue
-				}
-				fn(point)
+			for _, err := range exportMemstats(t, data, fn) {
+				log.Printf("tsdbutil: ExportVars: cannot export memstats.%v", err)
 			}
 
 		case *expvar.Int:
-			value := kv.Value.String()
-			point, err := tsdb.NewPoint(t, value, metric)
+			point, err := newVarPoint(t, kv.Value.String(), metric, "")
 			if err != nil {
 				log.Panic(err)
 			}
 			fn(point)
 
 		case *expvar.Float:
-			value := kv.Value.String()
-			point, err := tsdb.NewPoint(t, value, metric)
+			point, err := newVarPoint(t, kv.Value.String(), metric, "")
 			if err != nil {
 				log.Panic(err)
 			}
@@ -68,46 +58,106 @@ func ExportVars(t time.Time, fn func(*tsdb.Point)) {
 				default:
 					// log.Printf("tsdbutil: ExportVars: ignoring unsupported type %T for key %s of %s", v2, kv2.Key, kv.Key)
 
-				case expvar.Func:
-					tags := kv.Key
-					value := kv.Value
-					tags = strings.Replace(tags, "=", " ", -1)
-					point, err := tsdb.NewPoint(t, 0, metric, strings.Fields(tags)...)
-					if err != nil {
-						log.Panic(err)
-					}
-					if err := point.SetValue(value.String()); err != nil {
-						log.Panic(err)
-					}
-					fn(point)
-
-				case *expvar.Int:
-					tags := kv.Key
-					value := kv.Value
-					tags = strings.Replace(tags, "=", " ", -1)
-					point, err := tsdb.NewPoint(t, 0, metric, strings.Fields(tags)...)
-					if err != nil {
-						log.Panic(err)
-					}
-					if err := point.SetValue(value.String()); err != nil {
-						log.Panic(err)
-					}
-					fn(point)
-
-				case *expvar.Float:
-					tags := kv.Key
-					value := kv.Value
-					tags = strings.Replace(tags, "=", " ", -1)
-					point, err := tsdb.NewPoint(t, 0, metric, strings.Fields(tags)...)
+				case expvar.Func, *expvar.Int, *expvar.Float:
+					point, err := newVarPoint(t, kv.Value.String(), metric, kv.Key)
 					if err != nil {
 						log.Panic(err)
 					}
-					if err := point.SetValue(value.String()); err != nil {
-						log.Panic(err)
-					}
 					fn(point)
 				}
 			})
 		}
 	})
 }
+
+// ExportJSON is like ExportVars except it exports the variables of a remote
+// process, given the decoded output of its expvar handler (/debug/vars). The
+// numbers must be decoded as json.Number. Numbers are exported as series
+// named after the variable; objects as series tagged using the keys, which
+// are of the form "key=value key=value"; and the memstats object as the
+// "mem." series. Other values are ignored. Invalid variables are skipped,
+// and reported in the returned error.
+func ExportJSON(t time.Time, vars map[string]interface{}, fn func(*tsdb.Point)) error {
+	var errs []error
+	for _, metric := range sortedKeys(vars) {
+		switch v := vars[metric].(type) {
+		case json.Number:
+			point, err := newVarPoint(t, v.String(), metric, "")
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", metric, err))
+				continue
+			}
+			fn(point)
+
+		case map[string]interface{}:
+			if metric == "memstats" {
+				for _, err := range exportMemstats(t, v, fn) {
+					errs = append(errs, fmt.Errorf("memstats.%v", err))
+				}
+				continue
+			}
+			for _, tags := range sortedKeys(v) {
+				n, ok := v[tags].(json.Number)
+				if !ok {
+					continue
+				}
+				point, err := newVarPoint(t, n.String(), metric, tags)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %s: %v", metric, tags, err))
+					continue
+				}
+				fn(point)
+			}
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return fmt.Errorf("%v (and %d more errors)", errs[0], len(errs)-1)
+	}
+}
+
+// exportMemstats exports the numbers found in the given runtime.MemStats
+// object.
+func exportMemstats(t time.Time, data map[string]interface{}, fn func(*tsdb.Point)) []error {
+	var errs []error
+	for key, value := range data {
+		num, ok := value.(json.Number)
+		if !ok {
+			continue
+		}
+		point, err := tsdb.NewPoint(t, num.String(), "mem."+key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", key, err))
+			continue
+		}
+		fn(point)
+	}
+	return errs
+}
+
+// newVarPoint returns a point of the given variable. The tags are given in
+// the form used by expvar map keys, "key=value key=value".
+func newVarPoint(t time.Time, value, metric, tags string) (*tsdb.Point, error) {
+	tags = strings.Replace(tags, "=", " ", -1)
+	point, err := tsdb.NewPoint(t, 0, metric, strings.Fields(tags)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := point.SetValue(value); err != nil {
+		return nil, err
+	}
+	return point, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
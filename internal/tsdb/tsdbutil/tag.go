@@ -0,0 +1,23 @@
+// Copyright 2015 The Sporting Exchange Limited. All rights reserved.
+// Use of this source code is governed by a free license that can be
+// found in the LICENSE file.
+
+package tsdbutil
+
+// TagValue returns s with the characters that are invalid in tag values, or
+// in the values of expvar map keys of the form "key=value", replaced by
+// underscores.
+func TagValue(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		default:
+			b[i] = '_'
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			// ok
+		case c == '-', c == '_', c == '.', c == '/':
+			// ok
+		}
+	}
+	return string(b)
+}
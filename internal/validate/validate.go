@@ -2,16 +2,25 @@
 package validate
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
+	"path/filepath"
 
+	"opentsp.org/internal/collect"
+	"opentsp.org/internal/hoststat"
+	"opentsp.org/internal/httpstat"
+	"opentsp.org/internal/procstat"
 	"opentsp.org/internal/relay"
+	"opentsp.org/internal/snmpstat"
 	"opentsp.org/internal/tsdb/filter"
 )
 
 const (
-	maxRules  = 64
-	maxRelays = 8
+	maxRules   = 64
+	maxRelays  = 8
+	maxPlugins = 64
+	maxProcess = 64
 )
 
 func Filter(rules []filter.Rule) ([]filter.Rule, error) {
@@ -48,6 +57,98 @@ func Relay(configs map[string]*relay.Config) error {
 	return nil
 }
 
+func Collect(config *collect.Config) (*collect.Config, error) {
+	if config == nil {
+		config = new(collect.Config)
+	}
+	if err := config.Validate(); err != nil {
+		err := fmt.Errorf("invalid Collect setting: %v", err)
+		return nil, err
+	}
+	return config, nil
+}
+
+func HostStat(config *hoststat.Config) (*hoststat.Config, error) {
+	if config == nil {
+		config = new(hoststat.Config)
+	}
+	if err := config.Validate(); err != nil {
+		err := fmt.Errorf("invalid HostStat setting: %v", err)
+		return nil, err
+	}
+	return config, nil
+}
+
+func ProcStat(config *procstat.Config) (*procstat.Config, error) {
+	if config == nil {
+		config = new(procstat.Config)
+	}
+	if err := config.Validate(); err != nil {
+		err := fmt.Errorf("invalid ProcStat setting: %v", err)
+		return nil, err
+	}
+	return config, nil
+}
+
+func HTTP(config *httpstat.Config) (*httpstat.Config, error) {
+	if config == nil {
+		config = new(httpstat.Config)
+	}
+	if err := config.Validate(); err != nil {
+		err := fmt.Errorf("invalid HTTP setting: %v", err)
+		return nil, err
+	}
+	return config, nil
+}
+
+func SNMP(config *snmpstat.Config) (*snmpstat.Config, error) {
+	if config == nil {
+		config = new(snmpstat.Config)
+	}
+	if err := config.Validate(); err != nil {
+		err := fmt.Errorf("invalid SNMP setting: %v", err)
+		return nil, err
+	}
+	return config, nil
+}
+
+func Process(groups []*procstat.Group) error {
+	if n := len(groups); n > maxProcess {
+		err := fmt.Errorf("too many process groups defined: %d > %d", n, maxProcess)
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, g := range groups {
+		if g == nil {
+			return fmt.Errorf("invalid process group: null")
+		}
+		if err := g.Validate(); err != nil {
+			return err
+		}
+		if seen[g.ID] {
+			return fmt.Errorf("process group redeclared: %s", g.ID)
+		}
+		seen[g.ID] = true
+	}
+	return nil
+}
+
+func Plugin(configs map[string]json.RawMessage) error {
+	if n := len(configs); n > maxPlugins {
+		err := fmt.Errorf("too many plugins configured: %d > %d", n, maxPlugins)
+		return err
+	}
+	for name, config := range configs {
+		if name == "" || filepath.Base(name) != name {
+			return fmt.Errorf("invalid plugin name: %q", name)
+		}
+		if config == nil || string(config) == "null" {
+			return fmt.Errorf("plugin %s: missing config", name)
+		}
+	}
+	return nil
+}
+
 func ListenAddr(addr string) error {
 	if _, _, err := net.SplitHostPort(addr); err == nil {
 		return nil